@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptedrequest"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/recording"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const usage = `usage: ctl <command> [flags]
+
+commands:
+  export  write the event log of an Interceptor as JSON Lines or HAR
+  replay  replay a recording against a target URL
+
+Run "ctl <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// export writes the requests of the event log of an Interceptor since a version.
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "", "path to the Interceptor configuration, whose database holds the event log")
+	driver := flags.String("driver", "", "database/sql driver of the event log, overrides the configuration")
+	dsn := flags.String("dsn", "", "data source name of the event log, overrides the configuration")
+	fromVersion := flags.Int("from", 1, "version of the first exported request")
+	format := flags.String("format", "", `format of the recording, "jsonl" or "har", from the output extension by default`)
+	output := flags.String("output", "", "file to write the recording to, the standard output by default")
+	flags.Parse(args)
+
+	cfg, err := config(*configFile)
+	if err != nil {
+		return err
+	}
+	if cfg.RepositoryBackend == interceptorConfig.BackendSQL {
+		*driver, *dsn = or(*driver, cfg.DatabaseDriver), or(*dsn, cfg.DatabaseDSN)
+	}
+	if *driver == "" || *dsn == "" {
+		return errors.New("the database of the event log is required, the in-memory event log of an Interceptor can not be read by another process")
+	}
+	repository, err := interceptedRequestRepository(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	exported, err := recording.Export(w, or(*format, recording.FormatOf(*output)), repository, *fromVersion)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d requests\n", exported)
+	return nil
+}
+
+// replay replays a recording with the replay engine of the reprojections, and prints
+// the result.
+func replay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := flags.String("config", "", "path to an Interceptor configuration whose replay settings are used")
+	file := flags.String("file", "", "recording to replay")
+	format := flags.String("format", "", `format of the recording, "jsonl" or "har", from the file extension by default`)
+	target := flags.String("target", "", "URL the requests are replayed to")
+	fromVersion := flags.Int("from", 1, "version of the first replayed request")
+	timed := flags.Bool("timed", false, "replay with the original timing and concurrency")
+	speed := flags.Float64("speed", 1, "speed factor of timed replays")
+	rateLimit := flags.Float64("rate", 0, "maximum number of requests per second, unlimited when zero")
+	parallelism := flags.Int("parallelism", 1, "maximum number of commutative requests replayed at once")
+	retries := flags.Int("retries", 3, "number of retries of the requests the target was not ready for")
+	timeout := flags.Duration("timeout", 0, "timeout of each attempt to replay a request")
+	flags.Parse(args)
+
+	if *file == "" || *target == "" {
+		return errors.New("-file and -target are required")
+	}
+	cfg, err := config(*configFile)
+	if err != nil {
+		return err
+	}
+	// The flags set explicitly override the replay settings of the configuration.
+	replayConfig := cfg.Replay
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "timed":
+			replayConfig.Timed = *timed
+		case "speed":
+			replayConfig.Speed = *speed
+		case "rate":
+			replayConfig.RateLimit = *rateLimit
+		case "parallelism":
+			replayConfig.Parallelism = *parallelism
+		case "retries":
+			replayConfig.Retries = *retries
+		case "timeout":
+			replayConfig.Timeout = *timeout
+		}
+	})
+
+	recorded, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer recorded.Close()
+	requests, err := recording.Import(recorded, or(*format, recording.FormatOf(*file)))
+	if err != nil {
+		return err
+	}
+	var replayed []*entity.InterceptedRequest
+	for _, req := range requests {
+		if req.Version >= *fromVersion {
+			replayed = append(replayed, req)
+		}
+	}
+
+	result, replayErr := usecase.Replay(ctx, *target, replayed, usecase.ReplayOptions{
+		RateLimit:    replayConfig.RateLimit,
+		Parallelism:  replayConfig.Parallelism,
+		Timeout:      replayConfig.Timeout,
+		Retries:      replayConfig.Retries,
+		RetryBackoff: replayConfig.RetryBackoff,
+		Timed:        replayConfig.Timed,
+		Speed:        replayConfig.Speed,
+		Commutative: func(req *entity.InterceptedRequest) bool {
+			return interceptorConfig.Commutative(cfg.Routes, req.Request)
+		},
+	})
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return err
+	}
+	return replayErr
+}
+
+// config reads the Interceptor configuration, the defaults when there is no file.
+// It is not validated, only the settings of the event log and the replays are used.
+func config(filename string) (*interceptorConfig.Config, error) {
+	if filename == "" {
+		return interceptorConfig.FromYAML(nil)
+	}
+	return interceptorConfig.FromYAMLFile(filename)
+}
+
+func interceptedRequestRepository(driver string, dsn string) (entity.InterceptedRequestRepository, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return interceptedrequest.SQL(db), nil
+}
+
+func or(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,57 +1,255 @@
 package main
 
 import (
-	"net/url"
-	"time"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/auth"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptedrequest"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/reprojectionprogress"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/checkpoint"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/configwatcher"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/pidresolver"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/restore"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/scheduler"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/statemanager"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/statemanager/client"
 	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
+	configFile := flag.String("config", "", "path to the YAML configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flagValues := loader.Flags(flag.CommandLine, interceptorConfig.Keys())
+	flag.Parse()
+
+	cfg, err := interceptorConfig.Load(*configFile, os.LookupEnv, flagValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if *printConfig {
+		content, err := cfg.YAML()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(content)
+		return
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, "interceptor", cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	monitoredContainerID := uuid.NewString()
 	interceptor := entity.Interceptor{
 		ID:                    uuid.NewString(),
 		MonitoringContainerID: monitoredContainerID,
 		MonitoredContainer: &entity.Container{
-			ID:      monitoredContainerID,
-			PID:     272389,
-			HTTPUrl: "http://localhost:8000",
-			Name:    "test",
-		},
-		Config: &interceptor.Config{
-			CheckpointingInterval: time.Duration(time.Minute * 20),
-			ContainerURL:          url.URL{},
-			ContainerPID:          272389,
-			ContainerName:         "test",
-			StateManagerURL:       url.URL{},
+			ID:        monitoredContainerID,
+			PID:       cfg.ContainerPID,
+			HTTPUrl:   cfg.ContainerURL.String(),
+			Name:      cfg.ContainerName,
+			Namespace: cfg.PodNamespace,
+			Pod:       cfg.PodName,
 		},
+		Config:   cfg,
+		AdminURL: cfg.AdminURL,
 	}
-	checkpointService, err := checkpoint.CRIU(checkpoint.CRIUCheckpointServiceConfig{
-		ImagesDirectory: "/home/gian/test-images",
-	})
+
+	pidResolver := pidResolver(cfg)
+	pid, err := pidResolver.ResolvePID(interceptor.MonitoredContainer)
+	if err != nil {
+		slog.Warn("could not resolve container PID yet", logging.KeyContainer, cfg.ContainerName, logging.Error(err))
+	} else {
+		interceptor.MonitoredContainer.PID = pid
+	}
+
+	checkpointService, err := checkpointService(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	checkpointService = checkpoint.ResolvingPID(checkpointService, pidResolver)
+	stateManagerService, err := stateManagerService(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	interceptedRequestRepository, closeRepository, err := interceptedRequestRepository(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeRepository()
+
+	scheduler, err := scheduler.Local(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	restoreService, err := restoreService(cfg)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	imageStore := checkpoint.Directory(cfg.ImagesDirectory)
+	progressRepository := reprojectionprogress.InMemory()
+	if cfg.Replay.ProgressFile != "" {
+		progressRepository = reprojectionprogress.File(cfg.Replay.ProgressFile)
 	}
-	scheduler := scheduler.Local()
-	stateManagerService := statemanager.AlawaysAcceptingStub()
-	interceptedRequestRepository := interceptedrequest.InMemory()
-	interceptorUseCase, err := usecase.Interceptor(&interceptor, checkpointService, stateManagerService, interceptedRequestRepository, scheduler)
+
+	interceptorUseCase, err := usecase.Interceptor(&interceptor, checkpointService, stateManagerService, interceptedRequestRepository, scheduler, restoreService, imageStore, progressRepository)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 
-	go func(interceptorUseCase usecase.InterceptorUseCase) {
-		scheduler.ScheduleCheckpoint(interceptorUseCase, interceptor.Config.CheckpointingInterval)
-	}(interceptorUseCase)
+	go scheduler.Run(ctx, interceptorUseCase)
+
+	if err := interceptorUseCase.Register(ctx); err != nil {
+		slog.Warn("could not register in the state manager", logging.KeyContainer, interceptor.MonitoredContainer.Identity().String(), logging.Error(err))
+	}
+	if cfg.HeartbeatInterval > 0 {
+		go interceptorUseCase.RunHeartbeats(ctx, cfg.HeartbeatInterval)
+	}
 
-	interceptorServer := delivery.InterceptorServer(8001, interceptorUseCase)
-	interceptorServer.Run()
+	if cfg.WatchConfig && *configFile != "" {
+		watcher, err := configwatcher.File(*configFile, cfg.WatchInterval, func([]byte) error {
+			updated, err := interceptorConfig.Load(*configFile, os.LookupEnv, flagValues)
+			if err != nil {
+				return err
+			}
+			slog.Info("reloading configuration", "file", *configFile)
+			return interceptorUseCase.UpdateConfig(updated)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		go watcher.Run(ctx)
+	}
+
+	security, err := delivery.NewSecurity(cfg.Security)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	interceptorServer := delivery.InterceptorServer(cfg.Port, interceptorUseCase)
+	interceptorServer.AdminPort = cfg.AdminPort
+	interceptorServer.RetryAfter = cfg.Holding.RetryAfter
+	interceptorServer.Security = security
+	if err := interceptorServer.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("interceptor stopped")
+}
+
+func checkpointService(cfg *interceptorConfig.Config) (entity.CheckpointService, error) {
+	if cfg.CheckpointBackend == interceptorConfig.BackendStub {
+		return checkpoint.Stub(), nil
+	}
+	return checkpoint.CRIU(checkpoint.CRIUCheckpointServiceConfig{
+		ImagesDirectory: cfg.ImagesDirectory,
+	})
+}
+
+// restoreService restores the checkpoints migrated to the node of the Interceptor.
+func restoreService(cfg *interceptorConfig.Config) (entity.RestoreService, error) {
+	if cfg.CheckpointBackend == interceptorConfig.BackendStub {
+		return restore.AlwaysAcceptStub(), nil
+	}
+	return restore.CRIU(restore.CriuRestoreServiceConfig{
+		ImagesDirectory: cfg.ImagesDirectory,
+	})
+}
+
+func pidResolver(cfg *interceptorConfig.Config) entity.PIDResolver {
+	switch cfg.PIDResolver {
+	case interceptorConfig.PIDResolverCRI:
+		return pidresolver.CRI(pidresolver.CRIConfig{
+			Socket:        cfg.CRISocket,
+			ContainerID:   cfg.ContainerID,
+			ContainerName: cfg.ContainerName,
+		})
+	case interceptorConfig.PIDResolverCGroup:
+		path := cfg.CGroupPath
+		if path == "" {
+			path = cfg.ContainerID
+		}
+		return pidresolver.CGroup(pidresolver.CGroupConfig{ProcRoot: cfg.ProcRoot, Path: path})
+	case interceptorConfig.PIDResolverProcess:
+		return pidresolver.ProcessName(pidresolver.ProcessNameConfig{ProcRoot: cfg.ProcRoot, Name: cfg.ProcessName})
+	default:
+		return pidresolver.Static(cfg.ContainerPID)
+	}
+}
+
+func stateManagerService(ctx context.Context, cfg *interceptorConfig.Config) (entity.StateManagerService, error) {
+	if cfg.StateManagerTransport == interceptorConfig.BackendStub {
+		return statemanager.AlawaysAcceptingStub(), nil
+	}
+
+	clientConfig := client.DefaultConfig()
+	clientConfig.Timeout = cfg.StateManagerTimeout
+	clientConfig.MaxRetries = cfg.StateManagerMaxRetries
+	clientConfig.TokenFile = cfg.Security.ServiceAccountTokenFile
+	if cfg.StateManagerURL.Scheme == "https" {
+		tlsConfig, err := auth.ClientTLSConfig(cfg.Security.CertFile, cfg.Security.KeyFile, cfg.Security.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.TLSConfig = tlsConfig
+	}
+
+	var service entity.StateManagerService = statemanager.HTTPWithConfig(cfg.StateManagerURL.String(), clientConfig)
+	if cfg.OutboxDirectory == "" {
+		return service, nil
+	}
+
+	outbox, err := statemanager.Outbox(service, statemanager.OutboxConfig{
+		Directory:     cfg.OutboxDirectory,
+		FlushInterval: cfg.OutboxFlushInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go outbox.Run(ctx)
+	return outbox, nil
+}
+
+// interceptedRequestRepository returns the repository of the configuration with the
+// function closing its database.
+func interceptedRequestRepository(cfg *interceptorConfig.Config) (entity.InterceptedRequestRepository, func() error, error) {
+	if cfg.RepositoryBackend == interceptorConfig.BackendSQL {
+		db, err := sql.Open(cfg.DatabaseDriver, cfg.DatabaseDSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := interceptedrequest.MigrateSQL(context.Background(), db); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return interceptedrequest.SQL(db), db.Close, nil
+	}
+	return interceptedrequest.InMemory(), func() error { return nil }, nil
 }
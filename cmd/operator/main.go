@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
+	operatorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/operator"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/auth"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/operator"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/statemanager/client"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func main() {
+	configFile := flag.String("config", "", "path to the YAML configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flagValues := loader.Flags(flag.CommandLine, operatorConfig.Keys())
+	flag.Parse()
+
+	cfg, err := operatorConfig.Load(*configFile, os.LookupEnv, flagValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if *printConfig {
+		content, err := cfg.YAML()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(content)
+		return
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	restConfig, err := kubernetesConfig(cfg.Kubeconfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stateManager, err := stateManagerClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	op := operator.Operator(dynamicClient, stateManager, operator.Config{
+		Namespace:      cfg.Namespace,
+		ResyncInterval: cfg.ResyncInterval,
+		Workers:        cfg.Workers,
+	})
+	if err := op.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// kubernetesConfig loads the kubeconfig file, or the in-cluster configuration when
+// there is none.
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+// stateManagerClient calls the State Manager without retries, since restores are not
+// idempotent.
+func stateManagerClient(cfg *operatorConfig.Config) (*client.Client, error) {
+	clientConfig := client.DefaultConfig()
+	clientConfig.Timeout = cfg.RestoreTimeout
+	clientConfig.MaxRetries = 0
+	clientConfig.CircuitBreakerThreshold = 0
+	clientConfig.TokenFile = cfg.Security.ServiceAccountTokenFile
+	if strings.HasPrefix(cfg.StateManagerURL, "https://") {
+		tlsConfig, err := auth.ClientTLSConfig(cfg.Security.CertFile, cfg.Security.KeyFile, cfg.Security.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.TLSConfig = tlsConfig
+	}
+	return client.NewWithConfig(cfg.StateManagerURL, clientConfig), nil
+}
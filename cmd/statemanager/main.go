@@ -1,32 +1,236 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/statemanager"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/auth"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/container"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/containermetadata"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/election"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/migration"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/reprojection"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/restore"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
 	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	client "go.etcd.io/etcd/client/v3"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
-	containerMetadataRepository := containermetadata.InMemory()
-	restoreService, err := restore.CRIU(restore.CriuRestoreServiceConfig{
-		ImagesDirectory: "/home/gian/test-images",
+	configFile := flag.String("config", "", "path to the YAML configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flagValues := loader.Flags(flag.CommandLine, statemanager.Keys())
+	flag.Parse()
+
+	cfg, err := statemanager.Load(*configFile, os.LookupEnv, flagValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if *printConfig {
+		content, err := cfg.YAML()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(content)
+		return
+	}
+
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "statemanager", cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	etcdClient, err := etcdClient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	containerMetadataRepository, err := containerMetadataRepository(cfg, etcdClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	restoreService, err := restoreService(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Registrations are kept in memory, Interceptors register again when they start or
+	// when their heartbeats are rejected.
+	containerRepository := container.InMemory()
+	if cfg.Container.Name != "" {
+		containerID := cfg.Container.ID
+		if containerID == "" {
+			containerID = uuid.NewString()
+		}
+		err := containerRepository.Create(&entity.Container{
+			ID:        containerID,
+			PID:       cfg.Container.PID,
+			HTTPUrl:   cfg.Container.HTTPUrl,
+			Name:      cfg.Container.Name,
+			Namespace: cfg.Container.Namespace,
+			Pod:       cfg.Container.Pod,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	interceptorClientTLSConfig, err := interceptorClientTLSConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reprojectionService := reprojection.HTTP(reprojection.HTTPConfig{
+		Timeout:   cfg.ReprojectionTimeout,
+		TLSConfig: interceptorClientTLSConfig,
+		TokenFile: cfg.Security.ServiceAccountTokenFile,
+	})
+	migrationService := migration.HTTP(migration.HTTPConfig{
+		Timeout:   cfg.MigrationTimeout,
+		TLSConfig: interceptorClientTLSConfig,
+		TokenFile: cfg.Security.ServiceAccountTokenFile,
 	})
+
+	stateManagerUseCase, err := usecase.StateManager(containerMetadataRepository, containerRepository, interceptor.InMemory(), restoreService, reprojectionService, migrationService)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+
+	elector := election.Single(cfg.LeaderElection.AdvertiseURL)
+	if cfg.LeaderElection.Enabled {
+		elector = election.ETCD(etcdClient, election.ETCDConfig{
+			Prefix: cfg.LeaderElection.Prefix,
+			TTL:    cfg.LeaderElection.TTL,
+			URL:    cfg.LeaderElection.AdvertiseURL,
+		})
 	}
-	stateManagerUseCase, err := usecase.StateManager(containerMetadataRepository, restoreService, &entity.Container{
-		ID:      uuid.NewString(),
-		PID:     1,
-		HTTPUrl: "http://localhost:8000",
-		Name:    "test",
+	go elector.Run(context.Background(), func(ctx context.Context) {
+		stateManagerUseCase.MonitorInterceptors(ctx, cfg.HeartbeatTimeout)
 	})
+
+	security, err := delivery.NewSecurity(cfg.Security)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stateManagerServer := delivery.StateManager(cfg.Port, stateManagerUseCase, *cfg)
+	stateManagerServer.Security = security
+	stateManagerServer.Elector = elector
+	stateManagerServer.LeaderTransport, err = leaderTransport(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(stateManagerServer.Run())
+}
+
+// etcdClient connects to etcd when it is the repository backend, it is shared with
+// the leader election.
+func etcdClient(cfg *statemanager.StateManagerConfig) (*client.Client, error) {
+	if cfg.RepositoryBackend != statemanager.BackendETCD {
+		return nil, nil
+	}
+	return client.New(client.Config{
+		Endpoints:   cfg.ETCD.Endpoints,
+		DialTimeout: cfg.ETCD.DialTimeout,
+	})
+}
+
+func containerMetadataRepository(cfg *statemanager.StateManagerConfig, etcdClient *client.Client) (usecase.ContainerMetadataRepository, error) {
+	switch cfg.RepositoryBackend {
+	case statemanager.BackendETCD:
+		return containermetadata.ETCDWithConfig(etcdClient, containermetadata.ETCDConfig{
+			Prefix:  cfg.ETCD.Prefix,
+			Timeout: cfg.ETCD.RequestTimeout,
+		}), nil
+	case statemanager.BackendSQL:
+		db, err := sql.Open(cfg.SQL.Driver, cfg.SQL.DSN)
+		if err != nil {
+			return nil, err
+		}
+		if err := containermetadata.MigrateSQL(context.Background(), db); err != nil {
+			return nil, err
+		}
+		return containermetadata.SQL(db), nil
+	case statemanager.BackendKubernetes:
+		restConfig, err := kubernetesConfig(cfg.Kubernetes.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, err
+		}
+		return containermetadata.Kubernetes(dynamicClient, containermetadata.KubernetesConfig{
+			ImagesDirectory: cfg.ImagesDirectory,
+			Timeout:         cfg.Kubernetes.RequestTimeout,
+		}), nil
+	default:
+		return containermetadata.InMemory(), nil
+	}
+}
+
+// kubernetesConfig loads the kubeconfig file, or the in-cluster configuration when
+// there is none.
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+// interceptorClientTLSConfig is the TLS configuration reaching the admin API of the
+// Interceptors with the certificate of the State Manager, nil without certificate.
+func interceptorClientTLSConfig(cfg *statemanager.StateManagerConfig) (*tls.Config, error) {
+	if cfg.Security.CertFile == "" {
+		return nil, nil
+	}
+	return auth.ClientTLSConfig(cfg.Security.CertFile, cfg.Security.KeyFile, cfg.Security.CAFile)
+}
+
+// leaderTransport is the transport of the requests forwarded to the leader, which
+// presents the certificate of this replica when the replicas use TLS.
+func leaderTransport(cfg *statemanager.StateManagerConfig) (http.RoundTripper, error) {
+	if !strings.HasPrefix(cfg.LeaderElection.AdvertiseURL, "https://") {
+		return http.DefaultTransport, nil
+	}
+	tlsConfig, err := auth.ClientTLSConfig(cfg.Security.CertFile, cfg.Security.KeyFile, cfg.Security.CAFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func restoreService(cfg *statemanager.StateManagerConfig) (entity.RestoreService, error) {
+	if cfg.RestoreBackend == statemanager.BackendStub {
+		return restore.AlwaysAcceptStub(), nil
 	}
-	stateManagerServer := delivery.StateManager(8002, stateManagerUseCase, statemanager.StateManagerConfig{DevelopmentFeaturesEnabled: true})
-	stateManagerServer.Run()
+	return restore.CRIU(restore.CriuRestoreServiceConfig{
+		ImagesDirectory: cfg.ImagesDirectory,
+	})
 }
@@ -1,13 +1,215 @@
 package interceptor
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/security"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/tracing"
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v2"
 )
 
+// EnvPrefix is the prefix of the environment variables overriding the configuration.
+const EnvPrefix = "INTERCEPTOR"
+
+// Backends of the Interceptor services and repositories.
+const (
+	BackendCRIU     = "criu"
+	BackendStub     = "stub"
+	BackendInMemory = "inmemory"
+	BackendSQL      = "sql"
+	BackendHTTP     = "http"
+)
+
+// Resolvers of the monitored container PID.
+const (
+	PIDResolverStatic  = "static"
+	PIDResolverCRI     = "cri"
+	PIDResolverCGroup  = "cgroup"
+	PIDResolverProcess = "process"
+)
+
+// BlackoutWindow is a recurring period in which no checkpoint is made, like during
+// batch jobs.
+type BlackoutWindow struct {
+	// Start is the cron expression of when the window starts.
+	Start string `yaml:"start"`
+	// Duration is how long the window lasts.
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Actions of the route rules on the intercepted requests.
+const (
+	// RouteRecord records the request in the event log to replay it.
+	RouteRecord = "record"
+	// RouteRecordNoReplay records the request in the event log but never replays it.
+	RouteRecordNoReplay = "record-no-replay"
+	// RoutePassthrough forwards the request without recording it nor giving it a
+	// version, like health checks and metrics scrapes.
+	RoutePassthrough = "passthrough"
+)
+
+// RouteRule decides what the Interceptor does with the requests it matches. A request
+// matches when it matches every condition set.
+type RouteRule struct {
+	// Methods are the methods of the requests, any method when empty.
+	Methods []string `yaml:"methods"`
+	// Path is a pattern of the request paths in the syntax of path.Match, where a
+	// trailing "/**" matches every path below. Any path when empty.
+	Path string `yaml:"path"`
+	// Headers are the values the request headers must have.
+	Headers map[string]string `yaml:"headers"`
+	// Action is either "record", the default, "record-no-replay" or "passthrough".
+	Action string `yaml:"action"`
+	// Replay is the replay policy of the recorded requests, either "always", "never"
+	// or "idempotent". The default policy of the replays when empty.
+	Replay string `yaml:"replay"`
+	// Commutative marks the requests as independent of each other, so consecutive ones
+	// are replayed in parallel.
+	Commutative bool `yaml:"commutative"`
+}
+
+// Matches tells whether the request matches the rule.
+func (rule RouteRule) Matches(req *http.Request) bool {
+	if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, req.Method) {
+		return false
+	}
+	if rule.Path != "" && !matchPath(rule.Path, req.URL.Path) {
+		return false
+	}
+	for name, value := range rule.Headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPath(pattern string, requestPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+	}
+	matched, _ := path.Match(pattern, requestPath)
+	return matched
+}
+
+// MatchRoute returns the first rule matching the request.
+func MatchRoute(rules []RouteRule, req *http.Request) (RouteRule, bool) {
+	for _, rule := range rules {
+		if rule.Matches(req) {
+			return rule, true
+		}
+	}
+	return RouteRule{}, false
+}
+
+// RouteAction returns the action of the first rule matching the request, "record"
+// when none does.
+func RouteAction(rules []RouteRule, req *http.Request) string {
+	if rule, ok := MatchRoute(rules, req); ok && rule.Action != "" {
+		return rule.Action
+	}
+	return RouteRecord
+}
+
+// ReplayPolicy returns the replay policy of the first rule matching the request,
+// "never" when its requests are recorded without replay and the default policy when
+// the rule has none.
+func ReplayPolicy(rules []RouteRule, defaultPolicy string, req *http.Request) string {
+	rule, ok := MatchRoute(rules, req)
+	switch {
+	case !ok:
+		return defaultPolicy
+	case rule.Action == RouteRecordNoReplay:
+		return ReplayNever
+	case rule.Replay != "":
+		return rule.Replay
+	}
+	return defaultPolicy
+}
+
+// Commutative tells whether the first rule matching the request marks it commutative,
+// so it can be replayed in parallel.
+func Commutative(rules []RouteRule, req *http.Request) bool {
+	rule, ok := MatchRoute(rules, req)
+	return ok && rule.Commutative
+}
+
+// Replay policies of the routes.
+const (
+	// ReplayAlways replays every request of the route.
+	ReplayAlways = "always"
+	// ReplayNever never replays the requests of the route.
+	ReplayNever = "never"
+	// ReplayIdempotent only replays the requests of the route with an idempotent
+	// method.
+	ReplayIdempotent = "idempotent"
+)
+
+// ReplayConfig is the configuration of the replay of the intercepted requests.
+type ReplayConfig struct {
+	// SkipApplied skips the requests at or below the version the restored checkpoint
+	// recorded as applied, even when the reprojection starts before it.
+	SkipApplied bool `yaml:"skipApplied"`
+	// DefaultPolicy is the policy of the requests whose route rule sets none.
+	DefaultPolicy string `yaml:"defaultPolicy"`
+	// RateLimit is the maximum number of requests replayed per second, unlimited when
+	// zero.
+	RateLimit float64 `yaml:"rateLimit"`
+	// Parallelism is the maximum number of commutative requests replayed at once.
+	Parallelism int `yaml:"parallelism"`
+	// Timeout bounds each attempt to replay a request, unbounded when zero.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of times a request is replayed again when the container
+	// could not be reached or answered 502, 503 or 504.
+	Retries int `yaml:"retries"`
+	// RetryBackoff is the delay before the first retry, doubled on each of the next.
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+	// Timed replays the requests with their original inter-arrival timing, and only
+	// the requests originally processed concurrently in parallel, instead of replaying
+	// them back to back. Parallelism and the commutative route rules do not apply.
+	Timed bool `yaml:"timed"`
+	// Speed divides the original delays between the requests of timed replays, 2
+	// replays them twice as fast.
+	Speed float64 `yaml:"speed"`
+	// ProgressFile is the file recording the progress of the reprojections, so one
+	// interrupted by a crash resumes after the last replayed version. The progress is
+	// only kept in memory when empty.
+	ProgressFile string `yaml:"progressFile"`
+}
+
+func validReplayPolicy(policy string) bool {
+	return policy == ReplayAlways || policy == ReplayNever || policy == ReplayIdempotent
+}
+
+// HoldingConfig bounds the requests buffered while the monitored container is
+// restored, which are forwarded once the reprojection completes.
+type HoldingConfig struct {
+	// MaxRequests is the maximum number of requests held at once, zero disables
+	// holding and the requests are forwarded during restores.
+	MaxRequests int `yaml:"maxRequests"`
+	// MaxBytes is the maximum size of the bodies of the requests held at once.
+	MaxBytes int64 `yaml:"maxBytes"`
+	// MaxWait is how long a request is held before it is answered with 503.
+	MaxWait time.Duration `yaml:"maxWait"`
+	// Timeout ends a hold the State Manager did not release, like when it failed
+	// during the restore.
+	Timeout time.Duration `yaml:"timeout"`
+	// RetryAfter is the delay sent in the Retry-After header of the requests refused
+	// while holding.
+	RetryAfter time.Duration `yaml:"retryAfter"`
+}
+
 // Config is the configuration of the Interceptor.
 type Config struct {
 	// CheckpointingInterval is the interval between each checkpoint the Interceptor
@@ -19,8 +221,234 @@ type Config struct {
 	ContainerPID int32
 	// ContainerName the name of the monitored container.
 	ContainerName string
+	// PodNamespace the Kubernetes namespace of the pod of the monitored container.
+	PodNamespace string
+	// PodName the name of the pod of the monitored container, the hostname by default.
+	PodName string
+	// AdminURL the URL the State Manager reaches the admin API at, sent when
+	// registering.
+	AdminURL string
 	// StateManagerURL the url to use to communicate with the State Manager API.
 	StateManagerURL url.URL
+	// Security is the TLS and authentication configuration of the Interceptor admin API
+	// and of the calls to the State Manager.
+	Security security.Config
+	// Port the port the Interceptor receives the traffic of the monitored container.
+	Port int
+	// HeartbeatInterval the interval between the heartbeats sent to the State Manager,
+	// zero disables them.
+	HeartbeatInterval time.Duration
+	// AdminPort the port of the Interceptor admin API, metrics and health probes, zero
+	// disables it.
+	AdminPort int
+	// ImagesDirectory the directory to store checkpoint images.
+	ImagesDirectory string
+	// CheckpointRetention the number of checkpoint images of the monitored container
+	// kept in ImagesDirectory, zero keeps them all.
+	CheckpointRetention int
+	// CheckpointBackend the checkpoint service to use, either "criu" or "stub".
+	CheckpointBackend string
+	// RepositoryBackend the intercepted requests repository to use, either "inmemory"
+	// or "sql".
+	RepositoryBackend string
+	// DatabaseDriver the database/sql driver of the "sql" repository.
+	DatabaseDriver string
+	// DatabaseDSN the data source name of the "sql" repository.
+	DatabaseDSN string
+	// StateManagerTransport the transport to the State Manager, either "http" or "stub".
+	StateManagerTransport string
+	// StateManagerTimeout the timeout of each request to the State Manager.
+	StateManagerTimeout time.Duration
+	// StateManagerMaxRetries the number of retries of failed requests to the State
+	// Manager.
+	StateManagerMaxRetries int
+	// OutboxDirectory the directory to queue metadata while the State Manager is
+	// unreachable, empty disables the outbox.
+	OutboxDirectory string
+	// OutboxFlushInterval the interval between attempts to send the queued metadata.
+	OutboxFlushInterval time.Duration
+	// PIDResolver how to resolve the monitored container PID, either "static" to use
+	// ContainerPID, "cri", "cgroup" or "process".
+	PIDResolver string
+	// ContainerID the runtime ID of the monitored container, used by the "cri" and
+	// "cgroup" resolvers.
+	ContainerID string
+	// CRISocket the CRI runtime endpoint of the "cri" resolver.
+	CRISocket string
+	// CGroupPath the cgroup path of the "cgroup" resolver, ContainerID when empty.
+	CGroupPath string
+	// ProcessName the process name of the "process" resolver.
+	ProcessName string
+	// ProcRoot the mount point of the proc filesystem.
+	ProcRoot string
+	// MaxBodyCaptureBytes the maximum size of a request body captured for replay,
+	// larger bodies are forwarded but recorded truncated. Zero disables body capture.
+	MaxBodyCaptureBytes int64
+	// WatchConfig enables reloading the configuration file when it changes.
+	WatchConfig bool
+	// WatchInterval the interval between checks for changes of the configuration file.
+	WatchInterval time.Duration
+	// CheckpointEveryRequests makes a checkpoint every given number of requests, zero
+	// disables it.
+	CheckpointEveryRequests int
+	// CheckpointWriteBurstRequests makes a checkpoint after a burst of at least the
+	// given number of write requests, zero disables it.
+	CheckpointWriteBurstRequests int
+	// CheckpointWriteBurstQuietPeriod the period without writes that ends a burst.
+	CheckpointWriteBurstQuietPeriod time.Duration
+	// CheckpointEventLogBudgetBytes makes a checkpoint once the event log since the last
+	// one exceeds the given size, zero disables it.
+	CheckpointEventLogBudgetBytes int64
+	// CheckpointCron makes checkpoints at the times of the cron expression, empty
+	// disables it.
+	CheckpointCron string
+	// BlackoutWindows the windows in which checkpoints are suppressed.
+	BlackoutWindows []BlackoutWindow
+	// Holding the buffering of the requests received during restores.
+	Holding HoldingConfig
+	// Routes the rules deciding which requests are recorded and replayed, the first
+	// matching rule applies.
+	Routes []RouteRule
+	// Replay the replay of the intercepted requests by reprojections.
+	Replay ReplayConfig
+	// Tracing the export of the trace spans.
+	Tracing tracing.Config
+	// Logging the level and format of the logs.
+	Logging logging.Config
+}
+
+// configYAML is the representation of the Config in YAML, environment variables and
+// flags.
+type configYAML struct {
+	CheckpointingInterval  string          `yaml:"checkpointingInterval"`
+	ContainerURL           string          `yaml:"containerURL"`
+	ContainerPID           int             `yaml:"containerPID"`
+	ContainerName          string          `yaml:"containerName"`
+	PodNamespace           string          `yaml:"podNamespace"`
+	PodName                string          `yaml:"podName"`
+	AdminURL               string          `yaml:"adminURL"`
+	StateManagerURL        string          `yaml:"stateManagerURL"`
+	Security               security.Config `yaml:"security"`
+	Port                   int             `yaml:"port"`
+	HeartbeatInterval      string          `yaml:"heartbeatInterval"`
+	AdminPort              int             `yaml:"adminPort"`
+	ImagesDirectory        string          `yaml:"imagesDirectory"`
+	CheckpointRetention    int             `yaml:"checkpointRetention"`
+	CheckpointBackend      string          `yaml:"checkpointBackend"`
+	RepositoryBackend      string          `yaml:"repositoryBackend"`
+	DatabaseDriver         string          `yaml:"databaseDriver"`
+	DatabaseDSN            string          `yaml:"databaseDSN"`
+	StateManagerTransport  string          `yaml:"stateManagerTransport"`
+	StateManagerTimeout    string          `yaml:"stateManagerTimeout"`
+	StateManagerMaxRetries int             `yaml:"stateManagerMaxRetries"`
+	OutboxDirectory        string          `yaml:"outboxDirectory"`
+	OutboxFlushInterval    string          `yaml:"outboxFlushInterval"`
+	PIDResolver            string          `yaml:"pidResolver"`
+	ContainerID            string          `yaml:"containerID"`
+	CRISocket              string          `yaml:"criSocket"`
+	CGroupPath             string          `yaml:"cgroupPath"`
+	ProcessName            string          `yaml:"processName"`
+	ProcRoot               string          `yaml:"procRoot"`
+	MaxBodyCaptureBytes    int64           `yaml:"maxBodyCaptureBytes"`
+	WatchConfig            bool            `yaml:"watchConfig"`
+	WatchInterval          string          `yaml:"watchInterval"`
+
+	CheckpointEveryRequests         int    `yaml:"checkpointEveryRequests"`
+	CheckpointWriteBurstRequests    int    `yaml:"checkpointWriteBurstRequests"`
+	CheckpointWriteBurstQuietPeriod string `yaml:"checkpointWriteBurstQuietPeriod"`
+	CheckpointEventLogBudgetBytes   int64  `yaml:"checkpointEventLogBudgetBytes"`
+
+	CheckpointCron  string           `yaml:"checkpointCron"`
+	BlackoutWindows []BlackoutWindow `yaml:"blackoutWindows"`
+
+	Holding HoldingConfig `yaml:"holding"`
+	Routes  []RouteRule   `yaml:"routes"`
+	Replay  ReplayConfig  `yaml:"replay"`
+
+	Tracing tracing.Config `yaml:"tracing"`
+	Logging logging.Config `yaml:"logging"`
+}
+
+func defaultConfigYAML() configYAML {
+	return configYAML{
+		CheckpointingInterval:  "20m",
+		PodNamespace:           "default",
+		PodName:                hostname(),
+		Port:                   8001,
+		HeartbeatInterval:      "10s",
+		AdminPort:              8003,
+		ImagesDirectory:        "/var/lib/checkpoints",
+		CheckpointBackend:      BackendCRIU,
+		RepositoryBackend:      BackendInMemory,
+		StateManagerTransport:  BackendHTTP,
+		StateManagerTimeout:    "10s",
+		StateManagerMaxRetries: 3,
+		OutboxFlushInterval:    "5s",
+		PIDResolver:            PIDResolverStatic,
+		ProcRoot:               "/proc",
+		MaxBodyCaptureBytes:    1 << 20,
+		WatchInterval:          "10s",
+
+		CheckpointWriteBurstQuietPeriod: "5s",
+
+		Holding: HoldingConfig{
+			MaxRequests: 1000,
+			MaxBytes:    32 << 20,
+			MaxWait:     30 * time.Second,
+			Timeout:     10 * time.Minute,
+			RetryAfter:  5 * time.Second,
+		},
+		Replay: ReplayConfig{
+			SkipApplied:   true,
+			DefaultPolicy: ReplayAlways,
+			Parallelism:   1,
+			Timeout:       30 * time.Second,
+			Retries:       3,
+			RetryBackoff:  500 * time.Millisecond,
+			Speed:         1,
+		},
+
+		Tracing: tracing.Default(),
+		Logging: logging.Default(),
+	}
+}
+
+// Keys returns the configuration keys that can be overridden by environment variables
+// and flags.
+func Keys() []string {
+	return loader.Keys(&configYAML{})
+}
+
+// Load loads the configuration from the YAML file, when given, overridden by the
+// environment variables prefixed by EnvPrefix and then by the flag values by key. The
+// loaded configuration is validated.
+func Load(filename string, lookupEnv func(string) (string, bool), flagValues map[string]string) (*Config, error) {
+	cfg := defaultConfigYAML()
+	if filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loader.ApplyEnv(&cfg, EnvPrefix, lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := loader.ApplyValues(&cfg, flagValues); err != nil {
+		return nil, err
+	}
+
+	config, err := cfg.toConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 func FromYAMLFile(filename string) (*Config, error) {
@@ -32,20 +460,16 @@ func FromYAMLFile(filename string) (*Config, error) {
 }
 
 func FromYAML(content []byte) (*Config, error) {
-	type configYAML struct {
-		CheckpointingInterval string `yaml:"checkpointingInterval"`
-		ContainerURL          string `yaml:"containerURL"`
-		ContainerPID          int    `yaml:"containerPID"`
-		ContainerName         string `yaml:"containerName"`
-		StateManagerURL       string `yaml:"stateManagerURL"`
-	}
-
-	var cfg configYAML
+	cfg := defaultConfigYAML()
 	err := yaml.Unmarshal(content, &cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	return cfg.toConfig()
+}
+
+func (cfg configYAML) toConfig() (*Config, error) {
 	checkpointingInterval, err := time.ParseDuration(cfg.CheckpointingInterval)
 	if err != nil {
 		return nil, err
@@ -61,11 +485,291 @@ func FromYAML(content []byte) (*Config, error) {
 		return nil, err
 	}
 
+	stateManagerTimeout, err := time.ParseDuration(cfg.StateManagerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	outboxFlushInterval, err := time.ParseDuration(cfg.OutboxFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeatInterval, err := time.ParseDuration(cfg.HeartbeatInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	watchInterval, err := time.ParseDuration(cfg.WatchInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	writeBurstQuietPeriod, err := time.ParseDuration(cfg.CheckpointWriteBurstQuietPeriod)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		CheckpointingInterval: checkpointingInterval,
-		ContainerURL:          *containerURL,
-		ContainerPID:          int32(cfg.ContainerPID),
-		ContainerName:         cfg.ContainerName,
-		StateManagerURL:       *stateManagerURL,
+		CheckpointingInterval:  checkpointingInterval,
+		ContainerURL:           *containerURL,
+		ContainerPID:           int32(cfg.ContainerPID),
+		ContainerName:          cfg.ContainerName,
+		PodNamespace:           cfg.PodNamespace,
+		PodName:                cfg.PodName,
+		AdminURL:               cfg.AdminURL,
+		StateManagerURL:        *stateManagerURL,
+		Security:               cfg.Security,
+		Port:                   cfg.Port,
+		HeartbeatInterval:      heartbeatInterval,
+		AdminPort:              cfg.AdminPort,
+		ImagesDirectory:        cfg.ImagesDirectory,
+		CheckpointRetention:    cfg.CheckpointRetention,
+		CheckpointBackend:      cfg.CheckpointBackend,
+		RepositoryBackend:      cfg.RepositoryBackend,
+		DatabaseDriver:         cfg.DatabaseDriver,
+		DatabaseDSN:            cfg.DatabaseDSN,
+		StateManagerTransport:  cfg.StateManagerTransport,
+		StateManagerTimeout:    stateManagerTimeout,
+		StateManagerMaxRetries: cfg.StateManagerMaxRetries,
+		OutboxDirectory:        cfg.OutboxDirectory,
+		OutboxFlushInterval:    outboxFlushInterval,
+		PIDResolver:            cfg.PIDResolver,
+		ContainerID:            cfg.ContainerID,
+		CRISocket:              cfg.CRISocket,
+		CGroupPath:             cfg.CGroupPath,
+		ProcessName:            cfg.ProcessName,
+		ProcRoot:               cfg.ProcRoot,
+		MaxBodyCaptureBytes:    cfg.MaxBodyCaptureBytes,
+		WatchConfig:            cfg.WatchConfig,
+		WatchInterval:          watchInterval,
+
+		CheckpointEveryRequests:         cfg.CheckpointEveryRequests,
+		CheckpointWriteBurstRequests:    cfg.CheckpointWriteBurstRequests,
+		CheckpointWriteBurstQuietPeriod: writeBurstQuietPeriod,
+		CheckpointEventLogBudgetBytes:   cfg.CheckpointEventLogBudgetBytes,
+		CheckpointCron:                  cfg.CheckpointCron,
+		BlackoutWindows:                 cfg.BlackoutWindows,
+
+		Holding: cfg.Holding,
+		Routes:  cfg.Routes,
+		Replay:  cfg.Replay,
+
+		Tracing: cfg.Tracing,
+		Logging: cfg.Logging,
 	}, nil
 }
+
+// Validate checks the configuration, reporting every invalid value at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.CheckpointingInterval < 0 {
+		errs = append(errs, fmt.Errorf("checkpointingInterval must not be negative, got %v", cfg.CheckpointingInterval))
+	}
+	if cfg.CheckpointEveryRequests < 0 || cfg.CheckpointWriteBurstRequests < 0 || cfg.CheckpointEventLogBudgetBytes < 0 {
+		errs = append(errs, errors.New("checkpointEveryRequests, checkpointWriteBurstRequests and checkpointEventLogBudgetBytes must not be negative"))
+	}
+	if cfg.CheckpointingInterval == 0 && cfg.CheckpointEveryRequests == 0 && cfg.CheckpointWriteBurstRequests == 0 && cfg.CheckpointEventLogBudgetBytes == 0 && cfg.CheckpointCron == "" {
+		errs = append(errs, errors.New("at least one checkpoint policy must be enabled"))
+	}
+	if cfg.CheckpointRetention < 0 {
+		errs = append(errs, fmt.Errorf("checkpointRetention must not be negative, got %d", cfg.CheckpointRetention))
+	}
+	if cfg.CheckpointCron != "" {
+		if _, err := cron.ParseStandard(cfg.CheckpointCron); err != nil {
+			errs = append(errs, fmt.Errorf("checkpointCron %q is invalid: %w", cfg.CheckpointCron, err))
+		}
+	}
+	for i, window := range cfg.BlackoutWindows {
+		if _, err := cron.ParseStandard(window.Start); err != nil {
+			errs = append(errs, fmt.Errorf("blackoutWindows[%d].start %q is invalid: %w", i, window.Start, err))
+		}
+		if window.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("blackoutWindows[%d].duration must be positive, got %v", i, window.Duration))
+		}
+	}
+	if cfg.ContainerURL.Scheme == "" || cfg.ContainerURL.Host == "" {
+		errs = append(errs, fmt.Errorf("containerURL must be an absolute URL, got %q", cfg.ContainerURL.String()))
+	}
+	if cfg.ContainerName == "" {
+		errs = append(errs, errors.New("containerName is required"))
+	}
+	if cfg.PodNamespace == "" || cfg.PodName == "" {
+		errs = append(errs, errors.New("podNamespace and podName are required"))
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", cfg.Port))
+	}
+	if cfg.AdminPort < 0 || cfg.AdminPort > 65535 || (cfg.AdminPort != 0 && cfg.AdminPort == cfg.Port) {
+		errs = append(errs, fmt.Errorf("adminPort must be between 0 and 65535 and differ from port, got %d", cfg.AdminPort))
+	}
+	switch cfg.CheckpointBackend {
+	case BackendCRIU:
+		if cfg.ImagesDirectory == "" {
+			errs = append(errs, errors.New("imagesDirectory is required by the criu checkpoint backend"))
+		}
+		if cfg.PIDResolver == PIDResolverStatic && cfg.ContainerPID <= 0 {
+			errs = append(errs, errors.New("containerPID is required by the criu checkpoint backend with the static pid resolver"))
+		}
+	case BackendStub:
+	default:
+		errs = append(errs, fmt.Errorf("checkpointBackend must be %q or %q, got %q", BackendCRIU, BackendStub, cfg.CheckpointBackend))
+	}
+	switch cfg.RepositoryBackend {
+	case BackendInMemory:
+	case BackendSQL:
+		if cfg.DatabaseDriver == "" || cfg.DatabaseDSN == "" {
+			errs = append(errs, errors.New("databaseDriver and databaseDSN are required by the sql repository backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("repositoryBackend must be %q or %q, got %q", BackendInMemory, BackendSQL, cfg.RepositoryBackend))
+	}
+	switch cfg.StateManagerTransport {
+	case BackendHTTP:
+		if cfg.StateManagerURL.Scheme == "" || cfg.StateManagerURL.Host == "" {
+			errs = append(errs, fmt.Errorf("stateManagerURL must be an absolute URL with the http transport, got %q", cfg.StateManagerURL.String()))
+		}
+		if cfg.StateManagerTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("stateManagerTimeout must be positive, got %v", cfg.StateManagerTimeout))
+		}
+		if cfg.StateManagerMaxRetries < 0 {
+			errs = append(errs, fmt.Errorf("stateManagerMaxRetries must not be negative, got %d", cfg.StateManagerMaxRetries))
+		}
+		if cfg.OutboxDirectory != "" && cfg.OutboxFlushInterval <= 0 {
+			errs = append(errs, fmt.Errorf("outboxFlushInterval must be positive, got %v", cfg.OutboxFlushInterval))
+		}
+	case BackendStub:
+	default:
+		errs = append(errs, fmt.Errorf("stateManagerTransport must be %q or %q, got %q", BackendHTTP, BackendStub, cfg.StateManagerTransport))
+	}
+	switch cfg.PIDResolver {
+	case PIDResolverStatic, PIDResolverCRI:
+	case PIDResolverCGroup:
+		if cfg.CGroupPath == "" && cfg.ContainerID == "" {
+			errs = append(errs, errors.New("cgroupPath or containerID is required by the cgroup pid resolver"))
+		}
+	case PIDResolverProcess:
+		if cfg.ProcessName == "" {
+			errs = append(errs, errors.New("processName is required by the process pid resolver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("pidResolver must be one of %q, %q, %q or %q, got %q", PIDResolverStatic, PIDResolverCRI, PIDResolverCGroup, PIDResolverProcess, cfg.PIDResolver))
+	}
+	if cfg.HeartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("heartbeatInterval must not be negative, got %v", cfg.HeartbeatInterval))
+	}
+	if cfg.MaxBodyCaptureBytes < 0 {
+		errs = append(errs, fmt.Errorf("maxBodyCaptureBytes must not be negative, got %d", cfg.MaxBodyCaptureBytes))
+	}
+	if cfg.Holding.MaxRequests < 0 || cfg.Holding.MaxBytes < 0 {
+		errs = append(errs, errors.New("holding.maxRequests and holding.maxBytes must not be negative"))
+	}
+	if cfg.Holding.MaxRequests > 0 && (cfg.Holding.MaxWait <= 0 || cfg.Holding.Timeout <= 0 || cfg.Holding.RetryAfter <= 0) {
+		errs = append(errs, errors.New("holding.maxWait, holding.timeout and holding.retryAfter must be positive when holding is enabled"))
+	}
+	for i, rule := range cfg.Routes {
+		if rule.Action != "" && rule.Action != RouteRecord && rule.Action != RouteRecordNoReplay && rule.Action != RoutePassthrough {
+			errs = append(errs, fmt.Errorf("routes[%d].action must be %q, %q or %q, got %q", i, RouteRecord, RouteRecordNoReplay, RoutePassthrough, rule.Action))
+		}
+		if _, err := path.Match(strings.TrimSuffix(rule.Path, "/**"), ""); err != nil || (rule.Path != "" && !strings.HasPrefix(rule.Path, "/")) {
+			errs = append(errs, fmt.Errorf("routes[%d].path must be an absolute path pattern, got %q", i, rule.Path))
+		}
+		if rule.Replay != "" && !validReplayPolicy(rule.Replay) {
+			errs = append(errs, fmt.Errorf("routes[%d].replay must be %q, %q or %q, got %q", i, ReplayAlways, ReplayNever, ReplayIdempotent, rule.Replay))
+		}
+	}
+	if !validReplayPolicy(cfg.Replay.DefaultPolicy) {
+		errs = append(errs, fmt.Errorf("replay.defaultPolicy must be %q, %q or %q, got %q", ReplayAlways, ReplayNever, ReplayIdempotent, cfg.Replay.DefaultPolicy))
+	}
+	if cfg.Replay.Parallelism < 1 {
+		errs = append(errs, fmt.Errorf("replay.parallelism must be at least 1, got %d", cfg.Replay.Parallelism))
+	}
+	if cfg.Replay.RateLimit < 0 || cfg.Replay.Timeout < 0 || cfg.Replay.Retries < 0 || cfg.Replay.RetryBackoff < 0 {
+		errs = append(errs, errors.New("replay.rateLimit, replay.timeout, replay.retries and replay.retryBackoff must not be negative"))
+	}
+	if cfg.Replay.Speed <= 0 {
+		errs = append(errs, fmt.Errorf("replay.speed must be positive, got %v", cfg.Replay.Speed))
+	}
+	if cfg.WatchConfig && cfg.WatchInterval <= 0 {
+		errs = append(errs, fmt.Errorf("watchInterval must be positive, got %v", cfg.WatchInterval))
+	}
+	if cfg.Security.TLSEnabled() != (cfg.Security.CertFile != "" || cfg.Security.KeyFile != "") {
+		errs = append(errs, errors.New("security.certFile and security.keyFile must be set together"))
+	}
+	if err := cfg.Security.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Logging.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// YAML encodes the configuration in YAML, as accepted by FromYAML, with secrets
+// redacted.
+func (cfg *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(configYAML{
+		CheckpointingInterval:  cfg.CheckpointingInterval.String(),
+		ContainerURL:           cfg.ContainerURL.String(),
+		ContainerPID:           int(cfg.ContainerPID),
+		ContainerName:          cfg.ContainerName,
+		PodNamespace:           cfg.PodNamespace,
+		PodName:                cfg.PodName,
+		AdminURL:               cfg.AdminURL,
+		StateManagerURL:        cfg.StateManagerURL.String(),
+		Security:               cfg.Security.Redacted(),
+		Port:                   cfg.Port,
+		HeartbeatInterval:      cfg.HeartbeatInterval.String(),
+		AdminPort:              cfg.AdminPort,
+		ImagesDirectory:        cfg.ImagesDirectory,
+		CheckpointRetention:    cfg.CheckpointRetention,
+		CheckpointBackend:      cfg.CheckpointBackend,
+		RepositoryBackend:      cfg.RepositoryBackend,
+		DatabaseDriver:         cfg.DatabaseDriver,
+		DatabaseDSN:            redact(cfg.DatabaseDSN),
+		StateManagerTransport:  cfg.StateManagerTransport,
+		StateManagerTimeout:    cfg.StateManagerTimeout.String(),
+		StateManagerMaxRetries: cfg.StateManagerMaxRetries,
+		OutboxDirectory:        cfg.OutboxDirectory,
+		OutboxFlushInterval:    cfg.OutboxFlushInterval.String(),
+		PIDResolver:            cfg.PIDResolver,
+		ContainerID:            cfg.ContainerID,
+		CRISocket:              cfg.CRISocket,
+		CGroupPath:             cfg.CGroupPath,
+		ProcessName:            cfg.ProcessName,
+		ProcRoot:               cfg.ProcRoot,
+		MaxBodyCaptureBytes:    cfg.MaxBodyCaptureBytes,
+		WatchConfig:            cfg.WatchConfig,
+		WatchInterval:          cfg.WatchInterval.String(),
+
+		CheckpointEveryRequests:         cfg.CheckpointEveryRequests,
+		CheckpointWriteBurstRequests:    cfg.CheckpointWriteBurstRequests,
+		CheckpointWriteBurstQuietPeriod: cfg.CheckpointWriteBurstQuietPeriod.String(),
+		CheckpointEventLogBudgetBytes:   cfg.CheckpointEventLogBudgetBytes,
+		CheckpointCron:                  cfg.CheckpointCron,
+		BlackoutWindows:                 cfg.BlackoutWindows,
+
+		Holding: cfg.Holding,
+		Routes:  cfg.Routes,
+		Replay:  cfg.Replay,
+
+		Tracing: cfg.Tracing,
+		Logging: cfg.Logging,
+	})
+}
+
+// hostname returns the hostname, which is the pod name in Kubernetes.
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return security.RedactedValue
+}
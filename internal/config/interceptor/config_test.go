@@ -2,6 +2,9 @@ package interceptor
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -48,3 +51,131 @@ stateManagerURL: "%s"`,
 		t.Errorf("expected parsed state manager url to be %q, got %q\n", stateManagerURL, cfg.StateManagerURL.String())
 	}
 }
+
+func TestLoad(t *testing.T) {
+	t.Run("it should override the configuration with environment variables and flags", func(t *testing.T) {
+		env := map[string]string{
+			"INTERCEPTOR_CONTAINER_URL":  "http://localhost:8000",
+			"INTERCEPTOR_CONTAINER_NAME": "env",
+		}
+		cfg, err := Load("", func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}, map[string]string{
+			"containerName":         "flag",
+			"checkpointBackend":     "stub",
+			"stateManagerTransport": "stub",
+			"security.insecure":     "true",
+		})
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if cfg.ContainerURL.String() != "http://localhost:8000" {
+			t.Errorf("expected container URL from environment, got %q\n", cfg.ContainerURL.String())
+		}
+		if cfg.ContainerName != "flag" {
+			t.Errorf("expected container name from flag, got %q\n", cfg.ContainerName)
+		}
+	})
+
+	t.Run("it should report every invalid value", func(t *testing.T) {
+		_, err := Load("", func(string) (string, bool) { return "", false }, map[string]string{
+			"checkpointBackend":   "unknown",
+			"checkpointRetention": "-1",
+		})
+		if err == nil {
+			t.Fatal("expected validation error")
+		}
+		for _, message := range []string{"containerURL", "containerName", "checkpointBackend", "checkpointRetention", "security.insecure"} {
+			if !strings.Contains(err.Error(), message) {
+				t.Errorf("expected error to mention %q, got %v\n", message, err)
+			}
+		}
+	})
+}
+
+func TestReplayPolicy(t *testing.T) {
+	cfg, err := FromYAML([]byte(`replay:
+  defaultPolicy: idempotent
+routes:
+  - path: /payments/quotes/**
+    replay: always
+    commutative: true
+  - path: /payments/**
+    replay: never
+  - methods: [POST]
+    path: /reports/**
+    action: record-no-replay`))
+	if err != nil {
+		t.Fatalf("expected error nil, received %v\n", err)
+	}
+
+	for path, expected := range map[string]string{
+		"/orders":                ReplayIdempotent,
+		"/payments/1":            ReplayNever,
+		"/payments/quotes/today": ReplayAlways,
+		"/reports/daily":         ReplayNever,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if policy := ReplayPolicy(cfg.Routes, cfg.Replay.DefaultPolicy, req); policy != expected {
+			t.Errorf("expected policy %q for %s, got %q\n", expected, path, policy)
+		}
+	}
+
+	t.Run("it should tell the commutative requests from their rule", func(t *testing.T) {
+		if !Commutative(cfg.Routes, httptest.NewRequest(http.MethodGet, "/payments/quotes/today", nil)) || Commutative(cfg.Routes, httptest.NewRequest(http.MethodGet, "/orders", nil)) {
+			t.Error("expected only the quotes to be commutative")
+		}
+	})
+
+	t.Run("it should reject unknown policies", func(t *testing.T) {
+		cfg.Routes = append(cfg.Routes, RouteRule{Path: "/orders", Replay: "sometimes"})
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "routes[3].replay") {
+			t.Errorf("expected an error for the unknown policy, got %v\n", err)
+		}
+	})
+}
+
+func TestRouteAction(t *testing.T) {
+	cfg, err := FromYAML([]byte(`routes:
+  - path: /health
+    action: passthrough
+  - methods: [POST]
+    path: /reports/**
+    action: record-no-replay
+  - path: /debug/*
+    headers:
+      X-Debug: "true"
+    action: passthrough`))
+	if err != nil {
+		t.Fatalf("expected error nil, received %v\n", err)
+	}
+
+	for _, test := range []struct {
+		method, path string
+		headers      map[string]string
+		expected     string
+	}{
+		{http.MethodGet, "/health", nil, RoutePassthrough},
+		{http.MethodPost, "/reports/daily/1", nil, RouteRecordNoReplay},
+		{http.MethodGet, "/reports/daily/1", nil, RouteRecord},
+		{http.MethodGet, "/debug/vars", map[string]string{"X-Debug": "true"}, RoutePassthrough},
+		{http.MethodGet, "/debug/vars", nil, RouteRecord},
+		{http.MethodGet, "/orders", nil, RouteRecord},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		if action := RouteAction(cfg.Routes, req); action != test.expected {
+			t.Errorf("expected action %q for %s %s, got %q\n", test.expected, test.method, test.path, action)
+		}
+	}
+
+	t.Run("it should reject unknown actions", func(t *testing.T) {
+		cfg.Routes = append(cfg.Routes, RouteRule{Path: "/orders", Action: "ignore"})
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "routes[3].action") {
+			t.Errorf("expected an error for the unknown action, got %v\n", err)
+		}
+	})
+}
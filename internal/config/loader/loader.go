@@ -0,0 +1,184 @@
+// Package loader overrides configuration values loaded from YAML with environment
+// variables and command line flags. Configuration values are addressed by the path of
+// their YAML keys joined by dots, like "security.certFile".
+package loader
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Keys returns the keys of every scalar value of the configuration struct pointed by
+// cfg that can be overridden.
+func Keys(cfg interface{}) []string {
+	var keys []string
+	walk(reflect.ValueOf(cfg).Elem(), "", func(key string, _ reflect.Value) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Set sets the value of the given key in the configuration struct pointed by cfg.
+func Set(cfg interface{}, key string, value string) error {
+	var found bool
+	var err error
+	walk(reflect.ValueOf(cfg).Elem(), "", func(fieldKey string, field reflect.Value) {
+		if fieldKey != key {
+			return
+		}
+		found = true
+		err = setValue(field, value)
+	})
+	if !found {
+		return fmt.Errorf("unknown configuration key %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %q: %w", value, key, err)
+	}
+	return nil
+}
+
+// EnvName returns the environment variable overriding the key, the key in upper
+// snake case after the prefix, like PREFIX_SECURITY_CERT_FILE.
+func EnvName(prefix string, key string) string {
+	var name strings.Builder
+	name.WriteString(prefix)
+	for _, part := range strings.Split(key, ".") {
+		name.WriteByte('_')
+		for i, r := range part {
+			if unicode.IsUpper(r) && i > 0 && !unicode.IsUpper(rune(part[i-1])) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// ApplyEnv overrides the configuration with the environment variables named after
+// its keys with the given prefix.
+func ApplyEnv(cfg interface{}, prefix string, lookupEnv func(string) (string, bool)) error {
+	for _, key := range Keys(cfg) {
+		if value, ok := lookupEnv(EnvName(prefix, key)); ok {
+			if err := Set(cfg, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyValues overrides the configuration with the values by key.
+func ApplyValues(cfg interface{}, values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := Set(cfg, key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags registers a flag for every key in the flag set and returns the values of the
+// flags set in the command line once it is parsed.
+func Flags(flagSet *flag.FlagSet, keys []string) map[string]string {
+	values := make(map[string]string)
+	for _, key := range keys {
+		key := key
+		flagSet.Func(key, fmt.Sprintf("overrides %q of the configuration file", key), func(value string) error {
+			values[key] = value
+			return nil
+		})
+	}
+	return values
+}
+
+func walk(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name := strings.Split(structField.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || !structField.IsExported() {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			walk(field, key, fn)
+		case isScalar(field.Type()):
+			fn(key, field)
+		}
+	}
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name     string        `yaml:"name"`
+	Interval time.Duration `yaml:"interval"`
+	Nested   struct {
+		Port      int      `yaml:"port"`
+		Enabled   bool     `yaml:"enabled"`
+		Endpoints []string `yaml:"endpoints"`
+	} `yaml:"nested"`
+	Ignored map[string]string `yaml:"ignored"`
+}
+
+func TestKeys(t *testing.T) {
+	keys := Keys(&testConfig{})
+	expected := []string{"interval", "name", "nested.enabled", "nested.endpoints", "nested.port"}
+	if len(keys) != len(expected) {
+		t.Fatalf("expected keys %v, got %v\n", expected, keys)
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Errorf("expected key %q, got %q\n", expected[i], keys[i])
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	name := EnvName("INTERCEPTOR", "security.certFile")
+	if name != "INTERCEPTOR_SECURITY_CERT_FILE" {
+		t.Errorf("expected %q, got %q\n", "INTERCEPTOR_SECURITY_CERT_FILE", name)
+	}
+	name = EnvName("INTERCEPTOR", "containerURL")
+	if name != "INTERCEPTOR_CONTAINER_URL" {
+		t.Errorf("expected %q, got %q\n", "INTERCEPTOR_CONTAINER_URL", name)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := testConfig{Name: "file"}
+	env := map[string]string{
+		"TEST_NAME":             "env",
+		"TEST_NESTED_PORT":      "8080",
+		"TEST_NESTED_ENDPOINTS": "a, b",
+	}
+
+	t.Run("it should apply environment variables", func(t *testing.T) {
+		err := ApplyEnv(&cfg, "TEST", func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		})
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if cfg.Name != "env" || cfg.Nested.Port != 8080 || len(cfg.Nested.Endpoints) != 2 {
+			t.Errorf("expected environment variables to be applied, got %+v\n", cfg)
+		}
+	})
+
+	t.Run("it should apply flags over environment variables", func(t *testing.T) {
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+		values := Flags(flagSet, Keys(&cfg))
+		if err := flagSet.Parse([]string{"-name", "flag", "-interval", "5m", "-nested.enabled", "true"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyValues(&cfg, values); err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if cfg.Name != "flag" || cfg.Interval != 5*time.Minute || !cfg.Nested.Enabled {
+			t.Errorf("expected flags to be applied, got %+v\n", cfg)
+		}
+	})
+
+	t.Run("it should report invalid values", func(t *testing.T) {
+		if err := Set(&cfg, "nested.port", "http"); err == nil {
+			t.Error("expected error setting invalid port")
+		}
+		if err := Set(&cfg, "unknown", "value"); err == nil {
+			t.Error("expected error setting unknown key")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Formats of the log output.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config is the logging configuration of the Interceptor and State Manager.
+type Config struct {
+	// Level is the minimum level logged, either "debug", "info", "warn" or "error".
+	Level string `yaml:"level"`
+	// Format is the output format, either "text" or "json".
+	Format string `yaml:"format"`
+}
+
+// Default returns the configuration logging info messages as text.
+func Default() Config {
+	return Config{
+		Level:  "info",
+		Format: FormatText,
+	}
+}
+
+// SlogLevel returns the level as a slog.Level.
+func (cfg Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return level, fmt.Errorf("logging.level %q is invalid: %w", cfg.Level, err)
+	}
+	return level, nil
+}
+
+// Validate checks the level and the format.
+func (cfg Config) Validate() error {
+	if _, err := cfg.SlogLevel(); err != nil {
+		return err
+	}
+	if cfg.Format != FormatText && cfg.Format != FormatJSON {
+		return fmt.Errorf("logging.format must be %q or %q, got %q", FormatText, FormatJSON, cfg.Format)
+	}
+	return nil
+}
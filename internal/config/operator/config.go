@@ -0,0 +1,114 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/security"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the configuration.
+const EnvPrefix = "OPERATOR"
+
+// Config is the configuration of the operator.
+type Config struct {
+	// Kubeconfig the kubeconfig file, empty to use the in-cluster configuration.
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Namespace the namespace of the watched resources, every namespace when empty.
+	Namespace string `yaml:"namespace"`
+	// ResyncInterval the interval between reconciliations of every resource.
+	ResyncInterval time.Duration `yaml:"resyncInterval"`
+	// Workers the number of resources reconciled concurrently.
+	Workers int `yaml:"workers"`
+	// StateManagerURL the url of the State Manager API, whose restore route must
+	// authorize the identity of the operator.
+	StateManagerURL string `yaml:"stateManagerURL"`
+	// RestoreTimeout the maximum duration of a restore, including the reprojection of
+	// the requests.
+	RestoreTimeout time.Duration `yaml:"restoreTimeout"`
+	// Security the certificate and token presented to the State Manager.
+	Security security.Config `yaml:"security"`
+	// Logging the level and format of the logs.
+	Logging logging.Config `yaml:"logging"`
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		ResyncInterval:  5 * time.Minute,
+		Workers:         2,
+		StateManagerURL: "http://state-manager:8002",
+		RestoreTimeout:  15 * time.Minute,
+		Logging:         logging.Default(),
+	}
+}
+
+// Keys returns the configuration keys that can be overridden by environment variables
+// and flags.
+func Keys() []string {
+	return loader.Keys(&Config{})
+}
+
+// Load loads the configuration from the YAML file, when given, overridden by the
+// environment variables prefixed by EnvPrefix and then by the flag values by key. The
+// loaded configuration is validated.
+func Load(filename string, lookupEnv func(string) (string, bool), flagValues map[string]string) (*Config, error) {
+	cfg := Default()
+	if filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loader.ApplyEnv(&cfg, EnvPrefix, lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := loader.ApplyValues(&cfg, flagValues); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration, reporting every invalid value at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.ResyncInterval <= 0 {
+		errs = append(errs, fmt.Errorf("resyncInterval must be positive, got %v", cfg.ResyncInterval))
+	}
+	if cfg.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("workers must be positive, got %d", cfg.Workers))
+	}
+	if stateManagerURL, err := url.Parse(cfg.StateManagerURL); err != nil || stateManagerURL.Scheme == "" || stateManagerURL.Host == "" {
+		errs = append(errs, fmt.Errorf("stateManagerURL must be an absolute URL, got %q", cfg.StateManagerURL))
+	}
+	if cfg.RestoreTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("restoreTimeout must be positive, got %v", cfg.RestoreTimeout))
+	}
+	if (cfg.Security.CertFile != "") != (cfg.Security.KeyFile != "") {
+		errs = append(errs, errors.New("security.certFile and security.keyFile must be set together"))
+	}
+	if err := cfg.Logging.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// YAML encodes the configuration in YAML with secrets redacted.
+func (cfg Config) YAML() ([]byte, error) {
+	cfg.Security = cfg.Security.Redacted()
+	return yaml.Marshal(cfg)
+}
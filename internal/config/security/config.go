@@ -0,0 +1,79 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Config is the TLS and authentication configuration of the Interceptor and State
+// Manager APIs.
+type Config struct {
+	// CertFile is the certificate presented by the server and, when calling the
+	// other component, by the client.
+	CertFile string `yaml:"certFile"`
+	// KeyFile is the private key of CertFile.
+	KeyFile string `yaml:"keyFile"`
+	// CAFile is the certificate authority used to verify the certificates of the other
+	// component, both server and client certificates.
+	CAFile string `yaml:"caFile"`
+	// RequireClientCert rejects TLS connections without a verified client certificate.
+	RequireClientCert bool `yaml:"requireClientCert"`
+	// Tokens maps static bearer tokens to the name of their identity.
+	Tokens map[string]string `yaml:"tokens"`
+	// TokenReview enables authentication of Kubernetes ServiceAccount tokens.
+	TokenReview bool `yaml:"tokenReview"`
+	// APIServerURL is the URL of the Kubernetes API server used for TokenReview.
+	APIServerURL string `yaml:"apiServerURL"`
+	// ServiceAccountTokenFile is the token used to call the TokenReview API and sent
+	// as bearer token when calling the other component.
+	ServiceAccountTokenFile string `yaml:"serviceAccountTokenFile"`
+	// APIServerCAFile is the certificate authority of the Kubernetes API server.
+	APIServerCAFile string `yaml:"apiServerCAFile"`
+	// Audiences are the audiences reviewed ServiceAccount tokens must be issued for.
+	Audiences []string `yaml:"audiences"`
+	// Authorization maps route names to the identity names or groups allowed to call
+	// them.
+	Authorization map[string][]string `yaml:"authorization"`
+	// Insecure leaves the administrative routes open when no authentication is
+	// configured, which is otherwise refused.
+	Insecure bool `yaml:"insecure"`
+}
+
+// TLSEnabled tells whether the server must listen with TLS.
+func (cfg Config) TLSEnabled() bool {
+	return cfg.CertFile != "" && cfg.KeyFile != ""
+}
+
+// AuthenticationEnabled tells whether the administrative routes require
+// authentication.
+func (cfg Config) AuthenticationEnabled() bool {
+	return len(cfg.Tokens) > 0 || cfg.TokenReview || (cfg.TLSEnabled() && cfg.CAFile != "")
+}
+
+// Validate checks the administrative routes are either authenticated or explicitly
+// left open.
+func (cfg Config) Validate() error {
+	if !cfg.AuthenticationEnabled() && !cfg.Insecure {
+		return errors.New("security must configure tokens, tokenReview or client certificates, or set security.insecure to leave the administrative routes open")
+	}
+	return nil
+}
+
+// RedactedValue replaces secrets in printed configurations.
+const RedactedValue = "<redacted>"
+
+// Redacted returns a copy of the configuration with the static tokens redacted, safe
+// to be printed.
+func (cfg Config) Redacted() Config {
+	if len(cfg.Tokens) == 0 {
+		return cfg
+	}
+	tokens := make(map[string]string, len(cfg.Tokens))
+	i := 0
+	for _, name := range cfg.Tokens {
+		i++
+		tokens[fmt.Sprintf("%s-%d", RedactedValue, i)] = name
+	}
+	cfg.Tokens = tokens
+	return cfg
+}
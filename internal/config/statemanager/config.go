@@ -1,8 +1,277 @@
 package statemanager
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/loader"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/security"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/tracing"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the configuration.
+const EnvPrefix = "STATE_MANAGER"
+
+// Backends of the State Manager services and repositories.
+const (
+	BackendCRIU       = "criu"
+	BackendStub       = "stub"
+	BackendInMemory   = "inmemory"
+	BackendETCD       = "etcd"
+	BackendSQL        = "sql"
+	BackendKubernetes = "kubernetes"
+)
+
 // StateManagerConfig defines the state manager configuration.
 type StateManagerConfig struct {
 	// Flag to either enable or disable development features of state manager, like
 	// restore endpoint o create a new restore from an image checkpoint hash.
-	DevelopmentFeaturesEnabled bool
+	DevelopmentFeaturesEnabled bool `yaml:"developmentFeaturesEnabled"`
+	// Security is the TLS and authentication configuration of the State Manager API.
+	Security security.Config `yaml:"security"`
+	// Port the port of the State Manager API.
+	Port int `yaml:"port"`
+	// ImagesDirectory the directory to retrieve checkpoint images from.
+	ImagesDirectory string `yaml:"imagesDirectory"`
+	// RestoreBackend the restore service to use, either "criu" or "stub".
+	RestoreBackend string `yaml:"restoreBackend"`
+	// RepositoryBackend the container metadata repository to use, either "inmemory",
+	// "etcd", "sql" or "kubernetes".
+	RepositoryBackend string `yaml:"repositoryBackend"`
+	// ETCD the configuration of the "etcd" repository.
+	ETCD ETCDConfig `yaml:"etcd"`
+	// SQL the configuration of the "sql" repository.
+	SQL SQLConfig `yaml:"sql"`
+	// Kubernetes the configuration of the "kubernetes" repository.
+	Kubernetes KubernetesConfig `yaml:"kubernetes"`
+	// LeaderElection the election of the leader among the State Manager replicas.
+	LeaderElection LeaderElectionConfig `yaml:"leaderElection"`
+	// Container a container registered at startup, for deployments whose Interceptor
+	// does not register itself. Other containers are registered by their Interceptors.
+	Container ContainerConfig `yaml:"container"`
+	// HeartbeatTimeout how long an Interceptor can go without heartbeats before it is
+	// marked as lost.
+	HeartbeatTimeout time.Duration `yaml:"heartbeatTimeout"`
+	// ReprojectionTimeout the maximum duration of the reprojection following a
+	// restore.
+	ReprojectionTimeout time.Duration `yaml:"reprojectionTimeout"`
+	// MigrationTimeout the maximum duration of each step of a migration, like the
+	// transfer of the checkpoint image between the nodes.
+	MigrationTimeout time.Duration `yaml:"migrationTimeout"`
+	// Tracing the export of the trace spans.
+	Tracing tracing.Config `yaml:"tracing"`
+	// Logging the level and format of the logs.
+	Logging logging.Config `yaml:"logging"`
+}
+
+// ETCDConfig the configuration to connect to etcd.
+type ETCDConfig struct {
+	// Endpoints the etcd endpoints.
+	Endpoints []string `yaml:"endpoints"`
+	// DialTimeout the timeout to connect to etcd.
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+	// RequestTimeout the timeout of each request to etcd.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// Prefix the prefix of every key of the repository, to share etcd with others.
+	Prefix string `yaml:"prefix"`
+}
+
+// SQLConfig the configuration to connect to the database.
+type SQLConfig struct {
+	// Driver the database/sql driver, either "sqlite" or "postgres".
+	Driver string `yaml:"driver"`
+	// DSN the data source name of the database.
+	DSN string `yaml:"dsn"`
+}
+
+// KubernetesConfig the configuration to connect to the Kubernetes API.
+type KubernetesConfig struct {
+	// Kubeconfig the kubeconfig file, empty to use the in-cluster configuration.
+	Kubeconfig string `yaml:"kubeconfig"`
+	// RequestTimeout the timeout of each operation on the Kubernetes API.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+}
+
+// LeaderElectionConfig the configuration of the election of the leader among State
+// Manager replicas. Only the leader restores and watches the Interceptors, the other
+// replicas serve the checkpoint metadata and forward the rest to the leader.
+type LeaderElectionConfig struct {
+	// Enabled runs the State Manager as one of many replicas electing their leader in
+	// etcd, it requires the etcd repository backend.
+	Enabled bool `yaml:"enabled"`
+	// Prefix the etcd key prefix of the election.
+	Prefix string `yaml:"prefix"`
+	// TTL how long the leadership outlives a leader that stopped renewing it.
+	TTL time.Duration `yaml:"ttl"`
+	// AdvertiseURL the URL the other replicas reach this one at.
+	AdvertiseURL string `yaml:"advertiseURL"`
+}
+
+// ContainerConfig the configuration of the monitored application container.
+type ContainerConfig struct {
+	// Namespace the Kubernetes namespace of the pod running the container.
+	Namespace string `yaml:"namespace"`
+	// Pod the name of the pod running the container.
+	Pod string `yaml:"pod"`
+	// ID the unique identifier of the container.
+	ID string `yaml:"id"`
+	// Name the name of the container.
+	Name string `yaml:"name"`
+	// HTTPUrl the URL to access the container.
+	HTTPUrl string `yaml:"httpURL"`
+	// PID the container process identification number.
+	PID int32 `yaml:"pid"`
+}
+
+// Default returns the default configuration.
+func Default() StateManagerConfig {
+	return StateManagerConfig{
+		Port:              8002,
+		ImagesDirectory:   "/var/lib/checkpoints",
+		RestoreBackend:    BackendCRIU,
+		RepositoryBackend: BackendInMemory,
+		ETCD: ETCDConfig{
+			DialTimeout:    5 * time.Second,
+			RequestTimeout: 5 * time.Second,
+		},
+		Kubernetes: KubernetesConfig{
+			RequestTimeout: 10 * time.Second,
+		},
+		LeaderElection: LeaderElectionConfig{
+			Prefix: "statemanager/leader",
+			TTL:    10 * time.Second,
+		},
+		HeartbeatTimeout:    30 * time.Second,
+		ReprojectionTimeout: 10 * time.Minute,
+		MigrationTimeout:    10 * time.Minute,
+		Tracing:             tracing.Default(),
+		Logging:             logging.Default(),
+	}
+}
+
+// Keys returns the configuration keys that can be overridden by environment variables
+// and flags.
+func Keys() []string {
+	return loader.Keys(&StateManagerConfig{})
+}
+
+// Load loads the configuration from the YAML file, when given, overridden by the
+// environment variables prefixed by EnvPrefix and then by the flag values by key. The
+// loaded configuration is validated.
+func Load(filename string, lookupEnv func(string) (string, bool), flagValues map[string]string) (*StateManagerConfig, error) {
+	cfg := Default()
+	if filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loader.ApplyEnv(&cfg, EnvPrefix, lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := loader.ApplyValues(&cfg, flagValues); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration, reporting every invalid value at once.
+func (cfg *StateManagerConfig) Validate() error {
+	var errs []error
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", cfg.Port))
+	}
+	switch cfg.RestoreBackend {
+	case BackendCRIU:
+		if cfg.ImagesDirectory == "" {
+			errs = append(errs, errors.New("imagesDirectory is required by the criu restore backend"))
+		}
+	case BackendStub:
+	default:
+		errs = append(errs, fmt.Errorf("restoreBackend must be %q or %q, got %q", BackendCRIU, BackendStub, cfg.RestoreBackend))
+	}
+	switch cfg.RepositoryBackend {
+	case BackendInMemory:
+	case BackendETCD:
+		if len(cfg.ETCD.Endpoints) == 0 {
+			errs = append(errs, errors.New("etcd.endpoints is required by the etcd repository backend"))
+		}
+		if cfg.ETCD.RequestTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("etcd.requestTimeout must be positive, got %v", cfg.ETCD.RequestTimeout))
+		}
+	case BackendSQL:
+		if cfg.SQL.Driver != "sqlite" && cfg.SQL.Driver != "postgres" {
+			errs = append(errs, fmt.Errorf("sql.driver must be %q or %q, got %q", "sqlite", "postgres", cfg.SQL.Driver))
+		}
+		if cfg.SQL.DSN == "" {
+			errs = append(errs, errors.New("sql.dsn is required by the sql repository backend"))
+		}
+	case BackendKubernetes:
+		if cfg.Kubernetes.RequestTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("kubernetes.requestTimeout must be positive, got %v", cfg.Kubernetes.RequestTimeout))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("repositoryBackend must be %q, %q, %q or %q, got %q", BackendInMemory, BackendETCD, BackendSQL, BackendKubernetes, cfg.RepositoryBackend))
+	}
+	if cfg.LeaderElection.Enabled {
+		if cfg.RepositoryBackend != BackendETCD {
+			errs = append(errs, fmt.Errorf("leaderElection requires the %q repository backend", BackendETCD))
+		}
+		if advertiseURL, err := url.Parse(cfg.LeaderElection.AdvertiseURL); err != nil || advertiseURL.Scheme == "" || advertiseURL.Host == "" {
+			errs = append(errs, fmt.Errorf("leaderElection.advertiseURL must be an absolute URL, got %q", cfg.LeaderElection.AdvertiseURL))
+		}
+		if cfg.LeaderElection.Prefix == "" {
+			errs = append(errs, errors.New("leaderElection.prefix is required"))
+		}
+		if cfg.LeaderElection.TTL < time.Second {
+			errs = append(errs, fmt.Errorf("leaderElection.ttl must be at least 1s, got %v", cfg.LeaderElection.TTL))
+		}
+	}
+	if cfg.Container.Name != "" && (cfg.Container.Namespace == "" || cfg.Container.Pod == "") {
+		errs = append(errs, errors.New("container.namespace and container.pod are required with container.name"))
+	}
+	if cfg.HeartbeatTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("heartbeatTimeout must be positive, got %v", cfg.HeartbeatTimeout))
+	}
+	if cfg.ReprojectionTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("reprojectionTimeout must be positive, got %v", cfg.ReprojectionTimeout))
+	}
+	if cfg.MigrationTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("migrationTimeout must be positive, got %v", cfg.MigrationTimeout))
+	}
+	if cfg.Security.TLSEnabled() != (cfg.Security.CertFile != "" || cfg.Security.KeyFile != "") {
+		errs = append(errs, errors.New("security.certFile and security.keyFile must be set together"))
+	}
+	if err := cfg.Security.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.Logging.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// YAML encodes the configuration in YAML with secrets redacted.
+func (cfg StateManagerConfig) YAML() ([]byte, error) {
+	cfg.Security = cfg.Security.Redacted()
+	if cfg.SQL.DSN != "" {
+		cfg.SQL.DSN = security.RedactedValue
+	}
+	return yaml.Marshal(cfg)
 }
@@ -0,0 +1,42 @@
+package tracing
+
+import "fmt"
+
+// Exporters of the trace spans.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Config is the tracing configuration of the Interceptor and State Manager.
+type Config struct {
+	// Exporter is where spans are exported, either "none" or "otlp".
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host and port of the OTLP HTTP collector, the exporter default
+	// or OTEL_EXPORTER_OTLP_ENDPOINT when empty.
+	Endpoint string `yaml:"endpoint"`
+	// Insecure sends the spans to the collector without TLS.
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is the fraction of the traces started by the component that are
+	// sampled, traces propagated by the caller follow its decision.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Default returns the configuration with tracing disabled.
+func Default() Config {
+	return Config{
+		Exporter:    ExporterNone,
+		SampleRatio: 1,
+	}
+}
+
+// Validate checks the exporter and the sample ratio.
+func (cfg Config) Validate() error {
+	if cfg.Exporter != ExporterNone && cfg.Exporter != ExporterOTLP {
+		return fmt.Errorf("tracing.exporter must be %q or %q, got %q", ExporterNone, ExporterOTLP, cfg.Exporter)
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sampleRatio must be between 0 and 1, got %v", cfg.SampleRatio)
+	}
+	return nil
+}
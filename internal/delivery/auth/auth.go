@@ -0,0 +1,173 @@
+// Package auth authenticates and authorizes the callers of the Interceptor and State
+// Manager APIs.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthenticated is returned by an Authenticator when the request carries no
+// credentials it can verify.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Name is the name of the caller, the certificate common name, the name bound to a
+	// static token or the Kubernetes username of a ServiceAccount.
+	Name string
+	// Groups are the groups the caller belongs to.
+	Groups []string
+}
+
+// Authenticator authenticates a HTTP request.
+type Authenticator interface {
+	// Authenticate returns the identity of the caller of the request.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityContextKey struct{}
+
+// IdentityFromContext returns the identity authenticated by Require, if any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok
+}
+
+// Require only lets through requests authenticated by the authenticator whose
+// identity name or groups are in allowed. An empty allowed list accepts any
+// authenticated identity.
+func Require(authenticator Authenticator, allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			slog.Warn("rejecting unauthenticated request", "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !identity.allowed(allowed) {
+			slog.Warn("rejecting unauthorized request", "path", r.URL.Path, "identity", identity.Name)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey{}, identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serviceAccountPrefix prefixes the Kubernetes username of ServiceAccounts,
+// system:serviceaccount:<namespace>:<name>.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// ServiceAccountNamespace returns the namespace of the identity when it is a
+// Kubernetes ServiceAccount.
+func (identity *Identity) ServiceAccountNamespace() (string, bool) {
+	rest, ok := strings.CutPrefix(identity.Name, serviceAccountPrefix)
+	if !ok {
+		return "", false
+	}
+	namespace, _, ok := strings.Cut(rest, ":")
+	return namespace, ok
+}
+
+func (identity *Identity) allowed(allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, name := range allowed {
+		if name == identity.Name {
+			return true
+		}
+		for _, group := range identity.Groups {
+			if name == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type anyAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// Any authenticates the request with the first of the authenticators to succeed.
+func Any(authenticators ...Authenticator) Authenticator {
+	return &anyAuthenticator{authenticators: authenticators}
+}
+
+func (a *anyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	var errs []error
+	for _, authenticator := range a.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err == nil {
+			return identity, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(append([]error{ErrUnauthenticated}, errs...)...)
+}
+
+type clientCertificateAuthenticator struct{}
+
+// ClientCertificate authenticates requests by the common name of their verified TLS
+// client certificate.
+func ClientCertificate() Authenticator {
+	return &clientCertificateAuthenticator{}
+}
+
+func (a *clientCertificateAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	certificate := r.TLS.VerifiedChains[0][0]
+	return &Identity{
+		Name:   certificate.Subject.CommonName,
+		Groups: certificate.Subject.Organization,
+	}, nil
+}
+
+type staticTokenAuthenticator struct {
+	tokens map[string]string
+}
+
+// StaticTokens authenticates requests by their bearer token, mapping each token to
+// the name of its identity.
+func StaticTokens(tokens map[string]string) Authenticator {
+	return &staticTokenAuthenticator{tokens: tokens}
+}
+
+func (a *staticTokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	// Every token is compared in constant time, so the time taken tells nothing about
+	// how close the token is to a valid one.
+	var name string
+	found := false
+	for valid, validName := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+			name, found = validName, true
+		}
+	}
+	if !found {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Name: name}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequire(t *testing.T) {
+	authenticator := StaticTokens(map[string]string{
+		"manager-token":     "statemanager",
+		"interceptor-token": "interceptor",
+	})
+	handler := Require(authenticator, []string{"statemanager"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok || identity.Name != "statemanager" {
+			t.Errorf("expected identity %q in context, got %v\n", "statemanager", identity)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "it should reject requests without token", token: "", status: http.StatusUnauthorized},
+		{name: "it should reject unknown tokens", token: "unknown", status: http.StatusUnauthorized},
+		{name: "it should forbid identities not allowed on the route", token: "interceptor-token", status: http.StatusForbidden},
+		{name: "it should accept allowed identities", token: "manager-token", status: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/reproject", nil)
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != c.status {
+				t.Errorf("expected status %d, got %d\n", c.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+	var reviews atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reviews.Add(1)
+		if r.URL.Path != tokenReviewPath {
+			t.Errorf("expected path %q, got %q\n", tokenReviewPath, r.URL.Path)
+		}
+
+		var review map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&review)
+		token := review["spec"].(map[string]interface{})["token"]
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": map[string]interface{}{
+				"authenticated": token == "valid",
+				"user": map[string]interface{}{
+					"username": "system:serviceaccount:default:statemanager",
+					"groups":   []string{"system:serviceaccounts"},
+				},
+			},
+		})
+	}))
+	defer apiServer.Close()
+
+	authenticator, err := TokenReview(TokenReviewConfig{APIServerURL: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should authenticate valid ServiceAccount tokens", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer valid")
+		identity, err := authenticator.Authenticate(req)
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if identity.Name != "system:serviceaccount:default:statemanager" {
+			t.Errorf("expected ServiceAccount username, got %q\n", identity.Name)
+		}
+	})
+
+	t.Run("it should reject tokens the API server does not authenticate", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		if _, err := authenticator.Authenticate(req); err == nil {
+			t.Error("expected an error authenticating invalid token")
+		}
+	})
+
+	t.Run("it should not review again a token authenticated recently", func(t *testing.T) {
+		before := reviews.Load()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer valid")
+		if _, err := authenticator.Authenticate(req); err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if reviewed := reviews.Load() - before; reviewed != 0 {
+			t.Errorf("expected the cached review to be used, got %d reviews\n", reviewed)
+		}
+	})
+
+	t.Run("it should not review again a token denied recently", func(t *testing.T) {
+		before := reviews.Load()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		if _, err := authenticator.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected ErrUnauthenticated, received %v\n", err)
+		}
+		if reviewed := reviews.Load() - before; reviewed != 0 {
+			t.Errorf("expected the cached denial to be used, got %d reviews\n", reviewed)
+		}
+	})
+
+	t.Run("it should review again a denied token once the negative cache expired", func(t *testing.T) {
+		authenticator, err := TokenReview(TokenReviewConfig{APIServerURL: apiServer.URL, NegativeCacheTTL: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := reviews.Load()
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer invalid")
+			if _, err := authenticator.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("expected ErrUnauthenticated, received %v\n", err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if reviewed := reviews.Load() - before; reviewed != 2 {
+			t.Errorf("expected 2 reviews, got %d\n", reviewed)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const tokenReviewPath = "/apis/authentication.k8s.io/v1/tokenreviews"
+
+// DefaultTokenReviewCacheTTL is how long a reviewed token is trusted when the
+// configuration sets no CacheTTL.
+const DefaultTokenReviewCacheTTL = 10 * time.Second
+
+// DefaultTokenReviewNegativeCacheTTL is how long a denied token is rejected without a
+// new review when the configuration sets no NegativeCacheTTL.
+const DefaultTokenReviewNegativeCacheTTL = 2 * time.Second
+
+// TokenReviewConfig is the configuration to authenticate Kubernetes ServiceAccount
+// tokens through the TokenReview API.
+type TokenReviewConfig struct {
+	// APIServerURL is the URL of the Kubernetes API server.
+	APIServerURL string
+	// TokenFile is the file with the token used to call the TokenReview API, usually
+	// the ServiceAccount token mounted in the pod.
+	TokenFile string
+	// CAFile is the file with the certificate authority of the API server.
+	CAFile string
+	// Audiences are the audiences the reviewed tokens must be issued for.
+	Audiences []string
+	// CacheTTL is how long an authenticated token is trusted before being reviewed
+	// again, DefaultTokenReviewCacheTTL when zero.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long a token the API server denied is rejected before
+	// being reviewed again, DefaultTokenReviewNegativeCacheTTL when zero. It is kept
+	// short so a token just issued is not rejected for long.
+	NegativeCacheTTL time.Duration
+}
+
+type cachedReview struct {
+	identity *Identity
+	err      error
+	expires  time.Time
+}
+
+type tokenReviewAuthenticator struct {
+	httpClient *http.Client
+	config     TokenReviewConfig
+	mutex      sync.Mutex
+	// cache holds the reviews of the tokens by their SHA-256, so every administrative
+	// call does not hit the API server.
+	cache map[[sha256.Size]byte]cachedReview
+}
+
+// TokenReview authenticates requests by sending their bearer token to the Kubernetes
+// TokenReview API.
+func TokenReview(cfg TokenReviewConfig) (Authenticator, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pool, err := certPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultTokenReviewCacheTTL
+	}
+	if cfg.NegativeCacheTTL == 0 {
+		cfg.NegativeCacheTTL = DefaultTokenReviewNegativeCacheTTL
+	}
+
+	return &tokenReviewAuthenticator{
+		httpClient: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		config:     cfg,
+		cache:      map[[sha256.Size]byte]cachedReview{},
+	}, nil
+}
+
+func (a *tokenReviewAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	a.mutex.Lock()
+	cached, ok := a.cache[key]
+	a.mutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.identity, cached.err
+	}
+
+	// The denials of the API server are cached too, the failures to reach it are not.
+	identity, err := a.review(r, token)
+	ttl := a.config.CacheTTL
+	if err != nil {
+		if !errors.Is(err, ErrUnauthenticated) {
+			return nil, err
+		}
+		ttl = a.config.NegativeCacheTTL
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for key, cached := range a.cache {
+		if !now.Before(cached.expires) {
+			delete(a.cache, key)
+		}
+	}
+	a.cache[key] = cachedReview{identity: identity, err: err, expires: now.Add(ttl)}
+	return identity, err
+}
+
+// review sends the token to the TokenReview API.
+func (a *tokenReviewAuthenticator) review(r *http.Request, token string) (*Identity, error) {
+	type tokenReviewSpec struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences,omitempty"`
+	}
+	type tokenReview struct {
+		APIVersion string          `json:"apiVersion"`
+		Kind       string          `json:"kind"`
+		Spec       tokenReviewSpec `json:"spec"`
+		Status     struct {
+			Authenticated bool `json:"authenticated"`
+			User          struct {
+				Username string   `json:"username"`
+				Groups   []string `json:"groups"`
+			} `json:"user"`
+			Error string `json:"error"`
+		} `json:"status"`
+	}
+
+	body, err := json.Marshal(tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token, Audiences: a.config.Audiences},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, a.config.APIServerURL+tokenReviewPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.TokenFile != "" {
+		reviewerToken, err := os.ReadFile(a.config.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(reviewerToken)))
+	}
+
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token review status code is %d", res.StatusCode)
+	}
+
+	var review tokenReview
+	if err := json.NewDecoder(res.Body).Decode(&review); err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, review.Status.Error)
+	}
+
+	return &Identity{
+		Name:   review.Status.User.Username,
+		Groups: review.Status.User.Groups,
+	}, nil
+}
@@ -2,7 +2,6 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
@@ -19,29 +18,19 @@ func GetImageMetadata(stateManagerUseCase usecase.StateManagerUseCase) *getImage
 }
 
 func (handler *getImageMetadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	containerID, err := retrieveContainerIDFromURL(*r.URL)
-	if err != nil {
+	path, err := ParseContainerPath(*r.URL)
+	if err != nil || path.Resource != ResourceCheckpoints {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	type httpBody struct {
-		Hash string `json:"hash"`
-	}
-
-	var body httpBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	checkpointHash := fmt.Sprintf("%s-%s", containerID, body.Hash)
-	metadata, err := handler.stateManagerUseCase.RetrieveImageMetadata(checkpointHash)
+	metadata, err := handler.stateManagerUseCase.RetrieveImageMetadata(path.Identity, path.CheckpointHash)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(metadata); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type heartbeatHandler struct {
+	stateManagerUseCase usecase.StateManagerUseCase
+}
+
+func Heartbeat(stateManagerUseCase usecase.StateManagerUseCase) *heartbeatHandler {
+	return &heartbeatHandler{
+		stateManagerUseCase: stateManagerUseCase,
+	}
+}
+
+func (handler *heartbeatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, err := ParseContainerPath(*r.URL)
+	if err != nil || path.Resource != ResourceHeartbeat {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var heartbeat entity.Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil || heartbeat.InterceptorID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	heartbeat.Container = path.Identity
+
+	if err := handler.stateManagerUseCase.HeartbeatInterceptor(&heartbeat); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type interceptorsHandler struct {
+	stateManagerUseCase usecase.StateManagerUseCase
+}
+
+// Interceptors serves the registry of the Interceptors.
+func Interceptors(stateManagerUseCase usecase.StateManagerUseCase) *interceptorsHandler {
+	return &interceptorsHandler{
+		stateManagerUseCase: stateManagerUseCase,
+	}
+}
+
+func (handler *interceptorsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := handler.stateManagerUseCase.Interceptors()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type migrateHandler struct {
+	stateManagerUseCase usecase.StateManagerUseCase
+}
+
+func Migrate(stateManagerUseCase usecase.StateManagerUseCase) *migrateHandler {
+	return &migrateHandler{
+		stateManagerUseCase: stateManagerUseCase,
+	}
+}
+
+func (handler *migrateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request entity.MigrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if request.Source.Validate() != nil || request.Target.Validate() != nil || request.TargetURL == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	migration, err := handler.stateManagerUseCase.Migrate(r.Context(), &request)
+	if err != nil {
+		slog.Error("migration failed", "source", request.Source.String(), "target", request.Target.String(), logging.Error(err))
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(migration)
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type registerInterceptorHandler struct {
+	stateManagerUseCase usecase.StateManagerUseCase
+}
+
+func RegisterInterceptor(stateManagerUseCase usecase.StateManagerUseCase) *registerInterceptorHandler {
+	return &registerInterceptorHandler{
+		stateManagerUseCase: stateManagerUseCase,
+	}
+}
+
+func (handler *registerInterceptorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, err := ParseContainerPath(*r.URL)
+	if err != nil || path.Resource != ResourceContainer {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var registration entity.InterceptorRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil || registration.InterceptorID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// The path is authoritative, it is what the caller was authorized for.
+	registration.Container = path.Identity
+
+	if _, err := handler.stateManagerUseCase.RegisterInterceptor(&registration); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type restoreHandler struct {
+	stateManagerUseCase usecase.StateManagerUseCase
+}
+
+func Restore(stateManagerUseCase usecase.StateManagerUseCase) *restoreHandler {
+	return &restoreHandler{
+		stateManagerUseCase: stateManagerUseCase,
+	}
+}
+
+func (handler *restoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, err := ParseContainerPath(*r.URL)
+	if err != nil || path.Resource != ResourceRestore {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	restoration, err := handler.stateManagerUseCase.Restore(r.Context(), path.Identity)
+	if err != nil {
+		slog.Error("restore failed", logging.KeyContainer, path.Identity.String(), logging.Error(err))
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restoration)
+}
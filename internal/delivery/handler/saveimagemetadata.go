@@ -2,7 +2,6 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
@@ -20,27 +19,21 @@ func SaveImageMetadata(stateManagerUseCase usecase.StateManagerUseCase) *saveIma
 }
 
 func (handler *saveImageMetadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	type httpBody struct {
-		ImageHash string                   `json:"image_hash"`
-		Metadata  entity.ContainerMetadata `json:"metadata"`
-	}
-
-	containerID, err := retrieveContainerIDFromURL(*r.URL)
-	if err != nil {
+	path, err := ParseContainerPath(*r.URL)
+	if err != nil || path.Resource != ResourceCheckpoints {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	var body httpBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	var metadata entity.ContainerMetadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	checkpointHash := fmt.Sprintf("%s-%s", containerID, body.ImageHash)
-	err = handler.stateManagerUseCase.SaveImageMetadata(checkpointHash, &body.Metadata)
+	err = handler.stateManagerUseCase.SaveImageMetadata(path.Identity, path.CheckpointHash, &metadata)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -1,16 +1,78 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// Resources of a container in the State Manager API.
+const (
+	ResourceContainer   = ""
+	ResourceCheckpoints = "checkpoints"
+	ResourceRestore     = "restore"
+	ResourceHeartbeat   = "heartbeat"
 )
 
-func retrieveContainerIDFromURL(url url.URL) (string, error) {
-	parts := strings.Split(url.Path, "/")
-	if len(parts) >= 3 {
-		containerID := parts[2]
-		return containerID, nil
+// ContainerPath is a parsed /containers/{namespace}/{pod}/{container}[/{resource}[/{hash}]]
+// path.
+type ContainerPath struct {
+	// Identity is the identity of the container.
+	Identity entity.ContainerIdentity
+	// Resource is the resource of the container the path refers to.
+	Resource string
+	// CheckpointHash is the checkpoint of the checkpoints resource.
+	CheckpointHash string
+}
+
+// ParseContainerPath parses the path of a container resource.
+func ParseContainerPath(u url.URL) (*ContainerPath, error) {
+	parts := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	if len(parts) < 4 || parts[0] != "containers" {
+		return nil, fmt.Errorf("path %q does not identify a container", u.Path)
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = unescaped
+	}
+
+	path := &ContainerPath{
+		Identity: entity.ContainerIdentity{
+			Namespace: parts[1],
+			Pod:       parts[2],
+			Container: parts[3],
+		},
+	}
+	if err := path.Identity.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch rest := parts[4:]; {
+	case len(rest) == 0:
+		path.Resource = ResourceContainer
+	case len(rest) == 2 && rest[0] == ResourceCheckpoints && rest[1] != "":
+		path.Resource = ResourceCheckpoints
+		path.CheckpointHash = rest[1]
+	case len(rest) == 1 && (rest[0] == ResourceRestore || rest[0] == ResourceHeartbeat):
+		path.Resource = rest[0]
+	default:
+		return nil, fmt.Errorf("unknown container resource %q", strings.Join(rest, "/"))
+	}
+	return path, nil
+}
+
+// writeError answers with the status code matching the error.
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, entity.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
-	return "", fmt.Errorf("path does not contain container id")
+	w.WriteHeader(http.StatusInternalServerError)
 }
@@ -0,0 +1,36 @@
+package delivery
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+)
+
+// readinessTimeout bounds the checks of a readiness probe, below the default
+// Kubernetes probe timeout.
+const readinessTimeout = 900 * time.Millisecond
+
+// handleHealth registers the liveness probe on /healthz, answering as long as the
+// server runs, and the readiness probe on /readyz, answering 503 with the reason when
+// ready fails. Both are left unauthenticated for the kubelet.
+func handleHealth(mux *http.ServeMux, ready func(ctx context.Context) error) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := ready(ctx); err != nil {
+			slog.Debug("not ready", logging.Error(err))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -1,17 +1,35 @@
 package delivery
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
 	"github.com/google/uuid"
 )
 
 type interceptorServer struct {
-	Port               int
+	Port int
+	// AdminPort is the port of the administrative API, like reprojection, kept apart
+	// from the intercepted traffic. Zero disables it.
+	AdminPort int
+	// RetryAfter is the delay sent in the Retry-After header of the requests refused
+	// while holding them during a restore.
+	RetryAfter         time.Duration
+	Security           *Security
 	InterceptorUseCase usecase.InterceptorUseCase
 }
 
@@ -22,13 +40,32 @@ func InterceptorServer(port int, interceptorUseCase usecase.InterceptorUseCase)
 	}
 }
 
-func (s *interceptorServer) Run() error {
+// Run serves the intercepted traffic and the admin API until the context is done,
+// then shuts both servers down. It returns nil when they were shut down cleanly.
+func (s *interceptorServer) Run(ctx context.Context) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/", tracing.Handler("Proxy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqID := uuid.NewString()
-		log.Printf("Handling request %q\n", reqID)
+		startedAt := time.Now()
 		res, err := s.InterceptorUseCase.InterceptRequest(reqID, r)
-		log.Printf("Request %q handled with err %v and response %v\n", reqID, err, res)
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(res.StatusCode)
+		}
+		slog.Info("request handled",
+			logging.KeyRequestID, reqID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", code,
+			"duration", time.Since(startedAt),
+		)
+		metrics.ProxiedRequests.WithLabelValues(code).Inc()
+		metrics.ProxiedRequestDuration.WithLabelValues(code).Observe(time.Since(startedAt).Seconds())
+		if errors.Is(err, usecase.ErrHoldLimitExceeded) || errors.Is(err, usecase.ErrHoldTimeout) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.RetryAfter.Seconds()))))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -47,8 +84,206 @@ func (s *interceptorServer) Run() error {
 				w.Header().Add(key, value)
 			}
 		}
+	})))
+
+	adminStopped := make(chan struct{})
+	if s.AdminPort != 0 {
+		go func() {
+			defer close(adminStopped)
+			if err := s.runAdmin(ctx); err != nil {
+				slog.Error("interceptor admin server stopped", logging.Error(err))
+			}
+		}()
+	} else {
+		close(adminStopped)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.Port),
+		Handler: mux,
+	}
+	slog.Info("listening", "port", s.Port)
+	err := serve(ctx, server, server.ListenAndServe)
+	if err == nil {
+		<-adminStopped
+	}
+	return err
+}
+
+func (s *interceptorServer) runAdmin(ctx context.Context) error {
+	mux := http.NewServeMux()
+
+	mux.Handle("/reproject", tracing.Handler(RouteReproject, s.Security.protect(RouteReproject, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		version, err := strconv.Atoi(r.URL.Query().Get("version"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The applied version of the restored checkpoint is optional, nothing is
+		// skipped without it.
+		appliedVersion := 0
+		if applied := r.URL.Query().Get("applied"); applied != "" {
+			if appliedVersion, err = strconv.Atoi(applied); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		// The report is sent even when the reprojection failed, it tells which requests
+		// could not be replayed.
+		report, err := s.InterceptorUseCase.Reproject(r.Context(), version, appliedVersion, r.URL.Query().Get("restore"))
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			slog.Error("reprojection failed", logging.KeyVersion, version, logging.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			slog.Error("could not write the reprojection report", logging.Error(err))
+		}
+	}))))
+
+	mux.Handle("/hold", tracing.Handler("Hold", s.Security.protect(RouteReproject, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.InterceptorUseCase.Hold()
+		case http.MethodDelete:
+			s.InterceptorUseCase.Release()
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))))
+
+	s.handleMigration(mux)
+
+	// Metrics are left unauthenticated so Prometheus can scrape them.
+	mux.Handle("/metrics", metrics.InterceptorHandler())
+	handleHealth(mux, s.InterceptorUseCase.Ready)
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := s.InterceptorUseCase.Status()
+		if err != nil {
+			slog.Error("could not get status", logging.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	})
 
-	log.Printf("Listening on port %d\n", s.Port)
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.Port), mux)
+	return s.Security.listenAndServe(ctx, s.AdminPort, mux)
+}
+
+// handleMigration registers the routes the State Manager drives a migration through:
+// checkpointing the container, moving its image and restoring it on the target, and
+// switching the traffic of the source to the target.
+func (s *interceptorServer) handleMigration(mux *http.ServeMux) {
+	protect := func(name string, handler http.HandlerFunc) http.Handler {
+		return tracing.Handler(name, s.Security.protect(RouteMigrate, handler))
+	}
+
+	mux.Handle("/checkpoint", protect("Checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := s.InterceptorUseCase.CreateCheckpoint(r.Context())
+		if errors.Is(err, usecase.ErrCheckpointInProgress) {
+			// The scheduled checkpoint is being made, the caller retries once it is done.
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}))
+
+	mux.Handle("/checkpoints/", protect("CheckpointImage", func(w http.ResponseWriter, r *http.Request) {
+		checkpointHash, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/checkpoints/"), "/image")
+		if !ok || checkpointHash == "" || strings.Contains(checkpointHash, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/x-tar")
+			if err := s.InterceptorUseCase.ExportCheckpoint(checkpointHash, w); err != nil {
+				slog.Error("could not export checkpoint", logging.KeyCheckpointHash, checkpointHash, logging.Error(err))
+				// The status is only sent when the export fails before writing the
+				// archive, a truncated archive fails on the importing side.
+				if errors.Is(err, entity.ErrNotFound) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		case http.MethodPut:
+			if err := s.InterceptorUseCase.ImportCheckpoint(checkpointHash, r.Body); err != nil {
+				slog.Error("could not import checkpoint", logging.KeyCheckpointHash, checkpointHash, logging.Error(err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.Handle("/restore", protect("RestoreCheckpoint", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		checkpointHash := r.URL.Query().Get("hash")
+		if checkpointHash == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.InterceptorUseCase.RestoreCheckpoint(r.Context(), checkpointHash); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	mux.Handle("/switch", protect("SwitchUpstream", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		version, err := strconv.Atoi(r.URL.Query().Get("version"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		upstream := r.URL.Query().Get("upstream")
+		if upstream == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := s.InterceptorUseCase.SwitchUpstream(r.Context(), version, upstream); err != nil {
+			slog.Error("could not switch upstream", logging.KeyVersion, version, logging.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
 }
@@ -0,0 +1,153 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/security"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/auth"
+)
+
+// Names of the routes protected by Security.
+const (
+	RouteRegister         = "register"
+	RouteHeartbeat        = "heartbeat"
+	RouteInterceptors     = "interceptors"
+	RouteSaveMetadata     = "saveMetadata"
+	RouteRetrieveMetadata = "retrieveMetadata"
+	RouteRestore          = "restore"
+	RouteReproject        = "reproject"
+	RouteMigrate          = "migrate"
+)
+
+// StateManagerIdentity is the identity name allowed to trigger restores,
+// reprojections and migrations when the configuration does not authorize any other.
+const StateManagerIdentity = "statemanager"
+
+// Security configures TLS, authentication and authorization of a server.
+type Security struct {
+	// TLSConfig enables TLS on the server when set. Client certificates are verified
+	// when it has ClientCAs.
+	TLSConfig *tls.Config
+	// Authenticator authenticates the callers of the administrative routes. When nil
+	// these routes are refused unless Insecure is set.
+	Authenticator auth.Authenticator
+	// Insecure leaves the administrative routes open when there is no Authenticator.
+	Insecure bool
+	// Authorization maps route names to the identity names or groups allowed to call
+	// them. Routes without an entry accept any authenticated identity.
+	Authorization map[string][]string
+}
+
+// protect wraps the handler of the route with the authentication and authorization
+// configured for it.
+func (s *Security) protect(route string, handler http.Handler) http.Handler {
+	if s == nil || s.Authenticator == nil {
+		if s != nil && s.Insecure {
+			return handler
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slog.Warn("rejecting request, no authentication is configured", "path", r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	return auth.Require(s.Authenticator, s.Authorization[route], handler)
+}
+
+// listenAndServe serves the handler on the port, with TLS when configured, until the
+// context is done.
+func (s *Security) listenAndServe(ctx context.Context, port int, handler http.Handler) error {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
+	}
+
+	if s == nil || s.TLSConfig == nil {
+		slog.Info("listening", "port", port)
+		return serve(ctx, server, server.ListenAndServe)
+	}
+
+	server.TLSConfig = s.TLSConfig
+	slog.Info("listening with TLS", "port", port)
+	return serve(ctx, server, func() error { return server.ListenAndServeTLS("", "") })
+}
+
+// ShutdownTimeout is how long a server stopped with its context waits for the
+// requests in flight.
+const ShutdownTimeout = 10 * time.Second
+
+// serve runs the server with listen until the context is done, then shuts it down. A
+// server shut down this way returns nil once its requests in flight are done or the
+// ShutdownTimeout passed.
+func serve(ctx context.Context, server *http.Server, listen func() error) error {
+	shutdown := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	err := listen()
+	if errors.Is(err, http.ErrServerClosed) {
+		return <-shutdown
+	}
+	return err
+}
+
+// NewSecurity creates the server Security from its configuration.
+func NewSecurity(cfg security.Config) (*Security, error) {
+	s := &Security{Authorization: map[string][]string{
+		RouteRestore:   {StateManagerIdentity},
+		RouteReproject: {StateManagerIdentity},
+		RouteMigrate:   {StateManagerIdentity},
+	}}
+	for route, allowed := range cfg.Authorization {
+		s.Authorization[route] = allowed
+	}
+
+	if cfg.TLSEnabled() {
+		tlsConfig, err := auth.ServerTLSConfig(cfg.CertFile, cfg.KeyFile, cfg.CAFile, cfg.RequireClientCert)
+		if err != nil {
+			return nil, err
+		}
+		s.TLSConfig = tlsConfig
+	}
+
+	if !cfg.AuthenticationEnabled() {
+		if !cfg.Insecure {
+			return nil, cfg.Validate()
+		}
+		slog.Warn("no authentication configured, the administrative routes are open")
+		s.Insecure = true
+		return s, nil
+	}
+
+	var authenticators []auth.Authenticator
+	if s.TLSConfig != nil && s.TLSConfig.ClientCAs != nil {
+		authenticators = append(authenticators, auth.ClientCertificate())
+	}
+	if len(cfg.Tokens) > 0 {
+		authenticators = append(authenticators, auth.StaticTokens(cfg.Tokens))
+	}
+	if cfg.TokenReview {
+		tokenReview, err := auth.TokenReview(auth.TokenReviewConfig{
+			APIServerURL: cfg.APIServerURL,
+			TokenFile:    cfg.ServiceAccountTokenFile,
+			CAFile:       cfg.APIServerCAFile,
+			Audiences:    cfg.Audiences,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokenReview)
+	}
+	s.Authenticator = auth.Any(authenticators...)
+
+	return s, nil
+}
@@ -0,0 +1,50 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	t.Run("it should shut down once the context is done, finishing the requests in flight", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		handling := make(chan struct{})
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(handling)
+			time.Sleep(50 * time.Millisecond)
+		})}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error)
+		go func() { served <- serve(ctx, server, func() error { return server.Serve(listener) }) }()
+
+		responded := make(chan error)
+		go func() {
+			res, err := http.Get("http://" + listener.Addr().String())
+			if err == nil {
+				res.Body.Close()
+			}
+			responded <- err
+		}()
+		<-handling
+		cancel()
+
+		if err := <-responded; err != nil {
+			t.Errorf("expected the request in flight to finish, got %v\n", err)
+		}
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Errorf("expected no error, got %v\n", err)
+			}
+		case <-time.After(ShutdownTimeout):
+			t.Fatal("expected the server to shut down")
+		}
+	})
+}
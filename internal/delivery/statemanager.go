@@ -1,19 +1,32 @@
 package delivery
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/statemanager"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/auth"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/delivery/handler"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
 )
 
 type stateManagerServer struct {
 	Port                int
 	Config              statemanager.StateManagerConfig
+	Security            *Security
 	StateManagerUseCase usecase.StateManagerUseCase
+	// Elector elects the replica serving the registry and the restores, every
+	// request is served locally when nil.
+	Elector entity.LeaderElector
+	// LeaderTransport is the transport of the requests forwarded to the leader.
+	LeaderTransport http.RoundTripper
 }
 
 func StateManager(port int, stateManagerUseCase usecase.StateManagerUseCase, stateManagerConfig statemanager.StateManagerConfig) *stateManagerServer {
@@ -27,30 +40,118 @@ func StateManager(port int, stateManagerUseCase usecase.StateManagerUseCase, sta
 func (s *stateManagerServer) Run() error {
 	mux := http.NewServeMux()
 
-	saveImageMetadataHandler := handler.SaveImageMetadata(s.StateManagerUseCase)
-	getImageMetdataHandler := handler.GetImageMetadata(s.StateManagerUseCase)
+	// Reads of the checkpoint metadata in the shared repository are served by any
+	// replica, everything else by the leader.
+	registerInterceptorHandler := s.forwardToLeader(s.route(RouteRegister, scopeToNamespace(handler.RegisterInterceptor(s.StateManagerUseCase))))
+	heartbeatHandler := s.forwardToLeader(s.route(RouteHeartbeat, scopeToNamespace(handler.Heartbeat(s.StateManagerUseCase))))
+	saveImageMetadataHandler := s.forwardToLeader(s.route(RouteSaveMetadata, scopeToNamespace(handler.SaveImageMetadata(s.StateManagerUseCase))))
+	getImageMetdataHandler := s.route(RouteRetrieveMetadata, scopeToNamespace(handler.GetImageMetadata(s.StateManagerUseCase)))
+	restoreHandler := s.forwardToLeader(s.route(RouteRestore, handler.Restore(s.StateManagerUseCase)))
 
+	// Metrics are left unauthenticated so Prometheus can scrape them.
+	mux.Handle("/metrics", metrics.StateManagerHandler())
+	handleHealth(mux, s.StateManagerUseCase.Ready)
+
+	mux.Handle("/migrations", s.forwardToLeader(s.route(RouteMigrate, handler.Migrate(s.StateManagerUseCase))))
+	mux.Handle("/interceptors", s.forwardToLeader(s.route(RouteInterceptors, handler.Interceptors(s.StateManagerUseCase))))
 	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
+		path, err := handler.ParseContainerPath(*r.URL)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case path.Resource == handler.ResourceContainer && r.Method == http.MethodPut:
+			registerInterceptorHandler.ServeHTTP(w, r)
+		case path.Resource == handler.ResourceHeartbeat && r.Method == http.MethodPost:
+			heartbeatHandler.ServeHTTP(w, r)
+		case path.Resource == handler.ResourceCheckpoints && r.Method == http.MethodPost:
 			saveImageMetadataHandler.ServeHTTP(w, r)
-		} else if r.Method == http.MethodGet {
+		case path.Resource == handler.ResourceCheckpoints && r.Method == http.MethodGet:
 			getImageMetdataHandler.ServeHTTP(w, r)
-		} else {
+		case path.Resource == handler.ResourceRestore && r.Method == http.MethodPost:
+			restoreHandler.ServeHTTP(w, r)
+		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
 
 	if s.Config.DevelopmentFeaturesEnabled {
-		mux.HandleFunc("/checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		mux.Handle("/checkpoint", s.forwardToLeader(s.route(RouteRestore, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			containerName := r.URL.Query().Get("name")
 			containerHash := r.URL.Query().Get("hash")
-			if err := s.StateManagerUseCase.DevelopmentRestore(containerName, containerHash); err != nil {
-				log.Println(err)
+			if err := s.StateManagerUseCase.DevelopmentRestore(r.Context(), containerName, containerHash); err != nil {
+				slog.Error("development restore failed", logging.KeyContainer, containerName, logging.KeyCheckpointHash, containerHash, logging.Error(err))
 				w.WriteHeader(http.StatusInternalServerError)
 			}
-		})
+		}))))
+	}
+
+	return s.Security.listenAndServe(context.Background(), s.Port, mux)
+}
+
+// scopeToNamespace rejects ServiceAccounts acting on containers of other namespaces,
+// so tenants cannot read or overwrite the checkpoints of each other.
+func scopeToNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		namespace, isServiceAccount := identity.ServiceAccountNamespace()
+		if !isServiceAccount {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path, err := handler.ParseContainerPath(*r.URL)
+		if err != nil || path.Identity.Namespace != namespace {
+			slog.Warn("rejecting request to a container of another namespace", "path", r.URL.Path, "identity", identity.Name)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardToLeader serves the request when this replica is the leader and forwards it
+// untouched to the leader otherwise, so the leader authenticates the original caller.
+func (s *stateManagerServer) forwardToLeader(next http.Handler) http.Handler {
+	if s.Elector == nil {
+		return next
 	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Elector.IsLeader() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader, err := s.Elector.Leader(r.Context())
+		if err == nil && leader == s.Config.LeaderElection.AdvertiseURL {
+			// The lease of a previous run of this replica has not expired yet.
+			err = entity.ErrNoLeader
+		}
+		var leaderURL *url.URL
+		if err == nil {
+			leaderURL, err = url.Parse(leader)
+		}
+		if err != nil {
+			slog.Warn("cannot forward the request to the leader", "path", r.URL.Path, logging.Error(err))
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(leaderURL)
+		proxy.Transport = s.LeaderTransport
+		proxy.ServeHTTP(w, r)
+	})
+}
 
-	log.Printf("Listening on port %d\n", s.Port)
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.Port), mux)
+// route protects the handler of the route and instruments it with metrics and
+// tracing.
+func (s *stateManagerServer) route(name string, handler http.Handler) http.Handler {
+	return tracing.Handler(name, metrics.InstrumentAPI(name, s.Security.protect(name, handler)))
 }
@@ -1,5 +1,14 @@
 package entity
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned by repositories when the requested entity does not exist.
+var ErrNotFound = errors.New("not found")
+
 // Container is an abstraction of every application running in a container.
 type Container struct {
 	// ID is the unique identifier of the container in UUID.
@@ -10,12 +19,62 @@ type Container struct {
 	HTTPUrl string
 	// Name of the container.
 	Name string
+	// Namespace is the Kubernetes namespace of the pod running the container.
+	Namespace string
+	// Pod is the name of the pod running the container.
+	Pod string
+}
+
+// Identity returns the identity of the container in the cluster.
+func (container *Container) Identity() ContainerIdentity {
+	return ContainerIdentity{
+		Namespace: container.Namespace,
+		Pod:       container.Pod,
+		Container: container.Name,
+	}
+}
+
+// ContainerIdentity identifies a container in the cluster, namespacing everything
+// the State Manager stores about it.
+type ContainerIdentity struct {
+	// Namespace is the Kubernetes namespace of the pod.
+	Namespace string `json:"namespace"`
+	// Pod is the name of the pod.
+	Pod string `json:"pod"`
+	// Container is the name of the container in the pod.
+	Container string `json:"container"`
+}
+
+// String returns the identity as namespace/pod/container.
+func (identity ContainerIdentity) String() string {
+	return identity.Namespace + "/" + identity.Pod + "/" + identity.Container
+}
+
+// Validate checks every part of the identity is set and usable as a path segment.
+func (identity ContainerIdentity) Validate() error {
+	for name, part := range map[string]string{
+		"namespace": identity.Namespace,
+		"pod":       identity.Pod,
+		"container": identity.Container,
+	} {
+		if part == "" {
+			return fmt.Errorf("container identity %s is required", name)
+		}
+		if strings.Contains(part, "/") {
+			return fmt.Errorf("container identity %s %q must not contain '/'", name, part)
+		}
+	}
+	return nil
 }
 
 // ContainerRepository is the definition of data access to Container.
 type ContainerRepository interface {
 	// GetByID gets a Container by its id.
 	GetByID(id string) (*Container, error)
+	// GetByIdentity gets a Container by its identity in the cluster.
+	GetByIdentity(identity ContainerIdentity) (*Container, error)
 	// Create creates a new Container.
 	Create(container *Container) error
+	// Update replaces the stored Container with the same id.
+	Update(container *Container) error
 }
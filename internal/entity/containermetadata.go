@@ -7,4 +7,28 @@ type ContainerMetadata struct {
 	LastTimestamp time.Time `json:"last_timestamp"`
 	// LastRequestSolvedID latest request id solved by the Interceptor.
 	LastRequestSolvedID string `json:"last_request_solved_id"`
+	// LastVersion is the version up to which every request intercepted before the
+	// checkpoint was solved or failed, the requests after it are reprojected once the
+	// checkpoint is restored.
+	LastVersion int `json:"last_version"`
+}
+
+// CheckpointEvent tells a checkpoint became the latest of its container.
+type CheckpointEvent struct {
+	// Container is the identity of the checkpointed container.
+	Container ContainerIdentity `json:"container"`
+	// CheckpointHash is the hash of the checkpoint.
+	CheckpointHash string `json:"checkpoint_hash"`
+}
+
+// Checkpoint is a checkpoint of a container recorded by the State Manager.
+type Checkpoint struct {
+	// Container is the identity of the checkpointed container.
+	Container ContainerIdentity `json:"container"`
+	// Hash is the hash of the checkpoint image.
+	Hash string `json:"hash"`
+	// Metadata is the metadata of the checkpoint.
+	Metadata ContainerMetadata `json:"metadata"`
+	// CreatedAt is when the State Manager recorded the checkpoint.
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,22 @@
+package entity
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoLeader is returned by a LeaderElector when no replica is the leader.
+var ErrNoLeader = errors.New("no leader elected")
+
+// LeaderElector elects a single leader among the replicas of the State Manager, the
+// only one orchestrating restores and watching the Interceptors.
+type LeaderElector interface {
+	// Run campaigns for the leadership until the context is done. Every time this
+	// replica is elected lead is called with a context cancelled once the leadership
+	// is lost.
+	Run(ctx context.Context, lead func(ctx context.Context)) error
+	// IsLeader tells whether this replica is the leader.
+	IsLeader() bool
+	// Leader returns the URL of the leader replica, ErrNoLeader when there is none.
+	Leader(ctx context.Context) (string, error)
+}
@@ -9,14 +9,23 @@ import (
 type InterceptedRequest struct {
 	// ID is an unique identifier as UUID of the request, assigned to it when it comes to the application.
 	ID string
-	// SolvedAt is the datetime the request was solved by the monitored application.
+	// SolvedAt is the datetime the request was first solved by the monitored
+	// application.
 	SolvedAt *time.Time
+	// ReceivedAt is the datetime the request was received, in version order. With
+	// SolvedAt it tells which requests were processed concurrently.
+	ReceivedAt time.Time
 	// Request the representation of the HTTP request.
 	Request *http.Request
 	// Solved indicates whether or not the request was already solved.
 	Solved bool
 	// Version indicates the event version of this request in the event sourcing.
 	Version int
+	// Body is the captured body of the request, used to replay it.
+	Body []byte
+	// BodyTruncated indicates the body was larger than the capture limit and Body only
+	// holds its beginning, so the request can not be faithfully replayed.
+	BodyTruncated bool
 }
 
 type InterceptedRequestRepository interface {
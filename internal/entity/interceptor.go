@@ -1,6 +1,10 @@
 package entity
 
-import "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+import (
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+)
 
 // Interceptor is the structure of the Ambassador pattern to monitor containers.
 // The Interceptor must intercept traffic from the container and save it in a buffer,
@@ -16,6 +20,136 @@ type Interceptor struct {
 	// Config is the configuration of the Interceptor, containing information like
 	// the interval for making checkpoints.
 	Config *interceptor.Config
+	// AdminURL is the URL of the Interceptor admin API, used by the State Manager to
+	// reproject requests after a restore.
+	AdminURL string
+	// State is the state of the Interceptor in the State Manager registry, either
+	// InterceptorActive or InterceptorLost.
+	State string
+	// RegisteredAt is when the Interceptor last registered.
+	RegisteredAt time.Time
+	// LastHeartbeat is when the last heartbeat of the Interceptor was received.
+	LastHeartbeat time.Time
+	// LastVersion is the version of the last request reported by the Interceptor.
+	LastVersion int
+	// LastCheckpoint is when the last successful checkpoint reported by the
+	// Interceptor was made.
+	LastCheckpoint *time.Time
+	// LastCheckpointHash is the hash of the last checkpoint reported by the Interceptor.
+	LastCheckpointHash string
+}
+
+// States of an Interceptor in the State Manager registry.
+const (
+	// InterceptorActive is the state of an Interceptor sending heartbeats.
+	InterceptorActive = "active"
+	// InterceptorLost is the state of an Interceptor that missed its heartbeats.
+	InterceptorLost = "lost"
+)
+
+// Entry returns the view of the Interceptor in the State Manager registry.
+func (interceptor *Interceptor) Entry() InterceptorRegistryEntry {
+	entry := InterceptorRegistryEntry{
+		ID:                 interceptor.ID,
+		AdminURL:           interceptor.AdminURL,
+		State:              interceptor.State,
+		RegisteredAt:       interceptor.RegisteredAt,
+		LastHeartbeat:      interceptor.LastHeartbeat,
+		LastVersion:        interceptor.LastVersion,
+		LastCheckpoint:     interceptor.LastCheckpoint,
+		LastCheckpointHash: interceptor.LastCheckpointHash,
+	}
+	if interceptor.MonitoredContainer != nil {
+		entry.Container = interceptor.MonitoredContainer.Identity()
+	}
+	if interceptor.Config != nil {
+		entry.CheckpointingInterval = interceptor.Config.CheckpointingInterval.String()
+	}
+	return entry
+}
+
+// InterceptorRegistration is sent by an Interceptor to register itself and its
+// monitored container in the State Manager.
+type InterceptorRegistration struct {
+	// InterceptorID is the unique identifier of the Interceptor.
+	InterceptorID string `json:"interceptor_id"`
+	// AdminURL is the URL of the Interceptor admin API.
+	AdminURL string `json:"admin_url,omitempty"`
+	// Container is the identity of the monitored container.
+	Container ContainerIdentity `json:"container"`
+	// PID is the process identification number of the monitored container.
+	PID int32 `json:"pid"`
+	// HTTPUrl is the URL of the monitored container.
+	HTTPUrl string `json:"http_url"`
+	// Config is the configuration of the Interceptor in YAML with secrets redacted.
+	Config string `json:"config,omitempty"`
+}
+
+// Heartbeat is sent periodically by a registered Interceptor to tell the State
+// Manager it is alive and how far it is.
+type Heartbeat struct {
+	// InterceptorID is the unique identifier of the Interceptor.
+	InterceptorID string `json:"interceptor_id"`
+	// Container is the identity of the monitored container.
+	Container ContainerIdentity `json:"container"`
+	// LastVersion is the version of the last intercepted request.
+	LastVersion int `json:"last_version"`
+	// LastCheckpoint is when the last successful checkpoint was made.
+	LastCheckpoint *time.Time `json:"last_checkpoint,omitempty"`
+	// LastCheckpointHash is the hash of the last successful checkpoint.
+	LastCheckpointHash string `json:"last_checkpoint_hash,omitempty"`
+}
+
+// InterceptorRegistryEntry is the view of a registered Interceptor in the State
+// Manager registry.
+type InterceptorRegistryEntry struct {
+	// ID is the unique identifier of the Interceptor.
+	ID string `json:"id"`
+	// Container is the identity of the monitored container.
+	Container ContainerIdentity `json:"container"`
+	// AdminURL is the URL of the Interceptor admin API.
+	AdminURL string `json:"admin_url,omitempty"`
+	// State is either InterceptorActive or InterceptorLost.
+	State string `json:"state"`
+	// RegisteredAt is when the Interceptor last registered.
+	RegisteredAt time.Time `json:"registered_at"`
+	// LastHeartbeat is when the last heartbeat was received.
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// LastVersion is the version of the last request reported by the Interceptor.
+	LastVersion int `json:"last_version"`
+	// LastCheckpoint is when the last successful checkpoint was made.
+	LastCheckpoint *time.Time `json:"last_checkpoint,omitempty"`
+	// LastCheckpointHash is the hash of the last successful checkpoint.
+	LastCheckpointHash string `json:"last_checkpoint_hash,omitempty"`
+	// CheckpointingInterval is the checkpointing interval of the Interceptor, when it
+	// sent its configuration.
+	CheckpointingInterval string `json:"checkpointing_interval,omitempty"`
+}
+
+// InterceptorStatus is the current state of an Interceptor.
+type InterceptorStatus struct {
+	// ID is the unique identifier of the interceptor.
+	ID string `json:"id"`
+	// ContainerName is the name of the monitored container.
+	ContainerName string `json:"container_name"`
+	// LastVersion is the version of the last intercepted request.
+	LastVersion int `json:"last_version"`
+	// LastCheckpoint is when the last successful checkpoint was made.
+	LastCheckpoint *time.Time `json:"last_checkpoint,omitempty"`
+	// LastCheckpointHash is the hash of the last successful checkpoint.
+	LastCheckpointHash string `json:"last_checkpoint_hash,omitempty"`
+	// NextCheckpoint is when the next checkpoint is planned, unset when it depends on
+	// the traffic rather than on time.
+	NextCheckpoint *time.Time `json:"next_checkpoint,omitempty"`
+	// LastCheckpointError is the error of the last checkpoint when it failed.
+	LastCheckpointError string `json:"last_checkpoint_error,omitempty"`
+	// LastCheckpointFailure is when the last checkpoint failed, unset once a
+	// checkpoint succeeds.
+	LastCheckpointFailure *time.Time `json:"last_checkpoint_failure,omitempty"`
+	// Holding tells the requests are held while the container is restored.
+	Holding bool `json:"holding"`
+	// HeldRequests is the number of requests held.
+	HeldRequests int `json:"held_requests"`
 }
 
 // InterceptorRepository is the definition of the data access to the Interceptor.
@@ -28,4 +162,8 @@ type InterceptorRepository interface {
 	Create(interceptor *Interceptor) error
 	// UpdateInterceptorConfig updates the Interceptor configuration.
 	UpdateInterceptorConfig(interceptorId string, config *interceptor.Config) error
+	// Update replaces the stored Interceptor with the same id.
+	Update(interceptor *Interceptor) error
+	// List lists every Interceptor.
+	List() ([]*Interceptor, error)
 }
@@ -0,0 +1,80 @@
+package entity
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// CheckpointResult is a checkpoint made on demand by an Interceptor.
+type CheckpointResult struct {
+	// CheckpointHash is the hash of the checkpoint image.
+	CheckpointHash string `json:"checkpoint_hash"`
+	// Metadata is the metadata saved with the checkpoint.
+	Metadata ContainerMetadata `json:"metadata"`
+}
+
+// CheckpointImage is a checkpoint image stored on a node.
+type CheckpointImage struct {
+	// Hash is the hash of the checkpoint.
+	Hash string
+	// CreatedAt is when the image was dumped or imported.
+	CreatedAt time.Time
+}
+
+// CheckpointImageStore stores the checkpoint images of a node, to move them to
+// another node and prune them.
+type CheckpointImageStore interface {
+	// Export writes the image of the checkpoint as a tar archive.
+	Export(checkpointHash string, w io.Writer) error
+	// Import extracts the image of the checkpoint from a tar archive written by Export.
+	Import(checkpointHash string, r io.Reader) error
+	// List returns the images of the node.
+	List() ([]CheckpointImage, error)
+	// Delete deletes the image of the checkpoint, if any.
+	Delete(checkpointHash string) error
+}
+
+// MigrationRequest asks to migrate a container to a new pod, usually on another node.
+type MigrationRequest struct {
+	// Source is the identity of the migrated container.
+	Source ContainerIdentity `json:"source"`
+	// Target is the identity of the new container, whose Interceptor must be
+	// registered.
+	Target ContainerIdentity `json:"target"`
+	// TargetURL is the URL the Interceptor of the source forwards the requests to once
+	// switched, the traffic port of the Interceptor of the target.
+	TargetURL string `json:"target_url"`
+}
+
+// Migration is the outcome of a migration.
+type Migration struct {
+	// Source is the identity of the migrated container.
+	Source ContainerIdentity `json:"source"`
+	// Target is the identity of the new container.
+	Target ContainerIdentity `json:"target"`
+	// CheckpointHash is the hash of the checkpoint restored in the target.
+	CheckpointHash string `json:"checkpoint_hash"`
+	// FromVersion is the version the requests received during the migration were
+	// replayed from.
+	FromVersion int `json:"from_version"`
+	// StartedAt is when the migration started.
+	StartedAt time.Time `json:"started_at"`
+	// CompletedAt is when the traffic was switched to the target.
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// MigrationService drives the steps of a migration through the admin API of the
+// Interceptors.
+type MigrationService interface {
+	// Checkpoint makes a checkpoint of the container of the Interceptor.
+	Checkpoint(ctx context.Context, adminURL string) (*CheckpointResult, error)
+	// Transfer copies the image of the checkpoint from the node of the source
+	// Interceptor to the node of the target Interceptor.
+	Transfer(ctx context.Context, sourceAdminURL string, targetAdminURL string, checkpointHash string) error
+	// Restore restores the checkpoint in the container of the Interceptor.
+	Restore(ctx context.Context, adminURL string, checkpointHash string) error
+	// Switch has the Interceptor replay the requests from the given version to the
+	// upstream and then forward the new requests to it.
+	Switch(ctx context.Context, adminURL string, fromVersion int, upstream string) error
+}
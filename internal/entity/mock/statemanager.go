@@ -5,6 +5,7 @@
 package mock_entity
 
 import (
+	context "context"
 	reflect "reflect"
 
 	entity "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
@@ -34,16 +35,44 @@ func (m *MockStateManagerService) EXPECT() *MockStateManagerServiceMockRecorder
 	return m.recorder
 }
 
+// Register mocks base method.
+func (m *MockStateManagerService) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Register", ctx, registration)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Register indicates an expected call of Register.
+func (mr *MockStateManagerServiceMockRecorder) Register(ctx, registration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockStateManagerService)(nil).Register), ctx, registration)
+}
+
+// Heartbeat mocks base method.
+func (m *MockStateManagerService) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Heartbeat", ctx, heartbeat)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Heartbeat indicates an expected call of Heartbeat.
+func (mr *MockStateManagerServiceMockRecorder) Heartbeat(ctx, heartbeat interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Heartbeat", reflect.TypeOf((*MockStateManagerService)(nil).Heartbeat), ctx, heartbeat)
+}
+
 // SaveMetadata mocks base method.
-func (m *MockStateManagerService) SaveMetadata(containerName string, metadata *entity.ContainerMetadata) error {
+func (m *MockStateManagerService) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveMetadata", containerName, metadata)
+	ret := m.ctrl.Call(m, "SaveMetadata", ctx, identity, checkpointHash, metadata)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveMetadata indicates an expected call of SaveMetadata.
-func (mr *MockStateManagerServiceMockRecorder) SaveMetadata(containerName, metadata interface{}) *gomock.Call {
+func (mr *MockStateManagerServiceMockRecorder) SaveMetadata(ctx, identity, checkpointHash, metadata interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetadata", reflect.TypeOf((*MockStateManagerService)(nil).SaveMetadata), containerName, metadata)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMetadata", reflect.TypeOf((*MockStateManagerService)(nil).SaveMetadata), ctx, identity, checkpointHash, metadata)
 }
@@ -0,0 +1,8 @@
+package entity
+
+// PIDResolver resolves the PID of the main process of a container, which changes
+// every time the container is restarted or restored.
+type PIDResolver interface {
+	// ResolvePID returns the current PID of the main process of the container.
+	ResolvePID(container *Container) (int32, error)
+}
@@ -0,0 +1,78 @@
+package entity
+
+import "time"
+
+// ReplayResult is the outcome of replaying intercepted requests.
+type ReplayResult struct {
+	// Replayed is the number of requests replayed.
+	Replayed int `json:"replayed"`
+	// Retries is the number of times requests were sent again after a failure.
+	Retries int `json:"retries"`
+	// LastReplayedVersion is the version up to which every request was replayed.
+	LastReplayedVersion int `json:"last_replayed_version"`
+	// Failures are the requests that could not be replayed.
+	Failures []ReplayFailure `json:"failures,omitempty"`
+}
+
+// ReplayFailure is a request that could not be replayed.
+type ReplayFailure struct {
+	// RequestID is the id of the intercepted request.
+	RequestID string `json:"request_id"`
+	// Version is the version of the intercepted request.
+	Version int `json:"version"`
+	// Attempts is the number of times the request was sent.
+	Attempts int `json:"attempts"`
+	// StatusCode is the status of the last response, zero when there was none.
+	StatusCode int `json:"status_code,omitempty"`
+	// Error describes the failure of the last attempt.
+	Error string `json:"error"`
+}
+
+// ReprojectionReport is the outcome of a reprojection.
+type ReprojectionReport struct {
+	ReplayResult
+	// FromVersion is the version the requests are reprojected from.
+	FromVersion int `json:"from_version"`
+	// AppliedVersion is the version up to which the requests are part of the restored
+	// checkpoint.
+	AppliedVersion int `json:"applied_version"`
+	// Restore is the restore of the container the reprojection follows, empty when
+	// the reprojection can not be resumed.
+	Restore string `json:"restore,omitempty"`
+	// ResumedVersion is the last version replayed by the interrupted reprojection this
+	// one resumed, zero when it did not resume one.
+	ResumedVersion int `json:"resumed_version,omitempty"`
+	// Requests is the number of intercepted requests since the version.
+	Requests int `json:"requests"`
+	// Skipped is the number of requests not replayed by reason, either "applied",
+	// "resumed" or "policy".
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// StartedAt is when the reprojection started.
+	StartedAt time.Time `json:"started_at"`
+	// Duration is how long the reprojection took.
+	Duration time.Duration `json:"duration"`
+}
+
+// ReprojectionProgress is the progress of a reprojection, saved as the requests are
+// replayed so an interrupted reprojection resumes after the last replayed version
+// when it is requested again for the same restore.
+type ReprojectionProgress struct {
+	// Restore is the restore of the container the reprojection follows.
+	Restore string `json:"restore"`
+	// FromVersion is the version the reprojection started from.
+	FromVersion int `json:"from_version"`
+	// ReplayedVersion is the version up to which every request was replayed.
+	ReplayedVersion int `json:"replayed_version"`
+	// UpdatedAt is when the progress was saved.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReprojectionProgressRepository stores the progress of the unfinished reprojection.
+type ReprojectionProgressRepository interface {
+	// Get gets the progress, nil when no reprojection is unfinished.
+	Get() (*ReprojectionProgress, error)
+	// Save replaces the stored progress.
+	Save(progress *ReprojectionProgress) error
+	// Clear removes the progress once the reprojection completes.
+	Clear() error
+}
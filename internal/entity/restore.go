@@ -1,5 +1,7 @@
 package entity
 
+import "context"
+
 // RestoreConfig is the configuration to use to restore the application.
 type RestoreConfig struct {
 	// ContainerName is the name of the container to restore.
@@ -13,3 +15,37 @@ type RestoreService interface {
 	// Restore restores the application to a previous image.
 	Restore(config *RestoreConfig) error
 }
+
+// Restoration is the outcome of restoring a container.
+type Restoration struct {
+	// ID identifies the restore, the reprojection following it resumes only for the
+	// same restore.
+	ID string `json:"id"`
+	// Container is the identity of the restored container.
+	Container ContainerIdentity `json:"container"`
+	// CheckpointHash is the hash of the restored checkpoint.
+	CheckpointHash string `json:"checkpoint_hash"`
+	// FromVersion is the version the intercepted requests are reprojected from.
+	FromVersion int `json:"from_version"`
+	// Reprojected tells whether the requests were reprojected, which needs an active
+	// Interceptor with an admin URL registered for the container.
+	Reprojected bool `json:"reprojected"`
+	// Reprojection is the report of the reprojection, nil when it was not attempted.
+	Reprojection *ReprojectionReport `json:"reprojection,omitempty"`
+}
+
+// ReprojectionService asks Interceptors to reproject their intercepted requests.
+type ReprojectionService interface {
+	// Reproject reprojects the requests of the Interceptor at the admin URL, from the
+	// given version to the newest one, after the restore of the given ID. The
+	// Interceptor can skip the requests at or below the applied version, already part
+	// of the restored checkpoint. The report is returned when the Interceptor sent it,
+	// even if the reprojection failed.
+	Reproject(ctx context.Context, adminURL string, fromVersion int, appliedVersion int, restore string) (*ReprojectionReport, error)
+	// Hold has the Interceptor at the admin URL hold the new requests until the
+	// reprojection, while its container is restored.
+	Hold(ctx context.Context, adminURL string) error
+	// Release has the Interceptor at the admin URL forward the held requests without
+	// reprojection, like when the restore failed.
+	Release(ctx context.Context, adminURL string) error
+}
@@ -2,8 +2,23 @@
 
 package entity
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrMetadataQueued is returned by SaveMetadata when the metadata is queued to be
+// sent later, the State Manager did not acknowledge it yet.
+var ErrMetadataQueued = errors.New("metadata queued for the state manager")
+
 // StateManagerService is the service to communicate with the state manager
 type StateManagerService interface {
-	// SaveMedata saves metadata about the specified container.
-	SaveMetadata(containerName string, metadata *ContainerMetadata) error
+	// Register registers the Interceptor and its monitored container.
+	Register(ctx context.Context, registration *InterceptorRegistration) error
+	// Heartbeat tells the State Manager the Interceptor is alive. It returns
+	// ErrNotFound when the Interceptor is not registered.
+	Heartbeat(ctx context.Context, heartbeat *Heartbeat) error
+	// SaveMedata saves metadata about a checkpoint of the identified container. It
+	// returns ErrMetadataQueued when the metadata is saved later.
+	SaveMetadata(ctx context.Context, identity ContainerIdentity, checkpointHash string, metadata *ContainerMetadata) error
 }
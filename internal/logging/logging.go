@@ -0,0 +1,50 @@
+// Package logging sets up the structured logger of the Interceptor and State Manager
+// and names the fields used to correlate their logs.
+package logging
+
+import (
+	"io"
+	"log/slog"
+
+	loggingConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/logging"
+)
+
+// Keys of the fields correlating the logs of a request or checkpoint.
+const (
+	KeyRequestID      = "request_id"
+	KeyInterceptorID  = "interceptor_id"
+	KeyVersion        = "version"
+	KeyContainer      = "container"
+	KeyCheckpointHash = "checkpoint_hash"
+	KeyError          = "error"
+)
+
+// New creates a logger writing to w as configured.
+func New(w io.Writer, cfg loggingConfig.Config) (*slog.Logger, error) {
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	if cfg.Format == loggingConfig.FormatJSON {
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, options)), nil
+}
+
+// Setup makes the configured logger writing to w the default one, also used by the
+// log package.
+func Setup(w io.Writer, cfg loggingConfig.Config) error {
+	logger, err := New(w, cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Error is the field of an error.
+func Error(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,151 @@
+// Package metrics declares the Prometheus metrics of the Interceptor and the State
+// Manager, each registered in its own registry so a binary only exposes its own.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// InterceptorRegistry is the registry of the Interceptor metrics.
+var InterceptorRegistry = prometheus.NewRegistry()
+
+var interceptorFactory = promauto.With(InterceptorRegistry)
+
+// lastCheckpoint is the unix time in nanoseconds of the last successful checkpoint.
+var lastCheckpoint atomic.Int64
+
+var (
+	// ProxiedRequests counts the requests proxied to the monitored container by status
+	// code, "error" when the container could not be reached.
+	ProxiedRequests = interceptorFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "proxied_requests_total",
+		Help:      "Requests proxied to the monitored container by status code.",
+	}, []string{"code"})
+	// ProxiedRequestDuration observes the latency of the proxied requests by status code.
+	ProxiedRequestDuration = interceptorFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "interceptor",
+		Name:      "proxied_request_duration_seconds",
+		Help:      "Latency of the requests proxied to the monitored container by status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code"})
+	// EventLogRequests counts the requests recorded in the event log.
+	EventLogRequests = interceptorFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "event_log_requests_total",
+		Help:      "Requests recorded in the event log.",
+	})
+	// EventLogBytes counts the captured body bytes recorded in the event log.
+	EventLogBytes = interceptorFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "event_log_bytes_total",
+		Help:      "Captured request body bytes recorded in the event log.",
+	})
+	// EventLogVersion is the version of the last request recorded in the event log.
+	EventLogVersion = interceptorFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "event_log_version",
+		Help:      "Version of the last request recorded in the event log.",
+	})
+	// CheckpointDuration observes the duration of the checkpoints by result.
+	CheckpointDuration = interceptorFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "interceptor",
+		Name:      "checkpoint_duration_seconds",
+		Help:      "Duration of the checkpoints by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"result"})
+	// CheckpointFailures counts the failed checkpoints.
+	CheckpointFailures = interceptorFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "checkpoint_failures_total",
+		Help:      "Failed checkpoints.",
+	})
+	// CheckpointSize is the size of the last checkpoint image.
+	CheckpointSize = interceptorFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "checkpoint_size_bytes",
+		Help:      "Size of the last checkpoint image.",
+	})
+	// ReprojectionRequests is the number of requests of the current or last
+	// reprojection.
+	ReprojectionRequests = interceptorFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "reprojection_requests",
+		Help:      "Requests to replay in the current or last reprojection.",
+	})
+	// ReprojectionReplayed is the number of requests already replayed by the current or
+	// last reprojection.
+	ReprojectionReplayed = interceptorFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "reprojection_replayed_requests",
+		Help:      "Requests already replayed by the current or last reprojection.",
+	})
+	// Reprojections counts the reprojections by result.
+	Reprojections = interceptorFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "reprojections_total",
+		Help:      "Reprojections by result.",
+	}, []string{"result"})
+	// ReprojectionSkipped counts the requests reprojections skipped, by reason.
+	ReprojectionSkipped = interceptorFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "reprojection_skipped_requests_total",
+		Help:      "Requests skipped by reprojections, already applied, replayed before an interruption or excluded by their replay policy.",
+	}, []string{"reason"})
+	// HeldRequests is the number of requests held during a restore.
+	HeldRequests = interceptorFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "held_requests",
+		Help:      "Requests held until the restore and reprojection complete.",
+	})
+	// RefusedHeldRequests counts the requests refused while holding, by reason.
+	RefusedHeldRequests = interceptorFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "interceptor",
+		Name:      "refused_held_requests_total",
+		Help:      "Requests refused while holding, because of the limits or of their wait.",
+	}, []string{"reason"})
+)
+
+func init() {
+	interceptorFactory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "interceptor",
+		Name:      "seconds_since_last_checkpoint",
+		Help:      "Seconds since the last successful checkpoint, -1 before the first one.",
+	}, func() float64 {
+		last := lastCheckpoint.Load()
+		if last == 0 {
+			return -1
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	})
+}
+
+// ObserveCheckpoint records a checkpoint that started at startedAt.
+func ObserveCheckpoint(startedAt time.Time, err error) {
+	result := Result(err)
+	CheckpointDuration.WithLabelValues(result).Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		CheckpointFailures.Inc()
+		return
+	}
+	lastCheckpoint.Store(time.Now().UnixNano())
+}
+
+// Result is the result label of an operation that ended with err.
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// InterceptorHandler serves the Interceptor metrics.
+func InterceptorHandler() http.Handler {
+	return promhttp.HandlerFor(InterceptorRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveCheckpoint(t *testing.T) {
+	t.Run("should count failures and not reset last checkpoint", func(t *testing.T) {
+		before := testutil.ToFloat64(CheckpointFailures)
+		ObserveCheckpoint(time.Now(), errors.New("dump failed"))
+		if got := testutil.ToFloat64(CheckpointFailures); got != before+1 {
+			t.Errorf("expected %v failures, got %v\n", before+1, got)
+		}
+	})
+
+	t.Run("should track the time of the last successful checkpoint", func(t *testing.T) {
+		ObserveCheckpoint(time.Now(), nil)
+		rec := httptest.NewRecorder()
+		InterceptorHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if strings.Contains(rec.Body.String(), "interceptor_seconds_since_last_checkpoint -1") {
+			t.Errorf("expected last checkpoint to be set, got %s\n", rec.Body.String())
+		}
+	})
+}
+
+func TestInstrumentAPI(t *testing.T) {
+	t.Run("should record the status code of the handler", func(t *testing.T) {
+		handler := InstrumentAPI("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		rec := httptest.NewRecorder()
+		StateManagerHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if !strings.Contains(rec.Body.String(), `statemanager_api_request_duration_seconds_count{code="418",method="GET",route="test"} 1`) {
+			t.Errorf("expected API latency to be exported, got %s\n", rec.Body.String())
+		}
+	})
+}
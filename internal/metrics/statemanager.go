@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// StateManagerRegistry is the registry of the State Manager metrics.
+var StateManagerRegistry = prometheus.NewRegistry()
+
+var stateManagerFactory = promauto.With(StateManagerRegistry)
+
+var (
+	// Restores counts the restores by result.
+	Restores = stateManagerFactory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "statemanager",
+		Name:      "restores_total",
+		Help:      "Restores by result.",
+	}, []string{"result"})
+	// RestoreDuration observes the duration of the restores by result.
+	RestoreDuration = stateManagerFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "statemanager",
+		Name:      "restore_duration_seconds",
+		Help:      "Duration of the restores by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"result"})
+	// APIRequestDuration observes the latency of the State Manager API by route, method
+	// and status code.
+	APIRequestDuration = stateManagerFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "statemanager",
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of the State Manager API by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	// Interceptors is the number of registered Interceptors by state.
+	Interceptors = stateManagerFactory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "statemanager",
+		Name:      "interceptors",
+		Help:      "Registered Interceptors by state.",
+	}, []string{"state"})
+	// LostInterceptors counts the Interceptors marked as lost after missing their
+	// heartbeats.
+	LostInterceptors = stateManagerFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "statemanager",
+		Name:      "lost_interceptors_total",
+		Help:      "Interceptors marked as lost after missing their heartbeats.",
+	})
+)
+
+// ObserveRestore records a restore that started at startedAt.
+func ObserveRestore(startedAt time.Time, err error) {
+	result := Result(err)
+	Restores.WithLabelValues(result).Inc()
+	RestoreDuration.WithLabelValues(result).Observe(time.Since(startedAt).Seconds())
+}
+
+// StateManagerHandler serves the State Manager metrics.
+func StateManagerHandler() http.Handler {
+	return promhttp.HandlerFor(StateManagerRegistry, promhttp.HandlerOpts{})
+}
+
+// InstrumentAPI observes the latency of the route handler in APIRequestDuration.
+func InstrumentAPI(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		APIRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(startedAt).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,225 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reasons of the conditions of a CheckpointPolicy.
+const (
+	reasonApplied     = "Applied"
+	reasonInvalidSpec = "InvalidSpec"
+)
+
+// interceptorConfigYAML is the part of the Interceptor configuration set by a
+// CheckpointPolicy, the keys match the Interceptor configuration file.
+type interceptorConfigYAML struct {
+	CheckpointingInterval string `yaml:"checkpointingInterval"`
+	CheckpointRetention   int    `yaml:"checkpointRetention,omitempty"`
+	ImagesDirectory       string `yaml:"imagesDirectory,omitempty"`
+}
+
+// configMapName returns the name of the ConfigMap of the CheckpointPolicy.
+func configMapName(policy *v1alpha1.CheckpointPolicy) string {
+	return policy.Name + "-interceptor"
+}
+
+// reconcileCheckpointPolicy writes the Interceptor configuration of the policy to its
+// ConfigMap, mounted by the selected pods whose Interceptors watch it, and prunes the
+// checkpoints of their containers beyond the retention. The Interceptors delete the
+// images beyond the retention themselves, the operator only prunes the Checkpoint
+// resources of the kubernetes backend of the State Manager.
+func (o *operator) reconcileCheckpointPolicy(ctx context.Context, namespace string, name string) error {
+	object, err := o.client.Resource(v1alpha1.CheckpointPolicyResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// The ConfigMap is deleted with the policy owning it.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var policy v1alpha1.CheckpointPolicy
+	if err := fromUnstructured(object, &policy); err != nil {
+		return err
+	}
+	status := policy.Status
+	status.Conditions = append([]metav1.Condition(nil), policy.Status.Conditions...)
+	status.ObservedGeneration = policy.Generation
+
+	selector, err := validateCheckpointPolicy(&policy)
+	if err != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             reasonInvalidSpec,
+			Message:            err.Error(),
+			ObservedGeneration: policy.Generation,
+		})
+		return o.updateCheckpointPolicyStatus(ctx, &policy, status)
+	}
+
+	if err := o.applyConfigMap(ctx, &policy); err != nil {
+		return err
+	}
+
+	pods, err := o.client.Resource(podResource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	if policy.Spec.Retention > 0 {
+		for _, pod := range pods.Items {
+			if err := o.pruneCheckpoints(ctx, namespace, pod.GetName(), policy.Spec.Retention); err != nil {
+				return err
+			}
+		}
+	}
+
+	status.ConfigMap = configMapName(&policy)
+	status.MatchedPods = len(pods.Items)
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonApplied,
+		Message:            fmt.Sprintf("configuration applied to %d pods", len(pods.Items)),
+		ObservedGeneration: policy.Generation,
+	})
+	return o.updateCheckpointPolicyStatus(ctx, &policy, status)
+}
+
+// validateCheckpointPolicy checks the spec of the policy and returns its selector.
+func validateCheckpointPolicy(policy *v1alpha1.CheckpointPolicy) (labels.Selector, error) {
+	var errs []error
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid selector: %w", err))
+	} else if selector.Empty() {
+		errs = append(errs, errors.New("selector must select some pods"))
+	}
+	if policy.Spec.Interval.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("interval must be positive, got %v", policy.Spec.Interval.Duration))
+	}
+	if policy.Spec.Retention < 0 {
+		errs = append(errs, fmt.Errorf("retention must not be negative, got %d", policy.Spec.Retention))
+	}
+	return selector, errors.Join(errs...)
+}
+
+// applyConfigMap creates or updates the ConfigMap of the policy.
+func (o *operator) applyConfigMap(ctx context.Context, policy *v1alpha1.CheckpointPolicy) error {
+	content, err := yaml.Marshal(interceptorConfigYAML{
+		CheckpointingInterval: policy.Spec.Interval.Duration.String(),
+		CheckpointRetention:   policy.Spec.Retention,
+		ImagesDirectory:       policy.Spec.Storage.ImagesDirectory,
+	})
+	if err != nil {
+		return err
+	}
+
+	configMap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(policy),
+			Namespace: policy.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(policy, v1alpha1.GroupVersion.WithKind("CheckpointPolicy")),
+			},
+		},
+		Data: map[string]string{v1alpha1.InterceptorConfigKey: string(content)},
+	}
+
+	resource := o.client.Resource(configMapResource).Namespace(policy.Namespace)
+	existing, err := resource.Get(ctx, configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		object, err := toUnstructured(&configMap)
+		if err != nil {
+			return err
+		}
+		_, err = resource.Create(ctx, object, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var current corev1.ConfigMap
+	if err := fromUnstructured(existing, &current); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(current.Data, configMap.Data) {
+		return nil
+	}
+	current.Data = configMap.Data
+	object, err := toUnstructured(&current)
+	if err != nil {
+		return err
+	}
+	_, err = resource.Update(ctx, object, metav1.UpdateOptions{})
+	return err
+}
+
+// pruneCheckpoints deletes the oldest Checkpoints of each container of the pod beyond
+// the retention, never the latest one.
+func (o *operator) pruneCheckpoints(ctx context.Context, namespace string, pod string, retention int) error {
+	resource := o.client.Resource(v1alpha1.CheckpointResource).Namespace(namespace)
+	selector := labels.SelectorFromSet(labels.Set{v1alpha1.LabelPod: pod})
+	objects, err := resource.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+
+	byContainer := make(map[string][]*v1alpha1.Checkpoint)
+	for i := range objects.Items {
+		var checkpoint v1alpha1.Checkpoint
+		if err := fromUnstructured(&objects.Items[i], &checkpoint); err != nil {
+			return err
+		}
+		byContainer[checkpoint.Spec.Container] = append(byContainer[checkpoint.Spec.Container], &checkpoint)
+	}
+
+	for container, checkpoints := range byContainer {
+		// Newest first, the ones past the retention are deleted.
+		sort.SliceStable(checkpoints, func(i, j int) bool {
+			return checkpoints[j].Spec.LastTimestamp.Before(&checkpoints[i].Spec.LastTimestamp)
+		})
+		kept := 0
+		for _, checkpoint := range checkpoints {
+			if kept < retention || checkpoint.Status.Phase == v1alpha1.CheckpointLatest {
+				kept++
+				continue
+			}
+			err := resource.Delete(ctx, checkpoint.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			slog.Info("pruned checkpoint", "namespace", namespace, "pod", pod, "container", container, "hash", checkpoint.Spec.Hash)
+		}
+	}
+	return nil
+}
+
+// updateCheckpointPolicyStatus updates the status of the policy when it changed, so
+// the update does not trigger another reconciliation.
+func (o *operator) updateCheckpointPolicyStatus(ctx context.Context, policy *v1alpha1.CheckpointPolicy, status v1alpha1.CheckpointPolicyStatus) error {
+	if equality.Semantic.DeepEqual(policy.Status, status) {
+		return nil
+	}
+	policy.Status = status
+	object, err := toUnstructured(policy)
+	if err != nil {
+		return err
+	}
+	_, err = o.client.Resource(v1alpha1.CheckpointPolicyResource).Namespace(policy.Namespace).UpdateStatus(ctx, object, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,170 @@
+// Package operator reconciles the CheckpointPolicy and Restore custom resources.
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Core resources managed by the operator.
+var (
+	podResource       = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	configMapResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+)
+
+// StateManager restores containers, implemented by the State Manager client.
+type StateManager interface {
+	// Restore restores the container to its latest checkpoint and reprojects the
+	// requests intercepted after it.
+	Restore(ctx context.Context, identity entity.ContainerIdentity) (*entity.Restoration, error)
+}
+
+// Config is the configuration of the operator.
+type Config struct {
+	// Namespace restricts the operator to a namespace, every namespace when empty.
+	Namespace string
+	// ResyncInterval is the interval between reconciliations of every resource, which
+	// picks up the changes of the selected pods and of their checkpoints.
+	ResyncInterval time.Duration
+	// Workers is the number of resources reconciled concurrently, a restore occupies
+	// a worker until the State Manager answers.
+	Workers int
+}
+
+// key identifies a resource to reconcile in the queue.
+type key struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+type operator struct {
+	client       dynamic.Interface
+	stateManager StateManager
+	config       Config
+	queue        workqueue.RateLimitingInterface
+	now          func() time.Time
+}
+
+// Operator reconciles the CheckpointPolicies into Interceptor configurations and
+// drives the restores of the Restores through the State Manager.
+func Operator(client dynamic.Interface, stateManager StateManager, cfg Config) *operator {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	return &operator{
+		client:       client,
+		stateManager: stateManager,
+		config:       cfg,
+		queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		now:          time.Now,
+	}
+}
+
+// Run watches the CheckpointPolicies and Restores and reconciles them until the
+// context is done.
+func (o *operator) Run(ctx context.Context) error {
+	namespace := o.config.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceAll
+	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(o.client, o.config.ResyncInterval, namespace, nil)
+	for _, resource := range []schema.GroupVersionResource{v1alpha1.CheckpointPolicyResource, v1alpha1.RestoreResource} {
+		resource := resource
+		_, err := factory.ForResource(resource).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj any) { o.enqueue(resource, obj) },
+			UpdateFunc: func(_, obj any) { o.enqueue(resource, obj) },
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	for resource, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("could not sync the cache of %s", resource.Resource)
+		}
+	}
+	slog.Info("operator started", "namespace", o.config.Namespace, "workers", o.config.Workers)
+
+	var workers sync.WaitGroup
+	for i := 0; i < o.config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for o.processNext(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	o.queue.ShutDown()
+	workers.Wait()
+	return nil
+}
+
+func (o *operator) enqueue(resource schema.GroupVersionResource, obj any) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	o.queue.Add(key{resource: resource, namespace: object.GetNamespace(), name: object.GetName()})
+}
+
+// processNext reconciles the next resource of the queue, requeuing it with backoff
+// when it fails. It returns false once the queue is shut down.
+func (o *operator) processNext(ctx context.Context) bool {
+	item, shutdown := o.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer o.queue.Done(item)
+
+	k := item.(key)
+	if err := o.reconcile(ctx, k); err != nil {
+		slog.Error("reconciliation failed", "resource", k.resource.Resource, "namespace", k.namespace, "name", k.name, logging.Error(err))
+		o.queue.AddRateLimited(k)
+		return true
+	}
+	o.queue.Forget(k)
+	return true
+}
+
+func (o *operator) reconcile(ctx context.Context, k key) error {
+	switch k.resource {
+	case v1alpha1.CheckpointPolicyResource:
+		return o.reconcileCheckpointPolicy(ctx, k.namespace, k.name)
+	case v1alpha1.RestoreResource:
+		return o.reconcileRestore(ctx, k.namespace, k.name)
+	default:
+		return fmt.Errorf("unknown resource %s", k.resource)
+	}
+}
+
+func toUnstructured(object any) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+func fromUnstructured(object *unstructured.Unstructured, into any) error {
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, into)
+}
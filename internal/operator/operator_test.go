@@ -0,0 +1,249 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// stateManagerStub answers the restores with its restoration or error.
+type stateManagerStub struct {
+	restoration *entity.Restoration
+	err         error
+	restores    int
+}
+
+func (s *stateManagerStub) Restore(ctx context.Context, identity entity.ContainerIdentity) (*entity.Restoration, error) {
+	s.restores++
+	return s.restoration, s.err
+}
+
+func fakeClient(t *testing.T, objects ...any) dynamic.Interface {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		v1alpha1.CheckpointResource:       "CheckpointList",
+		v1alpha1.CheckpointPolicyResource: "CheckpointPolicyList",
+		v1alpha1.RestoreResource:          "RestoreList",
+		podResource:                       "PodList",
+		configMapResource:                 "ConfigMapList",
+	})
+	for _, object := range objects {
+		create(t, client, object)
+	}
+	return client
+}
+
+func create(t *testing.T, client dynamic.Interface, object any) {
+	t.Helper()
+	var resource schema.GroupVersionResource
+	switch object.(type) {
+	case *v1alpha1.Checkpoint:
+		resource = v1alpha1.CheckpointResource
+	case *v1alpha1.CheckpointPolicy:
+		resource = v1alpha1.CheckpointPolicyResource
+	case *v1alpha1.Restore:
+		resource = v1alpha1.RestoreResource
+	case *corev1.Pod:
+		resource = podResource
+	}
+	content, err := toUnstructured(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Resource(resource).Namespace(content.GetNamespace()).Create(context.Background(), content, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileRestore(t *testing.T) {
+	newRestore := func(name string) *v1alpha1.Restore {
+		return &v1alpha1.Restore{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "Restore"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1alpha1.RestoreSpec{Pod: "app-0", Container: "test"},
+		}
+	}
+
+	t.Run("it should restore the container and report the reprojection", func(t *testing.T) {
+		stateManager := &stateManagerStub{restoration: &entity.Restoration{CheckpointHash: "hash", FromVersion: 8, Reprojected: true}}
+		o := Operator(fakeClient(t, newRestore("restore")), stateManager, Config{})
+		if err := o.reconcileRestore(context.Background(), "default", "restore"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		restore, err := o.getRestore(context.Background(), "default", "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restore.Status.Phase != v1alpha1.RestoreSucceeded || restore.Status.CheckpointHash != "hash" || restore.Status.FromVersion != 8 {
+			t.Errorf("expected a succeeded restore of hash from version 8, got %+v\n", restore.Status)
+		}
+		if !meta.IsStatusConditionTrue(restore.Status.Conditions, v1alpha1.ConditionReprojected) {
+			t.Errorf("expected the reprojected condition, got %+v\n", restore.Status.Conditions)
+		}
+
+		t.Run("it should not restore a completed restore again", func(t *testing.T) {
+			if err := o.reconcileRestore(context.Background(), "default", "restore"); err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+			if stateManager.restores != 1 {
+				t.Errorf("expected 1 restore, got %d\n", stateManager.restores)
+			}
+		})
+	})
+
+	t.Run("it should fail restores without checkpoints", func(t *testing.T) {
+		stateManager := &stateManagerStub{err: entity.ErrNotFound}
+		o := Operator(fakeClient(t, newRestore("restore")), stateManager, Config{})
+		if err := o.reconcileRestore(context.Background(), "default", "restore"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		restore, err := o.getRestore(context.Background(), "default", "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := meta.FindStatusCondition(restore.Status.Conditions, v1alpha1.ConditionRestored)
+		if restore.Status.Phase != v1alpha1.RestoreFailed || condition == nil || condition.Reason != reasonCheckpointNotFound {
+			t.Errorf("expected a failed restore without checkpoint, got %+v\n", restore.Status)
+		}
+	})
+
+	t.Run("it should fail interrupted restores rather than restoring again", func(t *testing.T) {
+		interrupted := newRestore("restore")
+		interrupted.Status.Phase = v1alpha1.RestoreRestoring
+		stateManager := &stateManagerStub{err: errors.New("unexpected restore")}
+		o := Operator(fakeClient(t, interrupted), stateManager, Config{})
+		if err := o.reconcileRestore(context.Background(), "default", "restore"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		restore, err := o.getRestore(context.Background(), "default", "restore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		condition := meta.FindStatusCondition(restore.Status.Conditions, v1alpha1.ConditionRestored)
+		if stateManager.restores != 0 || condition == nil || condition.Reason != reasonInterrupted {
+			t.Errorf("expected an interrupted restore, got %d restores and %+v\n", stateManager.restores, restore.Status)
+		}
+	})
+}
+
+func TestReconcileCheckpointPolicy(t *testing.T) {
+	policy := &v1alpha1.CheckpointPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "CheckpointPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default", UID: "uid"},
+		Spec: v1alpha1.CheckpointPolicySpec{
+			Selector:  metav1.LabelSelector{MatchLabels: map[string]string{"app": "counter"}},
+			Interval:  metav1.Duration{Duration: 5 * time.Minute},
+			Retention: 1,
+			Storage:   v1alpha1.CheckpointStorage{ImagesDirectory: "/var/lib/checkpoints"},
+		},
+	}
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "default", Labels: map[string]string{"app": "counter"}},
+	}
+	now := time.Now()
+	checkpoint := func(name string, age time.Duration, phase string) *v1alpha1.Checkpoint {
+		return &v1alpha1.Checkpoint{
+			TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "Checkpoint"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{
+				v1alpha1.LabelPod:       "app-0",
+				v1alpha1.LabelContainer: "test",
+			}},
+			Spec:   v1alpha1.CheckpointSpec{Pod: "app-0", Container: "test", Hash: name, LastTimestamp: metav1.NewMicroTime(now.Add(-age))},
+			Status: v1alpha1.CheckpointStatus{Phase: phase},
+		}
+	}
+	client := fakeClient(t, policy, pod,
+		checkpoint("oldest", 3*time.Minute, v1alpha1.CheckpointAvailable),
+		checkpoint("restored", 2*time.Minute, v1alpha1.CheckpointLatest),
+		checkpoint("newest", time.Minute, v1alpha1.CheckpointAvailable),
+	)
+	o := Operator(client, &stateManagerStub{}, Config{})
+
+	if err := o.reconcileCheckpointPolicy(context.Background(), "default", "policy"); err != nil {
+		t.Fatalf("expected no error, got %v\n", err)
+	}
+
+	t.Run("it should write the interceptor configuration to the ConfigMap", func(t *testing.T) {
+		object, err := client.Resource(configMapResource).Namespace("default").Get(context.Background(), "policy-interceptor", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected the ConfigMap to exist, got %v\n", err)
+		}
+		var configMap corev1.ConfigMap
+		if err := fromUnstructured(object, &configMap); err != nil {
+			t.Fatal(err)
+		}
+		expected := "checkpointingInterval: 5m0s\ncheckpointRetention: 1\nimagesDirectory: /var/lib/checkpoints\n"
+		if configMap.Data[v1alpha1.InterceptorConfigKey] != expected {
+			t.Errorf("expected configuration %q, got %q\n", expected, configMap.Data[v1alpha1.InterceptorConfigKey])
+		}
+		if len(configMap.OwnerReferences) != 1 || configMap.OwnerReferences[0].UID != "uid" {
+			t.Errorf("expected the ConfigMap to be owned by the policy, got %+v\n", configMap.OwnerReferences)
+		}
+	})
+
+	t.Run("it should keep the newest and the latest checkpoints", func(t *testing.T) {
+		objects, err := client.Resource(v1alpha1.CheckpointResource).Namespace("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, object := range objects.Items {
+			names[object.GetName()] = true
+		}
+		if len(names) != 2 || !names["newest"] || !names["restored"] {
+			t.Errorf("expected the newest and restored checkpoints, got %v\n", names)
+		}
+	})
+
+	t.Run("it should report the matched pods", func(t *testing.T) {
+		object, err := client.Resource(v1alpha1.CheckpointPolicyResource).Namespace("default").Get(context.Background(), "policy", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reconciled v1alpha1.CheckpointPolicy
+		if err := fromUnstructured(object, &reconciled); err != nil {
+			t.Fatal(err)
+		}
+		if reconciled.Status.MatchedPods != 1 || !meta.IsStatusConditionTrue(reconciled.Status.Conditions, v1alpha1.ConditionReady) {
+			t.Errorf("expected a ready policy matching 1 pod, got %+v\n", reconciled.Status)
+		}
+	})
+
+	t.Run("it should report invalid policies", func(t *testing.T) {
+		invalid := *policy
+		invalid.Name = "invalid"
+		invalid.Spec.Interval = metav1.Duration{}
+		create(t, client, &invalid)
+		if err := o.reconcileCheckpointPolicy(context.Background(), "default", "invalid"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		object, err := client.Resource(v1alpha1.CheckpointPolicyResource).Namespace("default").Get(context.Background(), "invalid", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reconciled v1alpha1.CheckpointPolicy
+		if err := fromUnstructured(object, &reconciled); err != nil {
+			t.Fatal(err)
+		}
+		condition := meta.FindStatusCondition(reconciled.Status.Conditions, v1alpha1.ConditionReady)
+		if condition == nil || condition.Reason != reasonInvalidSpec {
+			t.Errorf("expected an invalid spec condition, got %+v\n", reconciled.Status.Conditions)
+		}
+	})
+}
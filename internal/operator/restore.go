@@ -0,0 +1,155 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Reasons of the conditions of a Restore.
+const (
+	reasonRestored           = "Restored"
+	reasonCheckpointNotFound = "CheckpointNotFound"
+	reasonRestoreFailed      = "RestoreFailed"
+	reasonInterrupted        = "Interrupted"
+	reasonReprojected        = "Reprojected"
+	reasonNoInterceptor      = "NoInterceptor"
+)
+
+// errInterrupted fails the Restores found restoring, whose outcome is unknown.
+var errInterrupted = errors.New("the operator stopped before the restore completed")
+
+// reconcileRestore restores the container of a new Restore. Restores are not
+// idempotent, a Restore found restoring was interrupted and is failed rather than
+// restored again.
+func (o *operator) reconcileRestore(ctx context.Context, namespace string, name string) error {
+	restore, err := o.getRestore(ctx, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch restore.Status.Phase {
+	case v1alpha1.RestoreSucceeded, v1alpha1.RestoreFailed:
+		return nil
+	case v1alpha1.RestoreRestoring:
+		return o.completeRestore(ctx, restore, nil, errInterrupted)
+	}
+
+	identity := entity.ContainerIdentity{Namespace: namespace, Pod: restore.Spec.Pod, Container: restore.Spec.Container}
+	if err := identity.Validate(); err != nil {
+		return o.completeRestore(ctx, restore, nil, err)
+	}
+
+	startedAt := metav1.NewTime(o.now())
+	restore.Status.Phase = v1alpha1.RestoreRestoring
+	restore.Status.StartedAt = &startedAt
+	if restore, err = o.updateRestoreStatus(ctx, restore); err != nil {
+		return err
+	}
+
+	slog.Info("restoring container", logging.KeyContainer, identity.String(), "restore", name)
+	restoration, err := o.stateManager.Restore(ctx, identity)
+	return o.completeRestore(ctx, restore, restoration, err)
+}
+
+// completeRestore records the outcome of the restore, retrying on conflicts so the
+// outcome is not lost to a concurrent update of the Restore.
+func (o *operator) completeRestore(ctx context.Context, restore *v1alpha1.Restore, restoration *entity.Restoration, restoreErr error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := o.getRestore(ctx, restore.Namespace, restore.Name)
+		if err != nil {
+			return err
+		}
+		setRestoreOutcome(latest, restoration, restoreErr, metav1.NewTime(o.now()))
+		_, err = o.updateRestoreStatus(ctx, latest)
+		return err
+	})
+}
+
+// setRestoreOutcome sets the phase and conditions of the completed restore.
+func setRestoreOutcome(restore *v1alpha1.Restore, restoration *entity.Restoration, restoreErr error, completedAt metav1.Time) {
+	restore.Status.CompletedAt = &completedAt
+
+	if restoreErr != nil {
+		reason := reasonRestoreFailed
+		switch {
+		case errors.Is(restoreErr, entity.ErrNotFound):
+			reason = reasonCheckpointNotFound
+		case errors.Is(restoreErr, errInterrupted):
+			reason = reasonInterrupted
+		}
+		restore.Status.Phase = v1alpha1.RestoreFailed
+		meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionRestored,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            restoreErr.Error(),
+			ObservedGeneration: restore.Generation,
+		})
+		return
+	}
+
+	restore.Status.Phase = v1alpha1.RestoreSucceeded
+	restore.Status.CheckpointHash = restoration.CheckpointHash
+	restore.Status.FromVersion = restoration.FromVersion
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionRestored,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonRestored,
+		Message:            fmt.Sprintf("restored checkpoint %s", restoration.CheckpointHash),
+		ObservedGeneration: restore.Generation,
+	})
+	reprojected := metav1.Condition{
+		Type:               v1alpha1.ConditionReprojected,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonReprojected,
+		Message:            fmt.Sprintf("reprojected the requests from version %d", restoration.FromVersion),
+		ObservedGeneration: restore.Generation,
+	}
+	if !restoration.Reprojected {
+		reprojected.Status = metav1.ConditionFalse
+		reprojected.Reason = reasonNoInterceptor
+		reprojected.Message = "no active interceptor was registered for the container"
+	}
+	meta.SetStatusCondition(&restore.Status.Conditions, reprojected)
+}
+
+func (o *operator) getRestore(ctx context.Context, namespace string, name string) (*v1alpha1.Restore, error) {
+	object, err := o.client.Resource(v1alpha1.RestoreResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var restore v1alpha1.Restore
+	if err := fromUnstructured(object, &restore); err != nil {
+		return nil, err
+	}
+	return &restore, nil
+}
+
+func (o *operator) updateRestoreStatus(ctx context.Context, restore *v1alpha1.Restore) (*v1alpha1.Restore, error) {
+	object, err := toUnstructured(restore)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := o.client.Resource(v1alpha1.RestoreResource).Namespace(restore.Namespace).UpdateStatus(ctx, object, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var result v1alpha1.Restore
+	if err := fromUnstructured(updated, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
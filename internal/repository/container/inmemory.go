@@ -0,0 +1,76 @@
+package container
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type inMemoryContainerRepository struct {
+	mutex      sync.RWMutex
+	containers map[string]*entity.Container
+	// identities indexes the container ids by identity.
+	identities map[entity.ContainerIdentity]string
+}
+
+func InMemory() entity.ContainerRepository {
+	return &inMemoryContainerRepository{
+		containers: make(map[string]*entity.Container),
+		identities: make(map[entity.ContainerIdentity]string),
+	}
+}
+
+func (r *inMemoryContainerRepository) GetByID(id string) (*entity.Container, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	container, ok := r.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no container with id %q", entity.ErrNotFound, id)
+	}
+	return container, nil
+}
+
+func (r *inMemoryContainerRepository) GetByIdentity(identity entity.ContainerIdentity) (*entity.Container, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	id, ok := r.identities[identity]
+	if !ok {
+		return nil, fmt.Errorf("%w: no container %q", entity.ErrNotFound, identity)
+	}
+	return r.containers[id], nil
+}
+
+func (r *inMemoryContainerRepository) Create(container *entity.Container) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.containers[container.ID]; ok {
+		return fmt.Errorf("container with id %q already exists", container.ID)
+	}
+	if _, ok := r.identities[container.Identity()]; ok {
+		return fmt.Errorf("container %q already exists", container.Identity())
+	}
+	r.containers[container.ID] = container
+	r.identities[container.Identity()] = container.ID
+	return nil
+}
+
+func (r *inMemoryContainerRepository) Update(container *entity.Container) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous, ok := r.containers[container.ID]
+	if !ok {
+		return fmt.Errorf("%w: no container with id %q", entity.ErrNotFound, container.ID)
+	}
+	if id, ok := r.identities[container.Identity()]; ok && id != container.ID {
+		return fmt.Errorf("container %q already exists", container.Identity())
+	}
+	delete(r.identities, previous.Identity())
+	r.containers[container.ID] = container
+	r.identities[container.Identity()] = container.ID
+	return nil
+}
@@ -4,28 +4,77 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
 	client "go.etcd.io/etcd/client/v3"
 )
 
+// Key prefixes of the checkpoint metadata and of the latest checkpoint of each
+// container, followed by the container identity.
+const (
+	checkpointsPrefix = "checkpoints/"
+	latestPrefix      = "latest/"
+)
+
+// ETCDConfig is the configuration of the etcd repository.
+type ETCDConfig struct {
+	// Prefix is prepended to every key, so many State Managers can share an etcd.
+	Prefix string
+	// Timeout is the maximum duration of each call to etcd.
+	Timeout time.Duration
+}
+
+// DefaultETCDConfig returns the configuration used by ETCD.
+func DefaultETCDConfig() ETCDConfig {
+	return ETCDConfig{
+		Timeout: 5 * time.Second,
+	}
+}
+
 type etcdContainerMetadataRepository struct {
 	etcdClient *client.Client
+	config     ETCDConfig
 }
 
 func ETCD(etcdClient *client.Client) *etcdContainerMetadataRepository {
+	return ETCDWithConfig(etcdClient, DefaultETCDConfig())
+}
+
+// ETCDWithConfig creates the repository with the given key prefix and timeout.
+func ETCDWithConfig(etcdClient *client.Client, cfg ETCDConfig) *etcdContainerMetadataRepository {
 	return &etcdContainerMetadataRepository{
 		etcdClient: etcdClient,
+		config:     cfg,
 	}
 }
 
-func (r *etcdContainerMetadataRepository) Insert(checkpointHash string, metadata *entity.ContainerMetadata) error {
+func (r *etcdContainerMetadataRepository) checkpointKey(identity entity.ContainerIdentity, checkpointHash string) string {
+	return r.config.Prefix + checkpointsPrefix + identity.String() + "/" + checkpointHash
+}
+
+func (r *etcdContainerMetadataRepository) latestKey(identity entity.ContainerIdentity) string {
+	return r.config.Prefix + latestPrefix + identity.String()
+}
+
+// context returns the context of a call to etcd, bounded by the configured timeout.
+func (r *etcdContainerMetadataRepository) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.config.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.config.Timeout)
+}
+
+func (r *etcdContainerMetadataRepository) Insert(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
 	encodedContainerMetadata, err := json.Marshal(metadata)
 	if err != nil {
 		return err
 	}
 
-	_, err = r.etcdClient.Put(context.Background(), checkpointHash, string(encodedContainerMetadata))
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	_, err = r.etcdClient.Put(ctx, r.checkpointKey(identity, checkpointHash), string(encodedContainerMetadata))
 	if err != nil {
 		return err
 	}
@@ -33,8 +82,14 @@ func (r *etcdContainerMetadataRepository) Insert(checkpointHash string, metadata
 	return nil
 }
 
-func (r *etcdContainerMetadataRepository) Get(checkpointHash string) (*entity.ContainerMetadata, error) {
-	res, err := r.etcdClient.Get(context.Background(), checkpointHash)
+func (r *etcdContainerMetadataRepository) Get(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	return r.get(ctx, r.checkpointKey(identity, checkpointHash))
+}
+
+func (r *etcdContainerMetadataRepository) get(ctx context.Context, key string) (*entity.ContainerMetadata, error) {
+	res, err := r.etcdClient.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -49,11 +104,13 @@ func (r *etcdContainerMetadataRepository) Get(checkpointHash string) (*entity.Co
 		return &metadata, nil
 	}
 
-	return nil, fmt.Errorf("no result for key %q", checkpointHash)
+	return nil, fmt.Errorf("%w: no result for key %q", entity.ErrNotFound, key)
 }
 
-func (r *etcdContainerMetadataRepository) UpsertContainerLatestCheckpoint(checkpointHash string, containerID string) error {
-	_, err := r.etcdClient.Put(context.Background(), containerID, checkpointHash)
+func (r *etcdContainerMetadataRepository) UpsertContainerLatestCheckpoint(identity entity.ContainerIdentity, checkpointHash string) error {
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	_, err := r.etcdClient.Put(ctx, r.latestKey(identity), checkpointHash)
 	if err != nil {
 		return err
 	}
@@ -61,8 +118,11 @@ func (r *etcdContainerMetadataRepository) UpsertContainerLatestCheckpoint(checkp
 	return nil
 }
 
-func (r *etcdContainerMetadataRepository) LatestContainerCheckpoint(containerID string) (string, error) {
-	res, err := r.etcdClient.Get(context.Background(), containerID)
+func (r *etcdContainerMetadataRepository) LatestContainerCheckpoint(identity entity.ContainerIdentity) (string, error) {
+	key := r.latestKey(identity)
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	res, err := r.etcdClient.Get(ctx, key)
 	if err != nil {
 		return "", err
 	}
@@ -71,5 +131,99 @@ func (r *etcdContainerMetadataRepository) LatestContainerCheckpoint(containerID
 		return string(res.Kvs[0].Value), nil
 	}
 
-	return "", fmt.Errorf("no result for key %q", containerID)
+	return "", fmt.Errorf("%w: no result for key %q", entity.ErrNotFound, key)
+}
+
+// SaveCheckpoint writes the metadata and advances the latest checkpoint in a single
+// transaction. The transaction only applies when the latest checkpoint is the one it
+// was compared with, otherwise it is tried again against the new one. The latest
+// checkpoint is kept when it is newer than the saved one.
+func (r *etcdContainerMetadataRepository) SaveCheckpoint(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	encodedContainerMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := r.context(context.Background())
+	defer cancel()
+	checkpointKey := r.checkpointKey(identity, checkpointHash)
+	latestKey := r.latestKey(identity)
+	for {
+		res, err := r.etcdClient.Get(ctx, latestKey)
+		if err != nil {
+			return err
+		}
+
+		var revision int64
+		advance := true
+		if len(res.Kvs) > 0 {
+			revision = res.Kvs[0].ModRevision
+			latest, err := r.get(ctx, r.checkpointKey(identity, string(res.Kvs[0].Value)))
+			if err == nil && latest.LastTimestamp.After(metadata.LastTimestamp) {
+				advance = false
+			}
+		}
+
+		operations := []client.Op{client.OpPut(checkpointKey, string(encodedContainerMetadata))}
+		if advance {
+			operations = append(operations, client.OpPut(latestKey, checkpointHash))
+		}
+		txn, err := r.etcdClient.Txn(ctx).
+			If(client.Compare(client.ModRevision(latestKey), "=", revision)).
+			Then(operations...).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+	}
+}
+
+// WatchCheckpoints watches the latest checkpoint keys of every container.
+func (r *etcdContainerMetadataRepository) WatchCheckpoints(ctx context.Context) (<-chan entity.CheckpointEvent, error) {
+	prefix := r.config.Prefix + latestPrefix
+	watch := r.etcdClient.Watch(client.WithRequireLeader(ctx), prefix, client.WithPrefix())
+
+	events := make(chan entity.CheckpointEvent)
+	go func() {
+		defer close(events)
+		for res := range watch {
+			for _, event := range res.Events {
+				if event.Type != client.EventTypePut {
+					continue
+				}
+				parts := strings.Split(strings.TrimPrefix(string(event.Kv.Key), prefix), "/")
+				if len(parts) != 3 {
+					continue
+				}
+				checkpointEvent := entity.CheckpointEvent{
+					Container: entity.ContainerIdentity{
+						Namespace: parts[0],
+						Pod:       parts[1],
+						Container: parts[2],
+					},
+					CheckpointHash: string(event.Kv.Value),
+				}
+				select {
+				case events <- checkpointEvent:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// pingKey is the key read to check the connectivity with etcd, like etcdctl
+// endpoint health does.
+const pingKey = "health"
+
+func (r *etcdContainerMetadataRepository) Ping(ctx context.Context) error {
+	ctx, cancel := r.context(ctx)
+	defer cancel()
+	_, err := r.etcdClient.Get(ctx, pingKey)
+	return err
 }
@@ -0,0 +1,96 @@
+package containermetadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/etcdtest"
+)
+
+func TestETCD(t *testing.T) {
+	etcdClient := etcdtest.Client(t, etcdtest.Start(t))
+	repository := ETCDWithConfig(etcdClient, ETCDConfig{Prefix: "tenant-a/", Timeout: 5 * time.Second})
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app-0", Container: "test"}
+	now := time.Now().UTC()
+
+	t.Run("it should namespace the keys by prefix", func(t *testing.T) {
+		if err := repository.SaveCheckpoint(identity, "first", &entity.ContainerMetadata{LastTimestamp: now}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		res, err := etcdClient.Get(context.Background(), "tenant-a/latest/default/app-0/test")
+		if err != nil || len(res.Kvs) != 1 || string(res.Kvs[0].Value) != "first" {
+			t.Errorf("expected the latest checkpoint under the prefix, got %v (%v)\n", res, err)
+		}
+
+		other := ETCDWithConfig(etcdClient, ETCDConfig{Prefix: "tenant-b/", Timeout: 5 * time.Second})
+		if _, err := other.LatestContainerCheckpoint(identity); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound under another prefix, got %v\n", err)
+		}
+	})
+
+	t.Run("it should not move the latest checkpoint back to an older one", func(t *testing.T) {
+		if err := repository.SaveCheckpoint(identity, "second", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if err := repository.SaveCheckpoint(identity, "late", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Second)}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		latest, err := repository.LatestContainerCheckpoint(identity)
+		if err != nil || latest != "second" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "second", latest, err)
+		}
+		if _, err := repository.Get(identity, "late"); err != nil {
+			t.Errorf("expected the older checkpoint to be saved, got %v\n", err)
+		}
+	})
+
+	t.Run("it should keep the newest of concurrent checkpoints", func(t *testing.T) {
+		concurrent := entity.ContainerIdentity{Namespace: "default", Pod: "app-1", Container: "test"}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				metadata := &entity.ContainerMetadata{LastTimestamp: now.Add(time.Duration(i) * time.Second)}
+				if err := repository.SaveCheckpoint(concurrent, fmt.Sprintf("checkpoint-%d", i), metadata); err != nil {
+					t.Errorf("expected no error, got %v\n", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		latest, err := repository.LatestContainerCheckpoint(concurrent)
+		if err != nil || latest != "checkpoint-9" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "checkpoint-9", latest, err)
+		}
+	})
+
+	t.Run("it should notify new latest checkpoints", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := repository.WatchCheckpoints(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repository.SaveCheckpoint(identity, "third", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		select {
+		case event := <-events:
+			if event.Container != identity || event.CheckpointHash != "third" {
+				t.Errorf("expected checkpoint %q of %s, got %+v\n", "third", identity, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("expected a checkpoint event")
+		}
+	})
+}
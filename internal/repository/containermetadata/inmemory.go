@@ -1,33 +1,81 @@
 package containermetadata
 
-import "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type metadataKey struct {
+	identity       entity.ContainerIdentity
+	checkpointHash string
+}
 
 type inMemoryContainerMetadataRepository struct {
-	metadataMemory                map[string]*entity.ContainerMetadata
-	containerCheckpointHashMemory map[string]string
+	mutex                         sync.RWMutex
+	metadataMemory                map[metadataKey]*entity.ContainerMetadata
+	containerCheckpointHashMemory map[entity.ContainerIdentity]string
 }
 
 func InMemory() *inMemoryContainerMetadataRepository {
 	return &inMemoryContainerMetadataRepository{
-		metadataMemory:                make(map[string]*entity.ContainerMetadata),
-		containerCheckpointHashMemory: make(map[string]string),
+		metadataMemory:                make(map[metadataKey]*entity.ContainerMetadata),
+		containerCheckpointHashMemory: make(map[entity.ContainerIdentity]string),
+	}
+}
+
+func (r *inMemoryContainerMetadataRepository) Insert(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metadataMemory[metadataKey{identity, checkpointHash}] = metadata
+	return nil
+}
+
+func (r *inMemoryContainerMetadataRepository) Get(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	metadata, ok := r.metadataMemory[metadataKey{identity, checkpointHash}]
+	if !ok {
+		return nil, fmt.Errorf("%w: no checkpoint %q of container %q", entity.ErrNotFound, checkpointHash, identity)
 	}
+	return metadata, nil
 }
 
-func (r *inMemoryContainerMetadataRepository) Insert(checkpointHash string, metadata *entity.ContainerMetadata) error {
-	r.metadataMemory[checkpointHash] = metadata
+func (r *inMemoryContainerMetadataRepository) UpsertContainerLatestCheckpoint(identity entity.ContainerIdentity, checkpointHash string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.containerCheckpointHashMemory[identity] = checkpointHash
 	return nil
 }
 
-func (r *inMemoryContainerMetadataRepository) Get(checkpointHash string) (*entity.ContainerMetadata, error) {
-	return r.metadataMemory[checkpointHash], nil
+func (r *inMemoryContainerMetadataRepository) LatestContainerCheckpoint(identity entity.ContainerIdentity) (string, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	checkpointHash, ok := r.containerCheckpointHashMemory[identity]
+	if !ok {
+		return "", fmt.Errorf("%w: no checkpoint of container %q", entity.ErrNotFound, identity)
+	}
+	return checkpointHash, nil
 }
 
-func (r *inMemoryContainerMetadataRepository) UpsertContainerLatestCheckpoint(checkpointHash string, containerID string) error {
-	r.containerCheckpointHashMemory[containerID] = checkpointHash
+// SaveCheckpoint inserts the metadata and advances the latest checkpoint under the
+// same lock, keeping the latest checkpoint when it is newer.
+func (r *inMemoryContainerMetadataRepository) SaveCheckpoint(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metadataMemory[metadataKey{identity, checkpointHash}] = metadata
+	if latestHash, ok := r.containerCheckpointHashMemory[identity]; ok {
+		latest, ok := r.metadataMemory[metadataKey{identity, latestHash}]
+		if ok && latest.LastTimestamp.After(metadata.LastTimestamp) {
+			return nil
+		}
+	}
+	r.containerCheckpointHashMemory[identity] = checkpointHash
 	return nil
 }
 
-func (r *inMemoryContainerMetadataRepository) LatestContainerCheckpoint(containerID string) (string, error) {
-	return r.containerCheckpointHashMemory[containerID], nil
+func (r *inMemoryContainerMetadataRepository) Ping(ctx context.Context) error {
+	return nil
 }
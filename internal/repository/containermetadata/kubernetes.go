@@ -0,0 +1,333 @@
+package containermetadata
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+// KubernetesConfig is the configuration of the Kubernetes repository.
+type KubernetesConfig struct {
+	// ImagesDirectory is the directory of the checkpoint images, recorded as the
+	// storage location of the Checkpoints.
+	ImagesDirectory string
+	// Timeout is the maximum duration of each operation on the Kubernetes API.
+	Timeout time.Duration
+}
+
+type kubernetesContainerMetadataRepository struct {
+	client dynamic.Interface
+	config KubernetesConfig
+}
+
+// Kubernetes is the repository storing every checkpoint as a Checkpoint custom
+// resource in the namespace of its container, so they are subject to RBAC and listed
+// by kubectl get checkpoints.
+func Kubernetes(client dynamic.Interface, cfg KubernetesConfig) *kubernetesContainerMetadataRepository {
+	return &kubernetesContainerMetadataRepository{
+		client: client,
+		config: cfg,
+	}
+}
+
+// checkpointName returns a name valid in Kubernetes for the checkpoint, readable by
+// its pod and container and unique by the hash of its identity.
+func checkpointName(identity entity.ContainerIdentity, checkpointHash string) string {
+	readable := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(identity.Pod+"-"+identity.Container))
+	if len(readable) > 40 {
+		readable = readable[:40]
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(identity.String() + "/" + checkpointHash))
+	return fmt.Sprintf("%s-%x", strings.Trim(readable, "-"), h.Sum64())
+}
+
+func (r *kubernetesContainerMetadataRepository) resource(namespace string) dynamic.ResourceInterface {
+	return r.client.Resource(v1alpha1.CheckpointResource).Namespace(namespace)
+}
+
+func (r *kubernetesContainerMetadataRepository) context() (context.Context, context.CancelFunc) {
+	if r.config.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.config.Timeout)
+}
+
+func (r *kubernetesContainerMetadataRepository) Insert(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	_, err := r.insert(ctx, identity, checkpointHash, metadata)
+	return err
+}
+
+// insert creates the Checkpoint, or updates its spec when it already exists.
+func (r *kubernetesContainerMetadataRepository) insert(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) (*v1alpha1.Checkpoint, error) {
+	spec := v1alpha1.CheckpointSpec{
+		Pod:                 identity.Pod,
+		Container:           identity.Container,
+		Hash:                checkpointHash,
+		LastTimestamp:       metav1.NewMicroTime(metadata.LastTimestamp),
+		LastRequestSolvedID: metadata.LastRequestSolvedID,
+		LastVersion:         metadata.LastVersion,
+	}
+	if r.config.ImagesDirectory != "" {
+		spec.StorageLocation = path.Join(r.config.ImagesDirectory, checkpointHash)
+	}
+
+	name := checkpointName(identity, checkpointHash)
+	existing, err := r.get(ctx, identity.Namespace, name)
+	if err == nil {
+		existing.Spec = spec
+		return r.update(ctx, existing, false)
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	checkpoint := &v1alpha1.Checkpoint{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "Checkpoint",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: identity.Namespace,
+			Labels: map[string]string{
+				v1alpha1.LabelPod:       identity.Pod,
+				v1alpha1.LabelContainer: identity.Container,
+			},
+		},
+		Spec: spec,
+	}
+	object, err := toUnstructured(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	created, err := r.resource(identity.Namespace).Create(ctx, object, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, err = fromUnstructured(created)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Status.Phase = v1alpha1.CheckpointAvailable
+	return r.update(ctx, checkpoint, true)
+}
+
+func (r *kubernetesContainerMetadataRepository) Get(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	checkpoint, err := r.get(ctx, identity.Namespace, checkpointName(identity, checkpointHash))
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: no checkpoint %q of container %q", entity.ErrNotFound, checkpointHash, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toMetadata(checkpoint), nil
+}
+
+func (r *kubernetesContainerMetadataRepository) UpsertContainerLatestCheckpoint(identity entity.ContainerIdentity, checkpointHash string) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.setLatest(ctx, identity, checkpointHash, nil)
+	})
+}
+
+// setLatest makes the checkpoint the latest of the container and the previous
+// latest available, unless keep tells the previous latest must be kept.
+func (r *kubernetesContainerMetadataRepository) setLatest(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, keep func(latest *v1alpha1.Checkpoint) bool) error {
+	checkpoints, err := r.list(ctx, identity)
+	if err != nil {
+		return err
+	}
+
+	var target *v1alpha1.Checkpoint
+	var previous []*v1alpha1.Checkpoint
+	for _, checkpoint := range checkpoints {
+		switch {
+		case checkpoint.Spec.Hash == checkpointHash:
+			target = checkpoint
+		case checkpoint.Status.Phase == v1alpha1.CheckpointLatest:
+			if keep != nil && keep(checkpoint) {
+				return nil
+			}
+			previous = append(previous, checkpoint)
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("%w: no checkpoint %q of container %q", entity.ErrNotFound, checkpointHash, identity)
+	}
+
+	// The target is marked first so the container always has a latest checkpoint.
+	if target.Status.Phase != v1alpha1.CheckpointLatest {
+		target.Status.Phase = v1alpha1.CheckpointLatest
+		if _, err := r.update(ctx, target, true); err != nil {
+			return err
+		}
+	}
+	for _, checkpoint := range previous {
+		checkpoint.Status.Phase = v1alpha1.CheckpointAvailable
+		if _, err := r.update(ctx, checkpoint, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *kubernetesContainerMetadataRepository) LatestContainerCheckpoint(identity entity.ContainerIdentity) (string, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	checkpoints, err := r.list(ctx, identity)
+	if err != nil {
+		return "", err
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Status.Phase == v1alpha1.CheckpointLatest {
+			return checkpoint.Spec.Hash, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no checkpoint of container %q", entity.ErrNotFound, identity)
+}
+
+// SaveCheckpoint creates the Checkpoint and makes it the latest unless the latest
+// one is newer. The Kubernetes API has no transactions across objects, conflicting
+// updates of the phases are retried against the new state.
+func (r *kubernetesContainerMetadataRepository) SaveCheckpoint(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	if _, err := r.insert(ctx, identity, checkpointHash, metadata); err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.setLatest(ctx, identity, checkpointHash, func(latest *v1alpha1.Checkpoint) bool {
+			return latest.Spec.LastTimestamp.Time.After(metadata.LastTimestamp)
+		})
+	})
+}
+
+// ListCheckpoints returns every checkpoint of the container, oldest first.
+func (r *kubernetesContainerMetadataRepository) ListCheckpoints(identity entity.ContainerIdentity) ([]*entity.Checkpoint, error) {
+	ctx, cancel := r.context()
+	defer cancel()
+	checkpoints, err := r.list(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*entity.Checkpoint, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		history = append(history, &entity.Checkpoint{
+			Container: identity,
+			Hash:      checkpoint.Spec.Hash,
+			Metadata:  *toMetadata(checkpoint),
+			CreatedAt: checkpoint.CreationTimestamp.Time,
+		})
+	}
+	return history, nil
+}
+
+// Ping lists a single Checkpoint, checking both the connectivity and the permissions.
+func (r *kubernetesContainerMetadataRepository) Ping(ctx context.Context) error {
+	_, err := r.client.Resource(v1alpha1.CheckpointResource).List(ctx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+func (r *kubernetesContainerMetadataRepository) get(ctx context.Context, namespace string, name string) (*v1alpha1.Checkpoint, error) {
+	object, err := r.resource(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(object)
+}
+
+// list returns the Checkpoints of the container ordered by their timestamp.
+func (r *kubernetesContainerMetadataRepository) list(ctx context.Context, identity entity.ContainerIdentity) ([]*v1alpha1.Checkpoint, error) {
+	selector := labels.SelectorFromSet(labels.Set{
+		v1alpha1.LabelPod:       identity.Pod,
+		v1alpha1.LabelContainer: identity.Container,
+	})
+	objects, err := r.resource(identity.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make([]*v1alpha1.Checkpoint, 0, len(objects.Items))
+	for i := range objects.Items {
+		checkpoint, err := fromUnstructured(&objects.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.SliceStable(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Spec.LastTimestamp.Before(&checkpoints[j].Spec.LastTimestamp)
+	})
+	return checkpoints, nil
+}
+
+// update updates the Checkpoint, or its status subresource.
+func (r *kubernetesContainerMetadataRepository) update(ctx context.Context, checkpoint *v1alpha1.Checkpoint, status bool) (*v1alpha1.Checkpoint, error) {
+	object, err := toUnstructured(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *unstructured.Unstructured
+	if status {
+		updated, err = r.resource(checkpoint.Namespace).UpdateStatus(ctx, object, metav1.UpdateOptions{})
+	} else {
+		updated, err = r.resource(checkpoint.Namespace).Update(ctx, object, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(updated)
+}
+
+func toUnstructured(checkpoint *v1alpha1.Checkpoint) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+func fromUnstructured(object *unstructured.Unstructured) (*v1alpha1.Checkpoint, error) {
+	var checkpoint v1alpha1.Checkpoint
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func toMetadata(checkpoint *v1alpha1.Checkpoint) *entity.ContainerMetadata {
+	return &entity.ContainerMetadata{
+		LastTimestamp:       checkpoint.Spec.LastTimestamp.Time,
+		LastRequestSolvedID: checkpoint.Spec.LastRequestSolvedID,
+		LastVersion:         checkpoint.Spec.LastVersion,
+	}
+}
@@ -0,0 +1,94 @@
+package containermetadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/apis/checkpointrestore/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestKubernetes(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		v1alpha1.CheckpointResource: "CheckpointList",
+	})
+	repository := Kubernetes(client, KubernetesConfig{ImagesDirectory: "/var/lib/checkpoints", Timeout: time.Second})
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app-0", Container: "test"}
+	now := time.Now().Truncate(time.Microsecond)
+
+	t.Run("it should create a Checkpoint in the namespace of the container", func(t *testing.T) {
+		metadata := &entity.ContainerMetadata{LastTimestamp: now, LastRequestSolvedID: "request"}
+		if err := repository.SaveCheckpoint(identity, "first", metadata); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		object, err := client.Resource(v1alpha1.CheckpointResource).Namespace("default").Get(context.Background(), checkpointName(identity, "first"), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected the Checkpoint to exist, got %v\n", err)
+		}
+		checkpoint, err := fromUnstructured(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint.Spec.StorageLocation != "/var/lib/checkpoints/first" || checkpoint.Status.Phase != v1alpha1.CheckpointLatest {
+			t.Errorf("expected the latest checkpoint stored in /var/lib/checkpoints/first, got %+v\n", checkpoint)
+		}
+
+		saved, err := repository.Get(identity, "first")
+		if err != nil || !saved.LastTimestamp.Equal(now) || saved.LastRequestSolvedID != "request" {
+			t.Errorf("expected %+v, got %+v (%v)\n", metadata, saved, err)
+		}
+	})
+
+	t.Run("it should not find checkpoints of other containers", func(t *testing.T) {
+		other := entity.ContainerIdentity{Namespace: "other", Pod: "app-0", Container: "test"}
+		if _, err := repository.Get(other, "first"); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+		if _, err := repository.LatestContainerCheckpoint(other); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+
+	t.Run("it should keep a single latest checkpoint, the newest", func(t *testing.T) {
+		repository.SaveCheckpoint(identity, "second", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Minute)})
+		repository.SaveCheckpoint(identity, "late", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Second)})
+
+		latest, err := repository.LatestContainerCheckpoint(identity)
+		if err != nil || latest != "second" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "second", latest, err)
+		}
+
+		checkpoints, err := repository.ListCheckpoints(identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hashes []string
+		for _, checkpoint := range checkpoints {
+			hashes = append(hashes, checkpoint.Hash)
+		}
+		if len(hashes) != 3 || hashes[0] != "first" || hashes[1] != "late" || hashes[2] != "second" {
+			t.Errorf("expected checkpoints [first late second], got %v\n", hashes)
+		}
+	})
+
+	t.Run("it should set the latest checkpoint explicitly", func(t *testing.T) {
+		if err := repository.UpsertContainerLatestCheckpoint(identity, "first"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		latest, err := repository.LatestContainerCheckpoint(identity)
+		if err != nil || latest != "first" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "first", latest, err)
+		}
+
+		if err := repository.UpsertContainerLatestCheckpoint(identity, "unknown"); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package containermetadata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// sqlMigrations are the schema migrations of the SQL repository, applied in order
+// and recorded by version in schema_migrations. The statements are portable between
+// SQLite and Postgres, timestamps are stored as unix nanoseconds.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE checkpoints (
+			namespace TEXT NOT NULL,
+			pod TEXT NOT NULL,
+			container TEXT NOT NULL,
+			checkpoint_hash TEXT NOT NULL,
+			last_timestamp BIGINT NOT NULL,
+			last_request_solved_id TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (namespace, pod, container, checkpoint_hash)
+		)`,
+		`CREATE INDEX checkpoints_history ON checkpoints (namespace, pod, container, last_timestamp)`,
+		`CREATE TABLE latest_checkpoints (
+			namespace TEXT NOT NULL,
+			pod TEXT NOT NULL,
+			container TEXT NOT NULL,
+			checkpoint_hash TEXT NOT NULL,
+			last_timestamp BIGINT NOT NULL,
+			PRIMARY KEY (namespace, pod, container)
+		)`,
+	},
+	{
+		`ALTER TABLE checkpoints ADD COLUMN last_version BIGINT NOT NULL DEFAULT 0`,
+	},
+}
+
+// MigrateSQL creates or upgrades the schema of the SQL repository.
+func MigrateSQL(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range sqlMigrations[version-1] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", version, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations(version) VALUES($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqlContainerMetadataRepository struct {
+	conn *sql.DB
+}
+
+// SQL is the repository backed by SQLite or Postgres, keeping every checkpoint of the
+// containers. The schema must be migrated with MigrateSQL.
+func SQL(db *sql.DB) *sqlContainerMetadataRepository {
+	return &sqlContainerMetadataRepository{
+		conn: db,
+	}
+}
+
+func (r *sqlContainerMetadataRepository) Insert(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	return insertCheckpoint(r.conn, identity, checkpointHash, metadata)
+}
+
+// execer is either the connection or a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertCheckpoint(conn execer, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	query := `INSERT INTO checkpoints(namespace, pod, container, checkpoint_hash, last_timestamp, last_request_solved_id, last_version, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (namespace, pod, container, checkpoint_hash) DO UPDATE
+		SET last_timestamp = excluded.last_timestamp, last_request_solved_id = excluded.last_request_solved_id, last_version = excluded.last_version`
+	_, err := conn.Exec(query, identity.Namespace, identity.Pod, identity.Container, checkpointHash, metadata.LastTimestamp.UnixNano(), metadata.LastRequestSolvedID, metadata.LastVersion, time.Now().UnixNano())
+	return err
+}
+
+func (r *sqlContainerMetadataRepository) Get(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	query := "SELECT last_timestamp, last_request_solved_id, last_version FROM checkpoints WHERE namespace=$1 AND pod=$2 AND container=$3 AND checkpoint_hash=$4"
+	row := r.conn.QueryRow(query, identity.Namespace, identity.Pod, identity.Container, checkpointHash)
+
+	var lastTimestamp int64
+	var metadata entity.ContainerMetadata
+	if err := row.Scan(&lastTimestamp, &metadata.LastRequestSolvedID, &metadata.LastVersion); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: no checkpoint %q of container %q", entity.ErrNotFound, checkpointHash, identity)
+		}
+		return nil, err
+	}
+	metadata.LastTimestamp = time.Unix(0, lastTimestamp)
+
+	return &metadata, nil
+}
+
+func (r *sqlContainerMetadataRepository) UpsertContainerLatestCheckpoint(identity entity.ContainerIdentity, checkpointHash string) error {
+	query := `INSERT INTO latest_checkpoints(namespace, pod, container, checkpoint_hash, last_timestamp)
+		VALUES($1, $2, $3, $4, COALESCE((SELECT last_timestamp FROM checkpoints WHERE namespace=$1 AND pod=$2 AND container=$3 AND checkpoint_hash=$4), 0))
+		ON CONFLICT (namespace, pod, container) DO UPDATE
+		SET checkpoint_hash = excluded.checkpoint_hash, last_timestamp = excluded.last_timestamp`
+	_, err := r.conn.Exec(query, identity.Namespace, identity.Pod, identity.Container, checkpointHash)
+	return err
+}
+
+func (r *sqlContainerMetadataRepository) LatestContainerCheckpoint(identity entity.ContainerIdentity) (string, error) {
+	query := "SELECT checkpoint_hash FROM latest_checkpoints WHERE namespace=$1 AND pod=$2 AND container=$3"
+	var checkpointHash string
+	if err := r.conn.QueryRow(query, identity.Namespace, identity.Pod, identity.Container).Scan(&checkpointHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: no checkpoint of container %q", entity.ErrNotFound, identity)
+		}
+		return "", err
+	}
+	return checkpointHash, nil
+}
+
+// SaveCheckpoint inserts the checkpoint and advances the latest checkpoint in a
+// transaction. The latest checkpoint is only replaced by a checkpoint at least as
+// recent, which the upsert checks itself so concurrent transactions cannot move it
+// back.
+func (r *sqlContainerMetadataRepository) SaveCheckpoint(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	tx, err := r.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := insertCheckpoint(tx, identity, checkpointHash, metadata); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `INSERT INTO latest_checkpoints(namespace, pod, container, checkpoint_hash, last_timestamp)
+		VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (namespace, pod, container) DO UPDATE
+		SET checkpoint_hash = excluded.checkpoint_hash, last_timestamp = excluded.last_timestamp
+		WHERE latest_checkpoints.last_timestamp <= excluded.last_timestamp`
+	if _, err := tx.Exec(query, identity.Namespace, identity.Pod, identity.Container, checkpointHash, metadata.LastTimestamp.UnixNano()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListCheckpoints returns every checkpoint of the container, oldest first.
+func (r *sqlContainerMetadataRepository) ListCheckpoints(identity entity.ContainerIdentity) ([]*entity.Checkpoint, error) {
+	query := `SELECT checkpoint_hash, last_timestamp, last_request_solved_id, last_version, created_at FROM checkpoints
+		WHERE namespace=$1 AND pod=$2 AND container=$3 ORDER BY last_timestamp, created_at`
+	rows, err := r.conn.Query(query, identity.Namespace, identity.Pod, identity.Container)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkpoints []*entity.Checkpoint
+	for rows.Next() {
+		var lastTimestamp, createdAt int64
+		checkpoint := entity.Checkpoint{Container: identity}
+		if err := rows.Scan(&checkpoint.Hash, &lastTimestamp, &checkpoint.Metadata.LastRequestSolvedID, &checkpoint.Metadata.LastVersion, &createdAt); err != nil {
+			return nil, err
+		}
+		checkpoint.Metadata.LastTimestamp = time.Unix(0, lastTimestamp)
+		checkpoint.CreatedAt = time.Unix(0, createdAt)
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+
+	return checkpoints, rows.Err()
+}
+
+func (r *sqlContainerMetadataRepository) Ping(ctx context.Context) error {
+	return r.conn.PingContext(ctx)
+}
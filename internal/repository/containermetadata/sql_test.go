@@ -0,0 +1,112 @@
+package containermetadata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQL(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		dsn := "file:" + filepath.Join(t.TempDir(), "statemanager.db") + "?_pragma=busy_timeout(5000)"
+		testSQL(t, openSQL(t, "sqlite", dsn))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("STATE_MANAGER_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("STATE_MANAGER_TEST_POSTGRES_DSN is not set")
+		}
+		db := openSQL(t, "postgres", dsn)
+		for _, table := range []string{"checkpoints", "latest_checkpoints", "schema_migrations"} {
+			db.Exec("DROP TABLE IF EXISTS " + table)
+		}
+		testSQL(t, db)
+	})
+}
+
+func openSQL(t *testing.T, driver string, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testSQL(t *testing.T, db *sql.DB) {
+	for i := 0; i < 2; i++ {
+		if err := MigrateSQL(context.Background(), db); err != nil {
+			t.Fatalf("expected migrations to apply more than once, got %v\n", err)
+		}
+	}
+	repository := SQL(db)
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app-0", Container: "test"}
+	now := time.Now()
+
+	t.Run("it should save and retrieve checkpoints", func(t *testing.T) {
+		metadata := &entity.ContainerMetadata{LastTimestamp: now, LastRequestSolvedID: "request"}
+		if err := repository.SaveCheckpoint(identity, "first", metadata); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		saved, err := repository.Get(identity, "first")
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if !saved.LastTimestamp.Equal(now) || saved.LastRequestSolvedID != "request" {
+			t.Errorf("expected %+v, got %+v\n", metadata, saved)
+		}
+		if _, err := repository.Get(identity, "unknown"); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+
+	t.Run("it should not move the latest checkpoint back to an older one", func(t *testing.T) {
+		repository.SaveCheckpoint(identity, "second", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Minute)})
+		repository.SaveCheckpoint(identity, "late", &entity.ContainerMetadata{LastTimestamp: now.Add(time.Second)})
+
+		latest, err := repository.LatestContainerCheckpoint(identity)
+		if err != nil || latest != "second" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "second", latest, err)
+		}
+	})
+
+	t.Run("it should keep the history of the container", func(t *testing.T) {
+		checkpoints, err := repository.ListCheckpoints(identity)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		var hashes []string
+		for _, checkpoint := range checkpoints {
+			hashes = append(hashes, checkpoint.Hash)
+		}
+		if len(hashes) != 3 || hashes[0] != "first" || hashes[1] != "late" || hashes[2] != "second" {
+			t.Errorf("expected checkpoints [first late second], got %v\n", hashes)
+		}
+	})
+
+	t.Run("it should set the latest checkpoint explicitly", func(t *testing.T) {
+		if err := repository.UpsertContainerLatestCheckpoint(identity, "first"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		latest, err := repository.LatestContainerCheckpoint(identity)
+		if err != nil || latest != "first" {
+			t.Errorf("expected latest checkpoint %q, got %q (%v)\n", "first", latest, err)
+		}
+
+		other := entity.ContainerIdentity{Namespace: "other", Pod: "app-0", Container: "test"}
+		if _, err := repository.LatestContainerCheckpoint(other); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+}
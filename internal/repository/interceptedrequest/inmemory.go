@@ -1,12 +1,17 @@
 package interceptedrequest
 
 import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
 )
 
 type InMemoryInterceptedRequestRepository struct {
+	mutex    sync.RWMutex
 	requests map[string]*entity.InterceptedRequest
 }
 
@@ -17,32 +22,47 @@ func InMemory() entity.InterceptedRequestRepository {
 }
 
 func (r *InMemoryInterceptedRequestRepository) Save(req *entity.InterceptedRequest) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	r.requests[req.ID] = req
 	return nil
 }
 
+// SetSolved replaces the request by a solved copy, the requests already returned are
+// left untouched for their readers.
 func (r *InMemoryInterceptedRequestRepository) SetSolved(reqID string, solvedAt time.Time, solved bool) error {
-	req := r.requests[reqID]
-	req.SolvedAt = &solvedAt
-	req.Solved = true
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	req, ok := r.requests[reqID]
+	if !ok {
+		return fmt.Errorf("%w: no intercepted request %q", entity.ErrNotFound, reqID)
+	}
+	updated := *req
+	updated.SolvedAt = &solvedAt
+	updated.Solved = solved
+	r.requests[reqID] = &updated
 	return nil
 }
 
+// GetLastRequestSolved returns the request solved last, nil when none was solved.
 func (r *InMemoryInterceptedRequestRepository) GetLastRequestSolved() (*entity.InterceptedRequest, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	var lastRequest *entity.InterceptedRequest
 	for _, req := range r.requests {
-		if lastRequest == nil {
+		if !req.Solved || req.SolvedAt == nil {
+			continue
+		}
+		if lastRequest == nil || req.SolvedAt.After(*lastRequest.SolvedAt) {
 			lastRequest = req
-		} else {
-			if req.SolvedAt.Compare(*lastRequest.SolvedAt) < 0 {
-				lastRequest = req
-			}
 		}
 	}
 	return lastRequest, nil
 }
 
 func (r *InMemoryInterceptedRequestRepository) GetAll() ([]*entity.InterceptedRequest, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	var requests []*entity.InterceptedRequest
 	for _, req := range r.requests {
 		requests = append(requests, req)
@@ -51,6 +71,8 @@ func (r *InMemoryInterceptedRequestRepository) GetAll() ([]*entity.InterceptedRe
 }
 
 func (r *InMemoryInterceptedRequestRepository) GetLastVersion() (int, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	greatestVersion := 0
 	for _, req := range r.requests {
 		if req.Version > greatestVersion {
@@ -61,11 +83,17 @@ func (r *InMemoryInterceptedRequestRepository) GetLastVersion() (int, error) {
 }
 
 func (r *InMemoryInterceptedRequestRepository) GetAllFromLastVersion(version int) ([]*entity.InterceptedRequest, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	var requests []*entity.InterceptedRequest
 	for _, req := range r.requests {
 		if req.Version >= version {
 			requests = append(requests, req)
 		}
 	}
+	// Keep the version order of the SQL repository, the requests are reprojected in it.
+	slices.SortFunc(requests, func(a, b *entity.InterceptedRequest) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
 	return requests, nil
 }
@@ -0,0 +1,37 @@
+package interceptedrequest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+func TestInMemory(t *testing.T) {
+	repository := InMemory()
+	for i, id := range []string{"first", "second", "third"} {
+		req := &entity.InterceptedRequest{ID: id, Version: i + 1, Request: httptest.NewRequest(http.MethodGet, "/", nil)}
+		if err := repository.Save(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should have no solved request before one is solved", func(t *testing.T) {
+		req, err := repository.GetLastRequestSolved()
+		if err != nil || req != nil {
+			t.Errorf("expected no solved request, got %v and %v\n", req, err)
+		}
+	})
+
+	t.Run("it should return the request solved last", func(t *testing.T) {
+		now := time.Now()
+		repository.SetSolved("second", now.Add(time.Second), true)
+		repository.SetSolved("first", now, true)
+		req, err := repository.GetLastRequestSolved()
+		if err != nil || req == nil || req.ID != "second" {
+			t.Errorf("expected the second request, got %v and %v\n", req, err)
+		}
+	})
+}
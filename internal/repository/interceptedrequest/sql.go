@@ -1,134 +1,184 @@
 package interceptedrequest
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
 )
 
-type SQLInterceptedRequestRepository struct {
-	conn *sql.DB
+// sqlMigrations are the schema migrations of the SQL repository, applied in order
+// and recorded by version in intercepted_request_migrations. The statements are
+// portable between SQLite and Postgres, timestamps are stored as unix nanoseconds and
+// the headers as JSON.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE intercepted_request (
+			id TEXT PRIMARY KEY,
+			version BIGINT NOT NULL,
+			received_at BIGINT NOT NULL,
+			solved_at BIGINT,
+			solved BOOLEAN NOT NULL,
+			method TEXT NOT NULL,
+			url TEXT NOT NULL,
+			host TEXT NOT NULL,
+			header TEXT NOT NULL,
+			body BYTEA,
+			body_truncated BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX intercepted_request_version ON intercepted_request (version)`,
+	},
 }
 
-func SQL(db *sql.DB) entity.InterceptedRequestRepository {
-	return &SQLInterceptedRequestRepository{
-		conn: db,
+// MigrateSQL creates or upgrades the schema of the SQL repository.
+func MigrateSQL(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS intercepted_request_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
 	}
-}
 
-func (r *SQLInterceptedRequestRepository) Save(req *entity.InterceptedRequest) error {
-	tx, err := r.conn.Begin()
-	if err != nil {
+	var current int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM intercepted_request_migrations").Scan(&current); err != nil {
 		return err
 	}
-	defer tx.Commit()
 
-	query := "INSERT INTO intercepted_request(id, solved_at, solved, req, version) VALUES($1, $2, $3, $4, $5)"
-	_, err = tx.Exec(query, req.ID, nil, req.Solved, req.Request, req.Version)
-	if err != nil {
-		if err := tx.Rollback(); err != nil {
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range sqlMigrations[version-1] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %w", version, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO intercepted_request_migrations(version) VALUES($1)", version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if err := tx.Commit(); err != nil {
 			return err
 		}
-		return err
 	}
-
 	return nil
 }
 
-func (r *SQLInterceptedRequestRepository) SetSolved(reqID string, solvedAt time.Time, solved bool) error {
-	tx, err := r.conn.Begin()
-	if err != nil {
-		return err
+type SQLInterceptedRequestRepository struct {
+	conn *sql.DB
+}
+
+// SQL is the repository backed by SQLite or Postgres. The schema must be migrated
+// with MigrateSQL.
+func SQL(db *sql.DB) entity.InterceptedRequestRepository {
+	return &SQLInterceptedRequestRepository{
+		conn: db,
 	}
-	defer tx.Commit()
+}
 
-	query := "UPDATE intercepted_request SET solved_at=$1, solved=$2 WHERE id=$3"
-	_, err = tx.Exec(query, solvedAt, solved, reqID)
+const selectColumns = "SELECT id, version, received_at, solved_at, solved, method, url, host, header, body, body_truncated FROM intercepted_request"
+
+func (r *SQLInterceptedRequestRepository) Save(req *entity.InterceptedRequest) error {
+	header, err := json.Marshal(req.Request.Header)
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			return err
-		}
 		return err
 	}
+	var solvedAt sql.NullInt64
+	if req.SolvedAt != nil {
+		solvedAt = sql.NullInt64{Int64: req.SolvedAt.UnixNano(), Valid: true}
+	}
 
-	return nil
+	query := `INSERT INTO intercepted_request(id, version, received_at, solved_at, solved, method, url, host, header, body, body_truncated)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err = r.conn.Exec(query, req.ID, req.Version, req.ReceivedAt.UnixNano(), solvedAt, req.Solved,
+		req.Request.Method, req.Request.URL.String(), req.Request.Host, string(header), req.Body, req.BodyTruncated)
+	return err
 }
 
+func (r *SQLInterceptedRequestRepository) SetSolved(reqID string, solvedAt time.Time, solved bool) error {
+	_, err := r.conn.Exec("UPDATE intercepted_request SET solved_at=$1, solved=$2 WHERE id=$3", solvedAt.UnixNano(), solved, reqID)
+	return err
+}
+
+// GetLastRequestSolved returns the request solved last, nil when none was solved.
 func (r *SQLInterceptedRequestRepository) GetLastRequestSolved() (*entity.InterceptedRequest, error) {
-	stmt, err := r.conn.Prepare("SELECT id, solved_at, solved, req FROM intercepted_request, version ORDER BY solved_at DESC LIMIT 1")
-	if err != nil {
-		return nil, err
+	req, err := scanRequest(r.conn.QueryRow(selectColumns + " WHERE solved AND solved_at IS NOT NULL ORDER BY solved_at DESC LIMIT 1"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
-	defer stmt.Close()
+	return req, err
+}
 
-	var req entity.InterceptedRequest
-	row := stmt.QueryRow()
-	if err := row.Scan(&req.ID, &req.SolvedAt, &req.Solved, &req.Request, &req.Version); err != nil {
-		return nil, err
-	}
+func (r *SQLInterceptedRequestRepository) GetAll() ([]*entity.InterceptedRequest, error) {
+	return r.query(selectColumns + " ORDER BY version")
+}
 
-	return &req, nil
+// GetLastVersion returns the greatest version of the requests, 0 when there is none.
+func (r *SQLInterceptedRequestRepository) GetLastVersion() (int, error) {
+	var version int
+	err := r.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM intercepted_request").Scan(&version)
+	return version, err
 }
 
-func (r *SQLInterceptedRequestRepository) GetAll() ([]*entity.InterceptedRequest, error) {
-	stmt, err := r.conn.Prepare("SELECT id, solved_at, solved, req, version FROM intercepted_request ORDER BY solved_at")
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
+func (r *SQLInterceptedRequestRepository) GetAllFromLastVersion(version int) ([]*entity.InterceptedRequest, error) {
+	return r.query(selectColumns+" WHERE version >= $1 ORDER BY version", version)
+}
 
-	var requests []*entity.InterceptedRequest
-	rows, err := stmt.Query()
+func (r *SQLInterceptedRequestRepository) query(query string, args ...any) ([]*entity.InterceptedRequest, error) {
+	rows, err := r.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
+	var requests []*entity.InterceptedRequest
 	for rows.Next() {
-		var req entity.InterceptedRequest
-		if err := rows.Scan(&req.ID, &req.SolvedAt, &req.Solved, &req.Request, &req.Version); err != nil {
+		req, err := scanRequest(rows)
+		if err != nil {
 			return nil, err
 		}
-		requests = append(requests, &req)
+		requests = append(requests, req)
 	}
-
-	return requests, nil
+	return requests, rows.Err()
 }
 
-func (r *SQLInterceptedRequestRepository) GetLastVersion() (int, error) {
-	var version int
+// scanner is either a row or rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
 
-	stmt, err := r.conn.Prepare("SELECT version FROM intercepted_request ORDER BY version DESC")
-	if err != nil {
-		return version, err
+// scanRequest rebuilds an intercepted request from its columns.
+func scanRequest(row scanner) (*entity.InterceptedRequest, error) {
+	var req entity.InterceptedRequest
+	var receivedAt int64
+	var solvedAt sql.NullInt64
+	var method, url, host, header string
+	if err := row.Scan(&req.ID, &req.Version, &receivedAt, &solvedAt, &req.Solved, &method, &url, &host, &header, &req.Body, &req.BodyTruncated); err != nil {
+		return nil, err
 	}
-	defer stmt.Close()
 
-	row := stmt.QueryRow()
-	err = row.Scan(&version)
-	return version, err
-}
+	req.ReceivedAt = time.Unix(0, receivedAt)
+	if solvedAt.Valid {
+		t := time.Unix(0, solvedAt.Int64)
+		req.SolvedAt = &t
+	}
 
-func (r *SQLInterceptedRequestRepository) GetAllFromLastVersion(version int) ([]*entity.InterceptedRequest, error) {
-	stmt, err := r.conn.Prepare("SELECT id, solved_at, solved, req, version FROM intercepted_request WHERE version >= $1 ORDER BY version ASC")
+	request, err := http.NewRequest(method, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
-
-	var requests []*entity.InterceptedRequest
-	rows, err := stmt.Query(version)
-	if err != nil {
+	request.Host = host
+	if err := json.Unmarshal([]byte(header), &request.Header); err != nil {
 		return nil, err
 	}
-
-	for rows.Next() {
-		var req entity.InterceptedRequest
-		if err := rows.Scan(&req.ID, &req.SolvedAt, &req.Solved, &req.Request, &req.Version); err != nil {
-			return nil, err
-		}
-		requests = append(requests, &req)
+	if request.Header == nil {
+		request.Header = http.Header{}
 	}
+	req.Request = request
 
-	return requests, nil
+	return &req, nil
 }
@@ -0,0 +1,122 @@
+package interceptedrequest
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQL(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		dsn := "file:" + filepath.Join(t.TempDir(), "interceptor.db") + "?_pragma=busy_timeout(5000)"
+		testSQL(t, openSQL(t, "sqlite", dsn))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("INTERCEPTOR_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("INTERCEPTOR_TEST_POSTGRES_DSN is not set")
+		}
+		db := openSQL(t, "postgres", dsn)
+		for _, table := range []string{"intercepted_request", "intercepted_request_migrations"} {
+			db.Exec("DROP TABLE IF EXISTS " + table)
+		}
+		testSQL(t, db)
+	})
+}
+
+func openSQL(t *testing.T, driver string, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testSQL(t *testing.T, db *sql.DB) {
+	for i := 0; i < 2; i++ {
+		if err := MigrateSQL(context.Background(), db); err != nil {
+			t.Fatalf("expected migrations to apply more than once, got %v\n", err)
+		}
+	}
+	repository := SQL(db)
+	receivedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("it should have no version nor solved request when empty", func(t *testing.T) {
+		version, err := repository.GetLastVersion()
+		if err != nil || version != 0 {
+			t.Errorf("expected version 0, got %d and %v\n", version, err)
+		}
+		req, err := repository.GetLastRequestSolved()
+		if err != nil || req != nil {
+			t.Errorf("expected no solved request, got %v and %v\n", req, err)
+		}
+	})
+
+	t.Run("it should read back the saved requests", func(t *testing.T) {
+		for i, req := range []*entity.InterceptedRequest{
+			{ID: "first", Version: 1, ReceivedAt: receivedAt, Request: httptest.NewRequest(http.MethodGet, "http://app/orders?page=2", nil)},
+			{ID: "second", Version: 2, ReceivedAt: receivedAt.Add(time.Second), Request: httptest.NewRequest(http.MethodPost, "http://app/orders", nil), Body: []byte{0xff, 0x00, 0xfe}, BodyTruncated: true},
+		} {
+			req.Request.Header.Set("X-Tenant", "acme")
+			if err := repository.Save(req); err != nil {
+				t.Fatalf("expected no error saving request %d, got %v\n", i, err)
+			}
+		}
+
+		requests, err := repository.GetAllFromLastVersion(2)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if len(requests) != 1 {
+			t.Fatalf("expected 1 request since version 2, got %d\n", len(requests))
+		}
+		second := requests[0]
+		if second.ID != "second" || second.Request.Method != http.MethodPost || second.Request.URL.String() != "http://app/orders" || second.Request.Host != "app" {
+			t.Errorf("expected the second request, got %+v\n", second)
+		}
+		if string(second.Body) != string([]byte{0xff, 0x00, 0xfe}) || !second.BodyTruncated || second.Request.Header.Get("X-Tenant") != "acme" {
+			t.Errorf("expected the body and headers of the second request, got %v and %v\n", second.Body, second.Request.Header)
+		}
+		if !second.ReceivedAt.Equal(receivedAt.Add(time.Second)) || second.SolvedAt != nil || second.Solved {
+			t.Errorf("expected the second request received and not solved, got %+v\n", second)
+		}
+
+		version, err := repository.GetLastVersion()
+		if err != nil || version != 2 {
+			t.Errorf("expected version 2, got %d and %v\n", version, err)
+		}
+	})
+
+	t.Run("it should return the request solved last", func(t *testing.T) {
+		solvedAt := receivedAt.Add(2 * time.Second)
+		if err := repository.SetSolved("second", solvedAt, true); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if err := repository.SetSolved("first", solvedAt.Add(time.Second), true); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		req, err := repository.GetLastRequestSolved()
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if req == nil || req.ID != "first" || !req.Solved || req.SolvedAt == nil || !req.SolvedAt.Equal(solvedAt.Add(time.Second)) {
+			t.Errorf("expected the first request solved last, got %+v\n", req)
+		}
+		if query := req.Request.URL.Query().Get("page"); query != "2" {
+			t.Errorf("expected the query string of the first request, got %q\n", query)
+		}
+	})
+}
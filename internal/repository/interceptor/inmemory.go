@@ -0,0 +1,92 @@
+package interceptor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type inMemoryInterceptorRepository struct {
+	mutex        sync.RWMutex
+	interceptors map[string]*entity.Interceptor
+}
+
+func InMemory() entity.InterceptorRepository {
+	return &inMemoryInterceptorRepository{
+		interceptors: make(map[string]*entity.Interceptor),
+	}
+}
+
+func (r *inMemoryInterceptorRepository) GetById(id string) (*entity.Interceptor, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	interceptor, ok := r.interceptors[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no interceptor with id %q", entity.ErrNotFound, id)
+	}
+	return interceptor, nil
+}
+
+func (r *inMemoryInterceptorRepository) GetMonitoredContainer(interceptor *entity.Interceptor) (*entity.Container, error) {
+	stored, err := r.GetById(interceptor.ID)
+	if err != nil {
+		return nil, err
+	}
+	return stored.MonitoredContainer, nil
+}
+
+func (r *inMemoryInterceptorRepository) Create(interceptor *entity.Interceptor) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.interceptors[interceptor.ID]; ok {
+		return fmt.Errorf("interceptor with id %q already exists", interceptor.ID)
+	}
+	r.interceptors[interceptor.ID] = interceptor
+	return nil
+}
+
+func (r *inMemoryInterceptorRepository) UpdateInterceptorConfig(interceptorId string, config *interceptorConfig.Config) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	interceptor, ok := r.interceptors[interceptorId]
+	if !ok {
+		return fmt.Errorf("%w: no interceptor with id %q", entity.ErrNotFound, interceptorId)
+	}
+	// Replace rather than mutate the stored interceptor so readers holding the previous
+	// one are not affected.
+	updated := *interceptor
+	updated.Config = config
+	r.interceptors[interceptorId] = &updated
+	return nil
+}
+
+func (r *inMemoryInterceptorRepository) Update(interceptor *entity.Interceptor) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.interceptors[interceptor.ID]; !ok {
+		return fmt.Errorf("%w: no interceptor with id %q", entity.ErrNotFound, interceptor.ID)
+	}
+	r.interceptors[interceptor.ID] = interceptor
+	return nil
+}
+
+func (r *inMemoryInterceptorRepository) List() ([]*entity.Interceptor, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	interceptors := make([]*entity.Interceptor, 0, len(r.interceptors))
+	for _, interceptor := range r.interceptors {
+		interceptors = append(interceptors, interceptor)
+	}
+	sort.Slice(interceptors, func(i, j int) bool {
+		return interceptors[i].ID < interceptors[j].ID
+	})
+	return interceptors, nil
+}
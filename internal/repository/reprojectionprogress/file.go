@@ -0,0 +1,80 @@
+package reprojectionprogress
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type fileReprojectionProgressRepository struct {
+	mutex    sync.Mutex
+	filename string
+}
+
+// File stores the progress as JSON in the file, which is absent when no reprojection
+// is unfinished.
+func File(filename string) entity.ReprojectionProgressRepository {
+	return &fileReprojectionProgressRepository{filename: filename}
+}
+
+func (r *fileReprojectionProgressRepository) Get() (*entity.ReprojectionProgress, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	content, err := os.ReadFile(r.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var progress entity.ReprojectionProgress
+	if err := json.Unmarshal(content, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// Save atomically replaces the file, so a crash while saving leaves the previous
+// progress.
+func (r *fileReprojectionProgressRepository) Save(progress *entity.ReprojectionProgress) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	content, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := r.filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, r.filename)
+}
+
+func (r *fileReprojectionProgressRepository) Clear() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := os.Remove(r.filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
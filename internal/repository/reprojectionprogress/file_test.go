@@ -0,0 +1,47 @@
+package reprojectionprogress
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+func TestFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "progress.json")
+	repository := File(filename)
+
+	t.Run("it should have no progress before the first save", func(t *testing.T) {
+		progress, err := repository.Get()
+		if err != nil || progress != nil {
+			t.Errorf("expected no progress, got %v and error %v\n", progress, err)
+		}
+	})
+
+	t.Run("it should read the saved progress again", func(t *testing.T) {
+		saved := &entity.ReprojectionProgress{FromVersion: 4, ReplayedVersion: 9, UpdatedAt: time.Now().UTC()}
+		if err := repository.Save(saved); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		progress, err := File(filename).Get()
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if progress.FromVersion != 4 || progress.ReplayedVersion != 9 || !progress.UpdatedAt.Equal(saved.UpdatedAt) {
+			t.Errorf("expected progress %v, got %v\n", saved, progress)
+		}
+	})
+
+	t.Run("it should have no progress once cleared", func(t *testing.T) {
+		if err := repository.Clear(); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if progress, _ := repository.Get(); progress != nil {
+			t.Errorf("expected no progress, got %v\n", progress)
+		}
+		if err := repository.Clear(); err != nil {
+			t.Errorf("expected clearing again to succeed, got %v\n", err)
+		}
+	})
+}
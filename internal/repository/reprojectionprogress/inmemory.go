@@ -0,0 +1,43 @@
+package reprojectionprogress
+
+import (
+	"sync"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type inMemoryReprojectionProgressRepository struct {
+	mutex    sync.Mutex
+	progress *entity.ReprojectionProgress
+}
+
+// InMemory keeps the progress in memory, it does not survive a restart of the
+// Interceptor.
+func InMemory() entity.ReprojectionProgressRepository {
+	return &inMemoryReprojectionProgressRepository{}
+}
+
+func (r *inMemoryReprojectionProgressRepository) Get() (*entity.ReprojectionProgress, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.progress == nil {
+		return nil, nil
+	}
+	progress := *r.progress
+	return &progress, nil
+}
+
+func (r *inMemoryReprojectionProgressRepository) Save(progress *entity.ReprojectionProgress) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	saved := *progress
+	r.progress = &saved
+	return nil
+}
+
+func (r *inMemoryReprojectionProgressRepository) Clear() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.progress = nil
+	return nil
+}
@@ -2,9 +2,12 @@ package checkpoint
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
 	"github.com/checkpoint-restore/go-criu/v6"
 	"github.com/checkpoint-restore/go-criu/v6/rpc"
 )
@@ -44,9 +47,37 @@ func (service *CRIUCheckpointService) Checkpoint(config *entity.CheckpointConfig
 
 	// Uses the dump command on CRIU to dump a new checkpoint image of the process in
 	// the given directory by the configuration.
-	return service.Dump(&rpc.CriuOpts{
+	err = service.Dump(&rpc.CriuOpts{
 		Pid:          &config.Container.PID,
 		ImagesDirFd:  &imagesDirFd,
 		LeaveRunning: &leaveRunning,
 	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if size, err := directorySize(checkpointImageDirectory); err == nil {
+		metrics.CheckpointSize.Set(float64(size))
+	}
+	return nil
+}
+
+// directorySize sums the size of the files in the directory.
+func directorySize(directory string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
 }
@@ -0,0 +1,171 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type directoryImageStore struct {
+	imagesDirectory string
+}
+
+// Directory stores the checkpoint images in the directories of their hash under the
+// images directory, where the CRIU checkpoint service dumps them.
+func Directory(imagesDirectory string) *directoryImageStore {
+	return &directoryImageStore{
+		imagesDirectory: imagesDirectory,
+	}
+}
+
+// imageDirectory returns the directory of the image, refusing hashes escaping the
+// images directory.
+func (s *directoryImageStore) imageDirectory(checkpointHash string) (string, error) {
+	if checkpointHash == "" || checkpointHash != filepath.Base(checkpointHash) || strings.HasPrefix(checkpointHash, ".") {
+		return "", fmt.Errorf("invalid checkpoint hash %q", checkpointHash)
+	}
+	return filepath.Join(s.imagesDirectory, checkpointHash), nil
+}
+
+func (s *directoryImageStore) Export(checkpointHash string, w io.Writer) error {
+	directory, err := s.imageDirectory(checkpointHash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(directory); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: no image of checkpoint %q", entity.ErrNotFound, checkpointHash)
+	}
+
+	archive := tar.NewWriter(w)
+	err = filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// List returns the image directories, without the ones of the imports in progress.
+func (s *directoryImageStore) List() ([]entity.CheckpointImage, error) {
+	entries, err := os.ReadDir(s.imagesDirectory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var images []entity.CheckpointImage
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, entity.CheckpointImage{Hash: entry.Name(), CreatedAt: info.ModTime()})
+	}
+	return images, nil
+}
+
+func (s *directoryImageStore) Delete(checkpointHash string) error {
+	directory, err := s.imageDirectory(checkpointHash)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(directory)
+}
+
+// Import extracts the image in a temporary directory renamed once complete, so a
+// failed transfer does not leave a partial image behind.
+func (s *directoryImageStore) Import(checkpointHash string, r io.Reader) error {
+	directory, err := s.imageDirectory(checkpointHash)
+	if err != nil {
+		return err
+	}
+	temporary, err := os.MkdirTemp(s.imagesDirectory, "."+checkpointHash+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temporary)
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid file %q in the image of checkpoint %q", header.Name, checkpointHash)
+		}
+
+		path := filepath.Join(temporary, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, archive)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(directory); err != nil {
+		return err
+	}
+	return os.Rename(temporary, directory)
+}
@@ -0,0 +1,91 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+func TestDirectory(t *testing.T) {
+	source := Directory(t.TempDir())
+	target := Directory(t.TempDir())
+
+	image := filepath.Join(source.imagesDirectory, "test-0123")
+	if err := os.MkdirAll(filepath.Join(image, "pages"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"inventory.img":   "inventory",
+		"pages/pages.img": "pages",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(image, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should move an image between directories", func(t *testing.T) {
+		var archive bytes.Buffer
+		if err := source.Export("test-0123", &archive); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if err := target.Import("test-0123", &archive); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		for name, content := range files {
+			imported, err := os.ReadFile(filepath.Join(target.imagesDirectory, "test-0123", name))
+			if err != nil || string(imported) != content {
+				t.Errorf("expected %s to contain %q, got %q (%v)\n", name, content, imported, err)
+			}
+		}
+	})
+
+	t.Run("it should not find unknown images", func(t *testing.T) {
+		if err := source.Export("test-unknown", &bytes.Buffer{}); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+
+	t.Run("it should reject hashes outside the images directory", func(t *testing.T) {
+		if err := source.Export("../test-0123", &bytes.Buffer{}); err == nil {
+			t.Error("expected an error for a hash escaping the images directory")
+		}
+	})
+
+	t.Run("it should list and delete the images", func(t *testing.T) {
+		images, err := target.List()
+		if err != nil || len(images) != 1 || images[0].Hash != "test-0123" {
+			t.Fatalf("expected the imported image, got %v (%v)\n", images, err)
+		}
+		if err := target.Delete("test-0123"); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if images, _ := target.List(); len(images) != 0 {
+			t.Errorf("expected no image left, got %v\n", images)
+		}
+		if err := target.Delete("../test-0123"); err == nil {
+			t.Error("expected an error for a hash escaping the images directory")
+		}
+	})
+
+	t.Run("it should reject archives with files outside the image", func(t *testing.T) {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+		writer.WriteHeader(&tar.Header{Name: "../escaped.img", Mode: 0o600, Size: 1, Typeflag: tar.TypeReg})
+		writer.Write([]byte("x"))
+		writer.Close()
+
+		if err := target.Import("test-escape", &archive); err == nil {
+			t.Error("expected an error for a file escaping the image")
+		}
+		if _, err := os.Stat(filepath.Join(target.imagesDirectory, "test-escape")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no partial image, got %v\n", err)
+		}
+	})
+}
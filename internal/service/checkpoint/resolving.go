@@ -0,0 +1,34 @@
+package checkpoint
+
+import (
+	"log/slog"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+)
+
+type resolvingPIDCheckpointService struct {
+	next        entity.CheckpointService
+	pidResolver entity.PIDResolver
+}
+
+// ResolvingPID resolves the container PID before every checkpoint, so checkpoints
+// keep targeting the container after it is restarted or restored.
+func ResolvingPID(next entity.CheckpointService, pidResolver entity.PIDResolver) entity.CheckpointService {
+	return &resolvingPIDCheckpointService{
+		next:        next,
+		pidResolver: pidResolver,
+	}
+}
+
+func (s *resolvingPIDCheckpointService) Checkpoint(config *entity.CheckpointConfig) error {
+	pid, err := s.pidResolver.ResolvePID(config.Container)
+	if err != nil {
+		return err
+	}
+	if pid != config.Container.PID {
+		slog.Info("container PID resolved", logging.KeyContainer, config.Container.Name, "pid", pid, "previous_pid", config.Container.PID)
+		config.Container.PID = pid
+	}
+	return s.next.Checkpoint(config)
+}
@@ -0,0 +1,68 @@
+package configwatcher
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+)
+
+// fileWatcher polls a file for changes of its content. Polling the content, rather
+// than watching filesystem events, also detects the symlink swap Kubernetes performs
+// when updating a mounted ConfigMap.
+type fileWatcher struct {
+	filename string
+	interval time.Duration
+	onChange func(content []byte) error
+	content  []byte
+}
+
+// File creates a watcher calling onChange with the new content of the file every
+// time it changes.
+func File(filename string, interval time.Duration, onChange func(content []byte) error) (*fileWatcher, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &fileWatcher{
+		filename: filename,
+		interval: interval,
+		onChange: onChange,
+		content:  content,
+	}, nil
+}
+
+// Run checks the file every interval until the context is done.
+func (w *fileWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *fileWatcher) check() {
+	content, err := os.ReadFile(w.filename)
+	if err != nil {
+		// The file may be briefly missing while it is replaced.
+		slog.Error("could not read watched file", "file", w.filename, logging.Error(err))
+		return
+	}
+	if bytes.Equal(content, w.content) {
+		return
+	}
+
+	if err := w.onChange(content); err != nil {
+		slog.Error("could not apply changes of watched file", "file", w.filename, logging.Error(err))
+		return
+	}
+	w.content = content
+}
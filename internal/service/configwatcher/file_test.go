@@ -0,0 +1,40 @@
+package configwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte("port: 8001"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []string
+	watcher, err := File(filename, time.Second, func(content []byte) error {
+		changes = append(changes, string(content))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should not notify unchanged content", func(t *testing.T) {
+		watcher.check()
+		if len(changes) != 0 {
+			t.Errorf("expected no changes, got %v\n", changes)
+		}
+	})
+
+	t.Run("it should notify the new content", func(t *testing.T) {
+		os.WriteFile(filename, []byte("port: 8002"), 0o644)
+		watcher.check()
+		watcher.check()
+		if len(changes) != 1 || changes[0] != "port: 8002" {
+			t.Errorf("expected a single change to %q, got %v\n", "port: 8002", changes)
+		}
+	})
+}
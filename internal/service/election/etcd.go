@@ -0,0 +1,122 @@
+// Package election elects the leader of the State Manager replicas.
+package election
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	client "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// ETCDConfig is the configuration of the election on etcd.
+type ETCDConfig struct {
+	// Prefix is the key prefix of the election.
+	Prefix string
+	// TTL is how long the leadership outlives a replica that stopped renewing it.
+	TTL time.Duration
+	// URL is the URL the other replicas reach this one at.
+	URL string
+	// RetryInterval is the wait before campaigning again after an error.
+	RetryInterval time.Duration
+}
+
+type etcdLeaderElector struct {
+	etcdClient *client.Client
+	config     ETCDConfig
+	leader     atomic.Bool
+}
+
+// ETCD elects the leader with etcd leases, the leader keeps the leadership while its
+// lease is renewed.
+func ETCD(etcdClient *client.Client, cfg ETCDConfig) entity.LeaderElector {
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = time.Second
+	}
+	return &etcdLeaderElector{
+		etcdClient: etcdClient,
+		config:     cfg,
+	}
+}
+
+func (e *etcdLeaderElector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	for {
+		err := e.campaign(ctx, lead)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			slog.Warn("leader election failed", logging.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.config.RetryInterval):
+		}
+	}
+}
+
+// campaign waits to be elected, then leads until the lease of the session is lost or
+// the context is done, resigning in the latter case so another replica takes over
+// without waiting for the lease to expire.
+func (e *etcdLeaderElector) campaign(ctx context.Context, lead func(ctx context.Context)) error {
+	ttl := int(e.config.TTL.Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+	session, err := concurrency.NewSession(e.etcdClient, concurrency.WithTTL(ttl), concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, e.config.Prefix)
+	if err := election.Campaign(ctx, e.config.URL); err != nil {
+		return err
+	}
+
+	e.leader.Store(true)
+	slog.Info("elected leader", "url", e.config.URL)
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	select {
+	case <-session.Done():
+		err = errors.New("leadership lost")
+	case <-ctx.Done():
+		resignCtx, cancelResign := context.WithTimeout(context.Background(), e.config.RetryInterval)
+		election.Resign(resignCtx)
+		cancelResign()
+	}
+	e.leader.Store(false)
+	cancel()
+	<-done
+	slog.Info("stopped leading", "url", e.config.URL)
+	return err
+}
+
+func (e *etcdLeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Leader returns the value of the oldest candidate, which is the leader.
+func (e *etcdLeaderElector) Leader(ctx context.Context) (string, error) {
+	res, err := e.etcdClient.Get(ctx, e.config.Prefix+"/", client.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Kvs) == 0 {
+		return "", entity.ErrNoLeader
+	}
+	return string(res.Kvs[0].Value), nil
+}
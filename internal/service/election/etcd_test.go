@@ -0,0 +1,107 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/etcdtest"
+	client "go.etcd.io/etcd/client/v3"
+)
+
+type replica struct {
+	url       string
+	client    *client.Client
+	elector   entity.LeaderElector
+	elected   chan struct{}
+	deposed   chan struct{}
+	cancelRun context.CancelFunc
+}
+
+func startReplica(t *testing.T, endpoint string, replicaURL string) *replica {
+	t.Helper()
+	r := &replica{
+		url:     replicaURL,
+		client:  etcdtest.Client(t, endpoint),
+		elected: make(chan struct{}, 1),
+		deposed: make(chan struct{}, 1),
+	}
+	r.elector = ETCD(r.client, ETCDConfig{
+		Prefix:        "/statemanager/leader",
+		TTL:           time.Second,
+		URL:           replicaURL,
+		RetryInterval: 100 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancelRun = cancel
+	t.Cleanup(cancel)
+	go r.elector.Run(ctx, func(ctx context.Context) {
+		r.elected <- struct{}{}
+		<-ctx.Done()
+		r.deposed <- struct{}{}
+	})
+	return r
+}
+
+func TestETCD(t *testing.T) {
+	endpoint := etcdtest.Start(t)
+	first := startReplica(t, endpoint, "http://first:8002")
+	select {
+	case <-first.elected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the first replica to be elected")
+	}
+	second := startReplica(t, endpoint, "http://second:8002")
+
+	t.Run("it should have a single leader", func(t *testing.T) {
+		if !first.elector.IsLeader() || second.elector.IsLeader() {
+			t.Errorf("expected only the first replica to lead, got %v and %v\n", first.elector.IsLeader(), second.elector.IsLeader())
+		}
+		leader, err := second.elector.Leader(context.Background())
+		if err != nil || leader != first.url {
+			t.Errorf("expected leader %q, got %q (%v)\n", first.url, leader, err)
+		}
+	})
+
+	t.Run("it should fail over when the leader dies", func(t *testing.T) {
+		// Closing the client stops renewing the lease without resigning, as a crash
+		// would.
+		first.client.Close()
+
+		select {
+		case <-second.elected:
+		case <-time.After(15 * time.Second):
+			t.Fatal("expected the second replica to be elected")
+		}
+		select {
+		case <-first.deposed:
+		case <-time.After(5 * time.Second):
+			t.Error("expected the first replica to stop leading")
+		}
+		if first.elector.IsLeader() {
+			t.Error("expected the first replica not to lead")
+		}
+
+		leader, err := second.elector.Leader(context.Background())
+		if err != nil || leader != second.url {
+			t.Errorf("expected leader %q, got %q (%v)\n", second.url, leader, err)
+		}
+	})
+
+	t.Run("it should report no leader once the leader resigns", func(t *testing.T) {
+		second.cancelRun()
+		select {
+		case <-second.deposed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the second replica to stop leading")
+		}
+
+		observer := ETCD(etcdtest.Client(t, endpoint), ETCDConfig{Prefix: "/statemanager/leader"})
+		if _, err := observer.Leader(context.Background()); !errors.Is(err, entity.ErrNoLeader) {
+			t.Errorf("expected ErrNoLeader, got %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package election
+
+import (
+	"context"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type singleLeaderElector struct {
+	url string
+}
+
+// Single is the elector of a State Manager running a single replica, which is always
+// the leader.
+func Single(url string) entity.LeaderElector {
+	return &singleLeaderElector{url: url}
+}
+
+func (e *singleLeaderElector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	lead(ctx)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (e *singleLeaderElector) IsLeader() bool {
+	return true
+}
+
+func (e *singleLeaderElector) Leader(ctx context.Context) (string, error) {
+	return e.url, nil
+}
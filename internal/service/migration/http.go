@@ -0,0 +1,176 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
+)
+
+// Paths of the migration steps in the Interceptor admin API.
+const (
+	CHECKPOINT_PATH = "/checkpoint"
+	RESTORE_PATH    = "/restore"
+	SWITCH_PATH     = "/switch"
+)
+
+// HTTPConfig is the configuration of the calls to the Interceptor admin API.
+type HTTPConfig struct {
+	// Timeout is the maximum duration of each step, the transfer of the checkpoint
+	// image and the replay of the requests before a switch included.
+	Timeout time.Duration
+	// TLSConfig is the TLS configuration used to reach the Interceptors, with the
+	// certificate identifying the State Manager.
+	TLSConfig *tls.Config
+	// TokenFile is a file with the bearer token sent on every request, read again on
+	// each request so rotated tokens are picked up.
+	TokenFile string
+}
+
+type httpMigrationService struct {
+	client    *http.Client
+	timeout   time.Duration
+	tokenFile string
+}
+
+// HTTP migrates containers through the admin API of their Interceptors.
+func HTTP(cfg HTTPConfig) *httpMigrationService {
+	transport := http.DefaultTransport
+	if cfg.TLSConfig != nil {
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = cfg.TLSConfig
+		transport = tlsTransport
+	}
+	return &httpMigrationService{
+		client:    &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		timeout:   cfg.Timeout,
+		tokenFile: cfg.TokenFile,
+	}
+}
+
+func imageURL(adminURL string, checkpointHash string) string {
+	return strings.TrimSuffix(adminURL, "/") + "/checkpoints/" + url.PathEscape(checkpointHash) + "/image"
+}
+
+// defaultRetryAfter is the delay before asking again for a checkpoint when the
+// Interceptor is busy with another one and sends no Retry-After.
+const defaultRetryAfter = time.Second
+
+// Checkpoint has the Interceptor checkpoint its container, waiting for the checkpoint
+// it is already making, like a scheduled one, within the timeout of the step.
+func (s *httpMigrationService) Checkpoint(ctx context.Context, adminURL string) (*entity.CheckpointResult, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	for {
+		res, err := s.do(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+CHECKPOINT_PATH, nil)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusConflict {
+			return decodeCheckpoint(res)
+		}
+		res.Body.Close()
+
+		retryAfter := defaultRetryAfter
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("checkpoint still in progress: %w", ctx.Err())
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+func decodeCheckpoint(res *http.Response) (*entity.CheckpointResult, error) {
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("checkpoint failed with status %d", res.StatusCode)
+	}
+
+	var result entity.CheckpointResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Transfer streams the image from the source to the target, without storing it in
+// the State Manager.
+func (s *httpMigrationService) Transfer(ctx context.Context, sourceAdminURL string, targetAdminURL string, checkpointHash string) error {
+	source, err := s.do(ctx, http.MethodGet, imageURL(sourceAdminURL, checkpointHash), nil)
+	if err != nil {
+		return err
+	}
+	defer source.Body.Close()
+	if source.StatusCode != http.StatusOK {
+		return fmt.Errorf("export of checkpoint %q failed with status %d", checkpointHash, source.StatusCode)
+	}
+
+	target, err := s.do(ctx, http.MethodPut, imageURL(targetAdminURL, checkpointHash), source.Body)
+	if err != nil {
+		return err
+	}
+	defer target.Body.Close()
+	if target.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("import of checkpoint %q failed with status %d", checkpointHash, target.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpMigrationService) Restore(ctx context.Context, adminURL string, checkpointHash string) error {
+	query := url.Values{"hash": {checkpointHash}}
+	res, err := s.do(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+RESTORE_PATH+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("restore of checkpoint %q failed with status %d", checkpointHash, res.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpMigrationService) Switch(ctx context.Context, adminURL string, fromVersion int, upstream string) error {
+	query := url.Values{"version": {strconv.Itoa(fromVersion)}, "upstream": {upstream}}
+	res, err := s.do(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+SWITCH_PATH+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("switch to %s from version %d failed with status %d", upstream, fromVersion, res.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpMigrationService) do(ctx context.Context, method string, requestURL string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	tracing.Inject(ctx, req.Header)
+	if s.tokenFile != "" {
+		token, err := os.ReadFile(s.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	}
+	return s.client.Do(req)
+}
@@ -0,0 +1,46 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+func TestCheckpoint(t *testing.T) {
+	busy := 1
+	interceptor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if busy > 0 {
+			busy--
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(entity.CheckpointResult{CheckpointHash: "hash"})
+	}))
+	defer interceptor.Close()
+	service := HTTP(HTTPConfig{Timeout: 5 * time.Second})
+
+	t.Run("it should wait for the checkpoint in progress", func(t *testing.T) {
+		result, err := service.Checkpoint(context.Background(), interceptor.URL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if result.CheckpointHash != "hash" {
+			t.Errorf("expected the checkpoint made once the other one is done, got %+v\n", result)
+		}
+	})
+
+	t.Run("it should give up when the context is done", func(t *testing.T) {
+		busy = 10
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := service.Checkpoint(ctx, interceptor.URL); err == nil {
+			t.Error("expected an error while the checkpoint is in progress")
+		}
+	})
+}
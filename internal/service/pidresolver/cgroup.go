@@ -0,0 +1,51 @@
+package pidresolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// CGroupConfig configuration to resolve the PID by cgroup.
+type CGroupConfig struct {
+	// ProcRoot is the mount point of the proc filesystem, DefaultProcRoot when empty.
+	ProcRoot string
+	// Path is a cgroup path, or part of it like the container runtime ID, the process
+	// must belong to.
+	Path string
+}
+
+type cgroupPIDResolver struct {
+	procRoot string
+	path     string
+}
+
+// CGroup resolves the PID of the oldest process in the given cgroup.
+func CGroup(cfg CGroupConfig) entity.PIDResolver {
+	procRoot := cfg.ProcRoot
+	if procRoot == "" {
+		procRoot = DefaultProcRoot
+	}
+	return &cgroupPIDResolver{procRoot: procRoot, path: cfg.Path}
+}
+
+func (r *cgroupPIDResolver) ResolvePID(container *entity.Container) (int32, error) {
+	pid, found, err := findProcess(r.procRoot, "cgroup", func(cgroups string) bool {
+		// Each line is hierarchy-ID:controllers:path.
+		for _, line := range strings.Split(cgroups, "\n") {
+			parts := strings.SplitN(line, ":", 3)
+			if len(parts) == 3 && strings.Contains(parts[2], r.path) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("no process in cgroup %q found for container %q", r.path, container.Name)
+	}
+	return pid, nil
+}
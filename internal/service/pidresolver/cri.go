@@ -0,0 +1,89 @@
+package pidresolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// DefaultCRISocket is the containerd CRI socket.
+const DefaultCRISocket = "unix:///run/containerd/containerd.sock"
+
+// CRIConfig configuration to resolve the PID through the CRI runtime.
+type CRIConfig struct {
+	// Socket is the CRI runtime endpoint, DefaultCRISocket when empty.
+	Socket string
+	// ContainerID is the runtime ID of the container. When empty the container is
+	// looked up by ContainerName.
+	ContainerID string
+	// ContainerName is the name of the container in the pod.
+	ContainerName string
+	// Crictl is the path of the crictl binary, looked up in PATH when empty.
+	Crictl string
+}
+
+type criPIDResolver struct {
+	cfg CRIConfig
+	run func(name string, args ...string) ([]byte, error)
+}
+
+// CRI resolves the PID by inspecting the container in the CRI runtime with crictl.
+func CRI(cfg CRIConfig) entity.PIDResolver {
+	if cfg.Socket == "" {
+		cfg.Socket = DefaultCRISocket
+	}
+	if cfg.Crictl == "" {
+		cfg.Crictl = "crictl"
+	}
+	return &criPIDResolver{
+		cfg: cfg,
+		run: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).Output()
+		},
+	}
+}
+
+func (r *criPIDResolver) ResolvePID(container *entity.Container) (int32, error) {
+	containerID := r.cfg.ContainerID
+	if containerID == "" {
+		output, err := r.crictl("ps", "--state", "running", "--name", "^"+r.cfg.ContainerName+"$", "--quiet")
+		if err != nil {
+			return 0, err
+		}
+		ids := strings.Fields(string(output))
+		if len(ids) == 0 {
+			return 0, fmt.Errorf("no running container named %q found in CRI runtime", r.cfg.ContainerName)
+		}
+		containerID = ids[0]
+	}
+
+	output, err := r.crictl("inspect", "--output", "json", containerID)
+	if err != nil {
+		return 0, err
+	}
+
+	var inspect struct {
+		Info struct {
+			PID int32 `json:"pid"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return 0, err
+	}
+	if inspect.Info.PID <= 0 {
+		return 0, fmt.Errorf("container %q has no running process", containerID)
+	}
+	return inspect.Info.PID, nil
+}
+
+func (r *criPIDResolver) crictl(args ...string) ([]byte, error) {
+	args = append([]string{"--runtime-endpoint", r.cfg.Socket}, args...)
+	output, err := r.run(r.cfg.Crictl, args...)
+	if err != nil {
+		return nil, fmt.Errorf("crictl %s: %w", strings.Join(args, " "), err)
+	}
+	return output, nil
+}
@@ -0,0 +1,106 @@
+package pidresolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// fakeProc creates a fake /proc tree with the given processes files by PID.
+func fakeProc(t *testing.T, processes map[string]map[string]string) string {
+	procRoot := t.TempDir()
+	for pid, files := range processes {
+		dir := filepath.Join(procRoot, pid)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Non PID entries of /proc must be ignored.
+	os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("1 1"), 0o644)
+	return procRoot
+}
+
+func TestProcessName(t *testing.T) {
+	container := &entity.Container{Name: "test"}
+	procRoot := fakeProc(t, map[string]map[string]string{
+		"1":   {"comm": "pause\n", "cmdline": "/pause\x00"},
+		"42":  {"comm": "server\n", "cmdline": "/app/server\x00--port\x008000\x00"},
+		"57":  {"comm": "server\n", "cmdline": "/app/server\x00--worker\x00"},
+		"120": {"comm": "a-very-long-pro\n", "cmdline": "/bin/a-very-long-process-name\x00"},
+	})
+
+	t.Run("it should resolve the oldest process with the name", func(t *testing.T) {
+		pid, err := ProcessName(ProcessNameConfig{ProcRoot: procRoot, Name: "server"}).ResolvePID(container)
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if pid != 42 {
+			t.Errorf("expected PID 42, got %d\n", pid)
+		}
+	})
+
+	t.Run("it should match names truncated in comm by the command line", func(t *testing.T) {
+		pid, err := ProcessName(ProcessNameConfig{ProcRoot: procRoot, Name: "a-very-long-process-name"}).ResolvePID(container)
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if pid != 120 {
+			t.Errorf("expected PID 120, got %d\n", pid)
+		}
+	})
+
+	t.Run("it should fail when no process matches", func(t *testing.T) {
+		_, err := ProcessName(ProcessNameConfig{ProcRoot: procRoot, Name: "unknown"}).ResolvePID(container)
+		if err == nil {
+			t.Error("expected error resolving unknown process")
+		}
+	})
+}
+
+func TestCGroup(t *testing.T) {
+	container := &entity.Container{Name: "test"}
+	procRoot := fakeProc(t, map[string]map[string]string{
+		"1":  {"cgroup": "0::/kubepods/pod1/aaaa\n"},
+		"10": {"cgroup": "0::/kubepods/pod1/bbbb\n"},
+		"11": {"cgroup": "12:memory:/kubepods/pod1/bbbb\n0::/kubepods/pod1/bbbb\n"},
+	})
+
+	pid, err := CGroup(CGroupConfig{ProcRoot: procRoot, Path: "bbbb"}).ResolvePID(container)
+	if err != nil {
+		t.Fatalf("expected error nil, received %v\n", err)
+	}
+	if pid != 10 {
+		t.Errorf("expected PID 10, got %d\n", pid)
+	}
+}
+
+func TestCRI(t *testing.T) {
+	resolver := CRI(CRIConfig{ContainerName: "test"}).(*criPIDResolver)
+	resolver.run = func(name string, args ...string) ([]byte, error) {
+		command := strings.Join(args, " ")
+		switch {
+		case strings.Contains(command, " ps "):
+			return []byte("abc123\n"), nil
+		case strings.HasSuffix(command, "inspect --output json abc123"):
+			return []byte(`{"info": {"pid": 4242}}`), nil
+		}
+		t.Fatalf("unexpected command %q", command)
+		return nil, nil
+	}
+
+	pid, err := resolver.ResolvePID(&entity.Container{Name: "test"})
+	if err != nil {
+		t.Fatalf("expected error nil, received %v\n", err)
+	}
+	if pid != 4242 {
+		t.Errorf("expected PID 4242, got %d\n", pid)
+	}
+}
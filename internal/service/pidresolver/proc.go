@@ -0,0 +1,45 @@
+package pidresolver
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultProcRoot is the mount point of the proc filesystem.
+const DefaultProcRoot = "/proc"
+
+// findProcess scans the processes under procRoot and returns the lowest PID whose
+// file, like "comm" or "cgroup", matches. The lowest PID is the oldest process in the
+// namespace, the container main process rather than any of its children.
+func findProcess(procRoot string, file string, match func(content string) bool) (int32, bool, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	for _, pid := range pids {
+		content, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), file))
+		if err != nil {
+			// The process may have exited while scanning.
+			continue
+		}
+		if match(strings.TrimSpace(string(content))) {
+			return int32(pid), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,54 @@
+package pidresolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// ProcessNameConfig configuration to resolve the PID by process name.
+type ProcessNameConfig struct {
+	// ProcRoot is the mount point of the proc filesystem, DefaultProcRoot when empty.
+	ProcRoot string
+	// Name is the process name, as in /proc/<pid>/comm, or the executable path at the
+	// start of its command line.
+	Name string
+}
+
+type processNamePIDResolver struct {
+	procRoot string
+	name     string
+}
+
+// ProcessName resolves the PID of the oldest process with the given name. It requires
+// the Interceptor to share the PID namespace of the monitored container.
+func ProcessName(cfg ProcessNameConfig) entity.PIDResolver {
+	procRoot := cfg.ProcRoot
+	if procRoot == "" {
+		procRoot = DefaultProcRoot
+	}
+	return &processNamePIDResolver{procRoot: procRoot, name: cfg.Name}
+}
+
+func (r *processNamePIDResolver) ResolvePID(container *entity.Container) (int32, error) {
+	pid, found, err := findProcess(r.procRoot, "comm", func(comm string) bool {
+		return comm == r.name
+	})
+	if err != nil || found {
+		return pid, err
+	}
+
+	// comm is truncated to 15 characters, fallback to the command line.
+	pid, found, err = findProcess(r.procRoot, "cmdline", func(cmdline string) bool {
+		executable := strings.SplitN(cmdline, "\x00", 2)[0]
+		return executable == r.name || strings.HasSuffix(executable, "/"+r.name)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("no process named %q found for container %q", r.name, container.Name)
+	}
+	return pid, nil
+}
@@ -0,0 +1,23 @@
+package pidresolver
+
+import (
+	"fmt"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+type staticPIDResolver struct {
+	pid int32
+}
+
+// Static always resolves the given PID.
+func Static(pid int32) entity.PIDResolver {
+	return &staticPIDResolver{pid: pid}
+}
+
+func (r *staticPIDResolver) ResolvePID(container *entity.Container) (int32, error) {
+	if r.pid <= 0 {
+		return 0, fmt.Errorf("no PID configured for container %q", container.Name)
+	}
+	return r.pid, nil
+}
@@ -0,0 +1,212 @@
+package recording
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/google/uuid"
+)
+
+// The subset of HTTP Archive 1.2 written for the intercepted requests. The fields
+// starting with an underscore are custom ones keeping what HAR has no field for.
+type harArchive struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ID              string      `json:"_id"`
+	Version         int         `json:"_version"`
+	Solved          bool        `json:"_solved"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Encoding  string `json:"_encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func writeHAR(w io.Writer, requests []*entity.InterceptedRequest) error {
+	archive := harArchive{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "interceptor", Version: "1"},
+		Entries: []harEntry{},
+	}}
+	for _, req := range requests {
+		// The responses are not recorded, the time of an entry is the one the request
+		// took to be solved.
+		var elapsed float64
+		if req.SolvedAt != nil && req.SolvedAt.After(req.ReceivedAt) {
+			elapsed = float64(req.SolvedAt.Sub(req.ReceivedAt)) / float64(time.Millisecond)
+		}
+		entry := harEntry{
+			StartedDateTime: req.ReceivedAt,
+			Time:            elapsed,
+			Request: harRequest{
+				Method:      req.Request.Method,
+				URL:         requestURL(req.Request),
+				HTTPVersion: req.Request.Proto,
+				Cookies:     []harNameValue{},
+				Headers:     nameValues(req.Request.Header),
+				QueryString: nameValues(req.Request.URL.Query()),
+				HeadersSize: -1,
+				BodySize:    len(req.Body),
+			},
+			Response: harResponse{
+				Cookies:     []harNameValue{},
+				Headers:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Timings: harTimings{Wait: elapsed},
+			ID:      req.ID,
+			Version: req.Version,
+			Solved:  req.Solved,
+		}
+		for _, cookie := range req.Request.Cookies() {
+			entry.Request.Cookies = append(entry.Request.Cookies, harNameValue{Name: cookie.Name, Value: cookie.Value})
+		}
+		if len(req.Body) > 0 {
+			postData := &harPostData{MimeType: req.Request.Header.Get("Content-Type"), Text: string(req.Body), Truncated: req.BodyTruncated}
+			if !utf8.Valid(req.Body) {
+				postData.Text, postData.Encoding = base64.StdEncoding.EncodeToString(req.Body), "base64"
+			}
+			entry.Request.PostData = postData
+		}
+		archive.Log.Entries = append(archive.Log.Entries, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(archive)
+}
+
+// nameValues lists the values by name, in the order of the names.
+func nameValues(values map[string][]string) []harNameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []harNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+// readHAR reads the entries of an HTTP Archive, which may come from another tool. The
+// entries without a version are numbered in their order.
+func readHAR(r io.Reader) ([]*entity.InterceptedRequest, error) {
+	var archive harArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, err
+	}
+
+	var requests []*entity.InterceptedRequest
+	for i, entry := range archive.Log.Entries {
+		header := http.Header{}
+		for _, h := range entry.Request.Headers {
+			header.Add(h.Name, h.Value)
+		}
+		req, err := newRequest(entry.Request.Method, entry.Request.URL, header)
+		if err != nil {
+			return nil, err
+		}
+
+		interceptedRequest := &entity.InterceptedRequest{
+			ID:         entry.ID,
+			ReceivedAt: entry.StartedDateTime,
+			Request:    req,
+			Solved:     entry.Solved,
+			Version:    entry.Version,
+		}
+		if interceptedRequest.ID == "" {
+			interceptedRequest.ID = uuid.NewString()
+		}
+		if interceptedRequest.Version == 0 {
+			interceptedRequest.Version = i + 1
+		}
+		if entry.Time > 0 {
+			solvedAt := entry.StartedDateTime.Add(time.Duration(entry.Time * float64(time.Millisecond)))
+			interceptedRequest.SolvedAt = &solvedAt
+		}
+		if postData := entry.Request.PostData; postData != nil {
+			interceptedRequest.Body = []byte(postData.Text)
+			interceptedRequest.BodyTruncated = postData.Truncated
+			if postData.Encoding == "base64" {
+				if interceptedRequest.Body, err = base64.StdEncoding.DecodeString(postData.Text); err != nil {
+					return nil, err
+				}
+			}
+		}
+		requests = append(requests, interceptedRequest)
+	}
+	return requests, nil
+}
@@ -0,0 +1,73 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// record is an intercepted request in a JSON Lines recording.
+type record struct {
+	ID            string      `json:"id"`
+	Version       int         `json:"version"`
+	ReceivedAt    time.Time   `json:"received_at"`
+	SolvedAt      *time.Time  `json:"solved_at,omitempty"`
+	Solved        bool        `json:"solved"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+func writeJSONL(w io.Writer, requests []*entity.InterceptedRequest) error {
+	encoder := json.NewEncoder(w)
+	for _, req := range requests {
+		err := encoder.Encode(record{
+			ID:            req.ID,
+			Version:       req.Version,
+			ReceivedAt:    req.ReceivedAt,
+			SolvedAt:      req.SolvedAt,
+			Solved:        req.Solved,
+			Method:        req.Request.Method,
+			URL:           requestURL(req.Request),
+			Header:        req.Request.Header,
+			Body:          req.Body,
+			BodyTruncated: req.BodyTruncated,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJSONL(r io.Reader) ([]*entity.InterceptedRequest, error) {
+	var requests []*entity.InterceptedRequest
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for decoder.More() {
+		var rec record
+		if err := decoder.Decode(&rec); err != nil {
+			return nil, err
+		}
+		req, err := newRequest(rec.Method, rec.URL, rec.Header)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, &entity.InterceptedRequest{
+			ID:            rec.ID,
+			SolvedAt:      rec.SolvedAt,
+			ReceivedAt:    rec.ReceivedAt,
+			Request:       req,
+			Solved:        rec.Solved,
+			Version:       rec.Version,
+			Body:          rec.Body,
+			BodyTruncated: rec.BodyTruncated,
+		})
+	}
+	return requests, nil
+}
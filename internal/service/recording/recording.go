@@ -0,0 +1,99 @@
+package recording
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+// Formats of the recordings.
+const (
+	// FormatJSONL writes an intercepted request per line as JSON.
+	FormatJSONL = "jsonl"
+	// FormatHAR writes the intercepted requests as the entries of an HTTP Archive 1.2,
+	// without their responses which are not recorded.
+	FormatHAR = "har"
+)
+
+// FormatOf returns the format of a recording file from its extension, JSON Lines
+// unless it is ".har".
+func FormatOf(filename string) string {
+	if strings.HasSuffix(strings.ToLower(filename), ".har") {
+		return FormatHAR
+	}
+	return FormatJSONL
+}
+
+// Export writes the requests of the repository since the version in the format.
+func Export(w io.Writer, format string, repository entity.InterceptedRequestRepository, fromVersion int) (int, error) {
+	requests, err := repository.GetAllFromLastVersion(fromVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	switch format {
+	case FormatJSONL:
+		err = writeJSONL(w, requests)
+	case FormatHAR:
+		err = writeHAR(w, requests)
+	default:
+		err = fmt.Errorf("unknown recording format %q", format)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(requests), nil
+}
+
+// Import reads the requests of a recording in the format, in version order.
+func Import(r io.Reader, format string) ([]*entity.InterceptedRequest, error) {
+	var requests []*entity.InterceptedRequest
+	var err error
+	switch format {
+	case FormatJSONL:
+		requests, err = readJSONL(r)
+	case FormatHAR:
+		requests, err = readHAR(r)
+	default:
+		err = fmt.Errorf("unknown recording format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(requests, func(a, b *entity.InterceptedRequest) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return requests, nil
+}
+
+// newRequest rebuilds an intercepted HTTP request from its recorded fields.
+func newRequest(method string, url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	return req, nil
+}
+
+// requestURL returns the URL of the intercepted request, absolute when the Host of
+// the request is known.
+func requestURL(req *http.Request) string {
+	if req.URL.Host != "" || req.Host == "" {
+		return req.URL.String()
+	}
+	url := *req.URL
+	url.Host = req.Host
+	if url.Scheme == "" {
+		url.Scheme = "http"
+	}
+	return url.String()
+}
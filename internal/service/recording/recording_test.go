@@ -0,0 +1,112 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptedrequest"
+	_ "modernc.org/sqlite"
+)
+
+func TestRecording(t *testing.T) {
+	t.Run("in-memory", func(t *testing.T) {
+		testRecording(t, interceptedrequest.InMemory())
+	})
+
+	// The event log is exported by another process than the Interceptor, from its
+	// database.
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "interceptor.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err := interceptedrequest.MigrateSQL(context.Background(), db); err != nil {
+			t.Fatal(err)
+		}
+		testRecording(t, interceptedrequest.SQL(db))
+	})
+}
+
+func testRecording(t *testing.T, repository entity.InterceptedRequestRepository) {
+	receivedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	solvedAt := receivedAt.Add(time.Second + 150*time.Millisecond)
+	for _, req := range []*entity.InterceptedRequest{
+		{ID: "first", Version: 1, ReceivedAt: receivedAt, Request: httptest.NewRequest(http.MethodGet, "http://app/orders?page=2", nil)},
+		{ID: "second", Version: 2, ReceivedAt: receivedAt.Add(time.Second), SolvedAt: &solvedAt, Solved: true, Request: httptest.NewRequest(http.MethodPost, "http://app/orders", nil), Body: []byte(`{"id":1}`)},
+		{ID: "third", Version: 3, ReceivedAt: receivedAt.Add(2 * time.Second), Request: httptest.NewRequest(http.MethodPut, "http://app/images/1", nil), Body: []byte{0xff, 0x00, 0xfe}, BodyTruncated: true},
+	} {
+		req.Request.Header.Set("X-Tenant", "acme")
+		if err := repository.Save(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{FormatJSONL, FormatHAR} {
+		t.Run("it should read back the requests exported as "+format, func(t *testing.T) {
+			var buffer bytes.Buffer
+			exported, err := Export(&buffer, format, repository, 2)
+			if err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+			if exported != 2 {
+				t.Errorf("expected 2 requests since version 2, got %d\n", exported)
+			}
+
+			requests, err := Import(&buffer, format)
+			if err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+			if len(requests) != 2 {
+				t.Fatalf("expected 2 requests, got %d\n", len(requests))
+			}
+			second, third := requests[0], requests[1]
+			if second.ID != "second" || second.Version != 2 || second.Request.Method != http.MethodPost || second.Request.URL.Path != "/orders" {
+				t.Errorf("expected the second request, got %+v\n", second)
+			}
+			if string(second.Body) != `{"id":1}` || second.Request.Header.Get("X-Tenant") != "acme" {
+				t.Errorf("expected the body and headers of the second request, got %q and %v\n", second.Body, second.Request.Header)
+			}
+			if !second.ReceivedAt.Equal(receivedAt.Add(time.Second)) || second.SolvedAt == nil {
+				t.Errorf("expected the timing of the second request, got %v and %v\n", second.ReceivedAt, second.SolvedAt)
+			}
+			if !bytes.Equal(third.Body, []byte{0xff, 0x00, 0xfe}) || !third.BodyTruncated {
+				t.Errorf("expected the binary truncated body of the third request, got %v\n", third.Body)
+			}
+		})
+	}
+
+	t.Run("it should write an HTTP Archive 1.2", func(t *testing.T) {
+		var buffer bytes.Buffer
+		if _, err := Export(&buffer, FormatHAR, repository, 1); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		var archive struct {
+			Log struct {
+				Version string `json:"version"`
+				Entries []struct {
+					Request struct {
+						QueryString []harNameValue `json:"queryString"`
+					} `json:"request"`
+				} `json:"entries"`
+			} `json:"log"`
+		}
+		if err := json.Unmarshal(buffer.Bytes(), &archive); err != nil {
+			t.Fatalf("expected valid JSON, got %v\n", err)
+		}
+		if archive.Log.Version != "1.2" || len(archive.Log.Entries) != 3 {
+			t.Errorf("expected 3 entries of version 1.2, got %+v\n", archive.Log)
+		}
+		if query := archive.Log.Entries[0].Request.QueryString; len(query) != 1 || query[0].Value != "2" {
+			t.Errorf("expected the query string of the first request, got %v\n", query)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package reprojection
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
+)
+
+// REPROJECT_PATH is the path of the reprojection in the Interceptor admin API.
+const REPROJECT_PATH = "/reproject"
+
+// HOLD_PATH is the path of the holding of the requests in the Interceptor admin API.
+const HOLD_PATH = "/hold"
+
+// HTTPConfig is the configuration of the calls to the Interceptor admin API.
+type HTTPConfig struct {
+	// Timeout is the maximum duration of a reprojection, which replays every request
+	// since the restored checkpoint.
+	Timeout time.Duration
+	// TLSConfig is the TLS configuration used to reach the Interceptors, with the
+	// certificate identifying the State Manager.
+	TLSConfig *tls.Config
+	// TokenFile is a file with the bearer token sent on every request, read again on
+	// each request so rotated tokens are picked up.
+	TokenFile string
+}
+
+type httpReprojectionService struct {
+	client    *http.Client
+	tokenFile string
+}
+
+// HTTP reprojects through the admin API of the Interceptors.
+func HTTP(cfg HTTPConfig) *httpReprojectionService {
+	transport := http.DefaultTransport
+	if cfg.TLSConfig != nil {
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = cfg.TLSConfig
+		transport = tlsTransport
+	}
+	return &httpReprojectionService{
+		client:    &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		tokenFile: cfg.TokenFile,
+	}
+}
+
+func (s *httpReprojectionService) Reproject(ctx context.Context, adminURL string, fromVersion int, appliedVersion int, restore string) (*entity.ReprojectionReport, error) {
+	query := url.Values{"version": {strconv.Itoa(fromVersion)}, "applied": {strconv.Itoa(appliedVersion)}, "restore": {restore}}
+	res, err := s.do(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+REPROJECT_PATH+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Interceptors older than the reports answer without a body.
+	var report *entity.ReprojectionReport
+	if res.Header.Get("Content-Type") == "application/json" {
+		report = &entity.ReprojectionReport{}
+		if err := json.NewDecoder(res.Body).Decode(report); err != nil {
+			report = nil
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("reprojection from version %d failed with status %d", fromVersion, res.StatusCode)
+	}
+	return report, nil
+}
+
+func (s *httpReprojectionService) Hold(ctx context.Context, adminURL string) error {
+	res, err := s.do(ctx, http.MethodPost, strings.TrimSuffix(adminURL, "/")+HOLD_PATH)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("hold failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpReprojectionService) Release(ctx context.Context, adminURL string) error {
+	res, err := s.do(ctx, http.MethodDelete, strings.TrimSuffix(adminURL, "/")+HOLD_PATH)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("release failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpReprojectionService) do(ctx context.Context, method string, requestURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	tracing.Inject(ctx, req.Header)
+	if s.tokenFile != "" {
+		token, err := os.ReadFile(s.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	}
+	return s.client.Do(req)
+}
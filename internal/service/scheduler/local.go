@@ -1,23 +1,168 @@
 package scheduler
 
 import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
 	"time"
 
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
 )
 
-type localScheduler struct{}
+const (
+	// evaluationInterval is how often time based policies are evaluated.
+	evaluationInterval = time.Second
+	// retryDelay is the wait before trying again after a failed checkpoint.
+	retryDelay = 30 * time.Second
+)
+
+type localScheduler struct {
+	mutex       sync.Mutex
+	policy      usecase.CheckpointPolicy
+	stats       usecase.CheckpointStats
+	lastFailure time.Time
+	now         func() time.Time
+	// wake nudges the scheduler to evaluate the policy after a request, it holds at
+	// most one pending nudge so bursts of requests coalesce.
+	wake chan struct{}
+}
 
-func Local() usecase.Scheduler {
-	return &localScheduler{}
+// Local creates a scheduler running checkpoints in this process following the
+// checkpoint policies of the configuration.
+func Local(cfg *interceptorConfig.Config) (usecase.Scheduler, error) {
+	policy, err := PolicyFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &localScheduler{
+		policy: policy,
+		stats:  usecase.CheckpointStats{LastCheckpoint: time.Now()},
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}, nil
 }
 
-func (s *localScheduler) ScheduleCheckpoint(usecase usecase.InterceptorUseCase, scheduleIn time.Duration) error {
-	ticker := time.NewTicker(scheduleIn)
-	go func(ticker *time.Ticker) {
-		for range ticker.C {
-			usecase.Checkpoint()
+// Run evaluates the policy every second and after every intercepted request, making
+// the checkpoints that are due one at a time, until the context is done.
+func (s *localScheduler) Run(ctx context.Context, uc usecase.InterceptorUseCase) error {
+	ticker := time.NewTicker(evaluationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-s.wake:
 		}
-	}(ticker)
+
+		if s.due() {
+			s.checkpoint(uc)
+		}
+	}
+}
+
+func (s *localScheduler) ObserveRequest(req *entity.InterceptedRequest) {
+	s.mutex.Lock()
+	s.stats.Requests++
+	s.stats.EventLogBytes += requestSize(req)
+	if isWrite(req) {
+		s.stats.WriteRequests++
+		s.stats.LastWrite = s.now()
+	}
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *localScheduler) UpdateConfig(cfg *interceptorConfig.Config) error {
+	policy, err := PolicyFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.policy = policy
 	return nil
 }
+
+func (s *localScheduler) NextRun() (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	next, planned := s.policy.Next(now, s.stats)
+	if planned && now.Sub(s.lastFailure) < retryDelay {
+		if retryAt := s.lastFailure.Add(retryDelay); retryAt.After(next) {
+			next = retryAt
+		}
+	}
+	return next, planned
+}
+
+func (s *localScheduler) due() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if now.Sub(s.lastFailure) < retryDelay {
+		return false
+	}
+	return s.policy.Due(now, s.stats)
+}
+
+func (s *localScheduler) checkpoint(uc usecase.InterceptorUseCase) {
+	s.mutex.Lock()
+	before := s.stats
+	startedAt := s.now()
+	s.mutex.Unlock()
+
+	err := uc.Checkpoint()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil {
+		slog.Warn("scheduled checkpoint failed, retrying later", "retry_delay", retryDelay, logging.Error(err))
+		s.lastFailure = s.now()
+		return
+	}
+	// Requests observed while the checkpoint was running may not be in it, they are
+	// kept towards the next one.
+	s.stats = usecase.CheckpointStats{
+		LastCheckpoint: startedAt,
+		Requests:       s.stats.Requests - before.Requests,
+		WriteRequests:  s.stats.WriteRequests - before.WriteRequests,
+		LastWrite:      s.stats.LastWrite,
+		EventLogBytes:  s.stats.EventLogBytes - before.EventLogBytes,
+	}
+}
+
+func isWrite(req *entity.InterceptedRequest) bool {
+	if req.Request == nil {
+		return false
+	}
+	switch req.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// requestSize estimates the size of the request in the event log.
+func requestSize(req *entity.InterceptedRequest) int64 {
+	size := int64(len(req.Body))
+	if req.Request != nil {
+		size += int64(len(req.Request.Method) + len(req.Request.URL.String()))
+		for key, values := range req.Request.Header {
+			for _, value := range values {
+				size += int64(len(key) + len(value))
+			}
+		}
+	}
+	return size
+}
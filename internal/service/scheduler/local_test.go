@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+)
+
+type countingUseCase struct {
+	usecase.InterceptorUseCase
+	checkpoints int32
+	running     int32
+	overlapped  bool
+}
+
+func (uc *countingUseCase) Checkpoint() error {
+	if atomic.AddInt32(&uc.running, 1) > 1 {
+		uc.overlapped = true
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(&uc.checkpoints, 1)
+	atomic.AddInt32(&uc.running, -1)
+	return nil
+}
+
+func TestPolicies(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name   string
+		policy usecase.CheckpointPolicy
+		stats  usecase.CheckpointStats
+		due    bool
+	}{
+		{"interval not elapsed", Interval(time.Minute), usecase.CheckpointStats{LastCheckpoint: now.Add(-time.Second)}, false},
+		{"interval elapsed", Interval(time.Minute), usecase.CheckpointStats{LastCheckpoint: now.Add(-time.Minute)}, true},
+		{"fewer requests", EveryNRequests(10), usecase.CheckpointStats{Requests: 9}, false},
+		{"enough requests", EveryNRequests(10), usecase.CheckpointStats{Requests: 10}, true},
+		{"burst still going", WriteBurst(5, time.Second), usecase.CheckpointStats{WriteRequests: 8, LastWrite: now}, false},
+		{"burst ended", WriteBurst(5, time.Second), usecase.CheckpointStats{WriteRequests: 8, LastWrite: now.Add(-2 * time.Second)}, true},
+		{"too few writes for a burst", WriteBurst(5, time.Second), usecase.CheckpointStats{WriteRequests: 2, LastWrite: now.Add(-2 * time.Second)}, false},
+		{"event log under budget", EventLogBytes(1024), usecase.CheckpointStats{EventLogBytes: 1000}, false},
+		{"event log over budget", EventLogBytes(1024), usecase.CheckpointStats{EventLogBytes: 2048}, true},
+		{"any policy due", Any(EveryNRequests(10), EventLogBytes(1024)), usecase.CheckpointStats{EventLogBytes: 2048}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if due := c.policy.Due(now, c.stats); due != c.due {
+				t.Errorf("expected due to be %v, got %v\n", c.due, due)
+			}
+		})
+	}
+}
+
+func TestLocalScheduler(t *testing.T) {
+	s, err := Local(&interceptorConfig.Config{CheckpointEveryRequests: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &countingUseCase{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx, uc)
+	}()
+
+	req := &entity.InterceptedRequest{Request: httptest.NewRequest(http.MethodPost, "/", nil)}
+	for i := 0; i < 6; i++ {
+		s.ObserveRequest(req)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Run("it should checkpoint when the policy is due", func(t *testing.T) {
+		if checkpoints := atomic.LoadInt32(&uc.checkpoints); checkpoints != 3 {
+			t.Errorf("expected 3 checkpoints, got %d\n", checkpoints)
+		}
+	})
+
+	t.Run("it should not overlap checkpoints", func(t *testing.T) {
+		if uc.overlapped {
+			t.Error("expected checkpoints to not overlap")
+		}
+	})
+
+	t.Run("it should stop when the context is canceled", func(t *testing.T) {
+		cancel()
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("expected context canceled error, got %v\n", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("expected scheduler to stop")
+		}
+	})
+}
+
+func TestCronAndBlackout(t *testing.T) {
+	// Every hour at minute 0, with a blackout between 02:00 and 04:00.
+	cronPolicy, err := Cron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := Blackout(cronPolicy, []interceptorConfig.BlackoutWindow{
+		{Start: "0 2 * * *", Duration: 2 * time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+
+	t.Run("it should be due once the cron time passed", func(t *testing.T) {
+		stats := usecase.CheckpointStats{LastCheckpoint: at(0, 30)}
+		if policy.Due(at(0, 59), stats) {
+			t.Error("expected checkpoint not due before 01:00")
+		}
+		if !policy.Due(at(1, 0), stats) {
+			t.Error("expected checkpoint due at 01:00")
+		}
+	})
+
+	t.Run("it should suppress checkpoints during the blackout", func(t *testing.T) {
+		stats := usecase.CheckpointStats{LastCheckpoint: at(1, 0)}
+		if policy.Due(at(3, 0), stats) {
+			t.Error("expected checkpoint suppressed at 03:00")
+		}
+		if !policy.Due(at(4, 0), stats) {
+			t.Error("expected checkpoint due once the blackout ends")
+		}
+	})
+
+	t.Run("it should plan the next run after the blackout", func(t *testing.T) {
+		next, planned := policy.Next(at(1, 30), usecase.CheckpointStats{LastCheckpoint: at(1, 0)})
+		if !planned || !next.Equal(at(4, 0)) {
+			t.Errorf("expected next run at %v, got %v\n", at(4, 0), next)
+		}
+	})
+
+	t.Run("it should not plan traffic driven policies", func(t *testing.T) {
+		if _, planned := EveryNRequests(10).Next(at(0, 0), usecase.CheckpointStats{}); planned {
+			t.Error("expected no planned run")
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"time"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/usecase"
+	"github.com/robfig/cron/v3"
+)
+
+// PolicyFromConfig creates the checkpoint policy of the configuration, due when any
+// of the configured policies is and outside of the blackout windows.
+func PolicyFromConfig(cfg *interceptorConfig.Config) (usecase.CheckpointPolicy, error) {
+	var policies []usecase.CheckpointPolicy
+	if cfg.CheckpointingInterval > 0 {
+		policies = append(policies, Interval(cfg.CheckpointingInterval))
+	}
+	if cfg.CheckpointEveryRequests > 0 {
+		policies = append(policies, EveryNRequests(cfg.CheckpointEveryRequests))
+	}
+	if cfg.CheckpointWriteBurstRequests > 0 {
+		policies = append(policies, WriteBurst(cfg.CheckpointWriteBurstRequests, cfg.CheckpointWriteBurstQuietPeriod))
+	}
+	if cfg.CheckpointEventLogBudgetBytes > 0 {
+		policies = append(policies, EventLogBytes(cfg.CheckpointEventLogBudgetBytes))
+	}
+	if cfg.CheckpointCron != "" {
+		policy, err := Cron(cfg.CheckpointCron)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	policy := Any(policies...)
+	if len(cfg.BlackoutWindows) == 0 {
+		return policy, nil
+	}
+	return Blackout(policy, cfg.BlackoutWindows)
+}
+
+type intervalPolicy struct {
+	interval time.Duration
+}
+
+// Interval makes a checkpoint every interval.
+func Interval(interval time.Duration) usecase.CheckpointPolicy {
+	return &intervalPolicy{interval: interval}
+}
+
+func (p *intervalPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	return now.Sub(stats.LastCheckpoint) >= p.interval
+}
+
+func (p *intervalPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	return stats.LastCheckpoint.Add(p.interval), true
+}
+
+type cronPolicy struct {
+	schedule cron.Schedule
+}
+
+// Cron makes a checkpoint at the times of the standard five fields cron expression.
+func Cron(expression string) (usecase.CheckpointPolicy, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &cronPolicy{schedule: schedule}, nil
+}
+
+func (p *cronPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	return !p.schedule.Next(stats.LastCheckpoint).After(now)
+}
+
+func (p *cronPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	return p.schedule.Next(stats.LastCheckpoint), true
+}
+
+type everyNRequestsPolicy struct {
+	requests int
+}
+
+// EveryNRequests makes a checkpoint once the given number of requests were
+// intercepted since the last one.
+func EveryNRequests(requests int) usecase.CheckpointPolicy {
+	return &everyNRequestsPolicy{requests: requests}
+}
+
+func (p *everyNRequestsPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	return stats.Requests >= p.requests
+}
+
+func (p *everyNRequestsPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+type writeBurstPolicy struct {
+	writes      int
+	quietPeriod time.Duration
+}
+
+// WriteBurst makes a checkpoint after a burst of at least the given number of write
+// requests, once no write was intercepted for the quiet period, so the checkpoint
+// captures the state after the burst rather than in the middle of it.
+func WriteBurst(writes int, quietPeriod time.Duration) usecase.CheckpointPolicy {
+	return &writeBurstPolicy{writes: writes, quietPeriod: quietPeriod}
+}
+
+func (p *writeBurstPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	return stats.WriteRequests >= p.writes && now.Sub(stats.LastWrite) >= p.quietPeriod
+}
+
+func (p *writeBurstPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	if stats.WriteRequests < p.writes {
+		return time.Time{}, false
+	}
+	// Planned for the end of the burst, if no other write comes.
+	return stats.LastWrite.Add(p.quietPeriod), true
+}
+
+type eventLogBytesPolicy struct {
+	budget int64
+}
+
+// EventLogBytes makes a checkpoint once the event log since the last one exceeds the
+// byte budget, bounding how much must be replayed after a restore.
+func EventLogBytes(budget int64) usecase.CheckpointPolicy {
+	return &eventLogBytesPolicy{budget: budget}
+}
+
+func (p *eventLogBytesPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	return stats.EventLogBytes >= p.budget
+}
+
+func (p *eventLogBytesPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+type anyPolicy struct {
+	policies []usecase.CheckpointPolicy
+}
+
+// Any makes a checkpoint when any of the policies is due.
+func Any(policies ...usecase.CheckpointPolicy) usecase.CheckpointPolicy {
+	return &anyPolicy{policies: policies}
+}
+
+func (p *anyPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	for _, policy := range p.policies {
+		if policy.Due(now, stats) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *anyPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	var next time.Time
+	planned := false
+	for _, policy := range p.policies {
+		if t, ok := policy.Next(now, stats); ok && (!planned || t.Before(next)) {
+			next = t
+			planned = true
+		}
+	}
+	return next, planned
+}
+
+type blackoutWindow struct {
+	start    cron.Schedule
+	duration time.Duration
+}
+
+type blackoutPolicy struct {
+	policy  usecase.CheckpointPolicy
+	windows []blackoutWindow
+}
+
+// Blackout suppresses the checkpoints of the policy during the blackout windows.
+// Checkpoints that became due during a window are made once it ends.
+func Blackout(policy usecase.CheckpointPolicy, windows []interceptorConfig.BlackoutWindow) (usecase.CheckpointPolicy, error) {
+	p := &blackoutPolicy{policy: policy}
+	for _, window := range windows {
+		start, err := cron.ParseStandard(window.Start)
+		if err != nil {
+			return nil, err
+		}
+		p.windows = append(p.windows, blackoutWindow{start: start, duration: window.Duration})
+	}
+	return p, nil
+}
+
+func (p *blackoutPolicy) Due(now time.Time, stats usecase.CheckpointStats) bool {
+	if _, inBlackout := p.windowEnd(now); inBlackout {
+		return false
+	}
+	return p.policy.Due(now, stats)
+}
+
+func (p *blackoutPolicy) Next(now time.Time, stats usecase.CheckpointStats) (time.Time, bool) {
+	next, planned := p.policy.Next(now, stats)
+	if !planned {
+		return next, false
+	}
+	if next.Before(now) {
+		next = now
+	}
+	// Windows may follow or overlap each other, move the planned time until it falls
+	// outside of all of them.
+	for i := 0; i <= len(p.windows); i++ {
+		end, inBlackout := p.windowEnd(next)
+		if !inBlackout {
+			break
+		}
+		next = end
+	}
+	return next, true
+}
+
+// windowEnd returns the end of the blackout window t is in, if any.
+func (p *blackoutPolicy) windowEnd(t time.Time) (time.Time, bool) {
+	var end time.Time
+	inBlackout := false
+	for _, window := range p.windows {
+		// The first start after t minus the duration is the only one whose window can
+		// contain t.
+		start := window.start.Next(t.Add(-window.duration))
+		if !start.After(t) {
+			if windowEnd := start.Add(window.duration); !inBlackout || windowEnd.After(end) {
+				end = windowEnd
+			}
+			inBlackout = true
+		}
+	}
+	return end, inBlackout
+}
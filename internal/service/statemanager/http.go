@@ -1,6 +1,8 @@
 package statemanager
 
 import (
+	"context"
+
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/statemanager/client"
 )
@@ -10,12 +12,26 @@ type httpStateManagerService struct {
 }
 
 func HTTP(stateManagerURL string) *httpStateManagerService {
-	c := client.New(stateManagerURL)
+	return HTTPWithConfig(stateManagerURL, client.DefaultConfig())
+}
+
+// HTTPWithConfig creates the service with the given client timeouts, retries and
+// circuit breaker configuration.
+func HTTPWithConfig(stateManagerURL string, cfg client.Config) *httpStateManagerService {
+	c := client.NewWithConfig(stateManagerURL, cfg)
 	return &httpStateManagerService{
 		client: c,
 	}
 }
 
-func (stateManager *httpStateManagerService) SaveMetadata(containerName string, metadata *entity.ContainerMetadata) error {
-	return stateManager.client.InsertMetadata(containerName, metadata)
+func (stateManager *httpStateManagerService) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	return stateManager.client.Register(ctx, registration)
+}
+
+func (stateManager *httpStateManagerService) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	return stateManager.client.Heartbeat(ctx, heartbeat)
+}
+
+func (stateManager *httpStateManagerService) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	return stateManager.client.InsertMetadataContext(ctx, identity, checkpointHash, metadata)
 }
@@ -0,0 +1,244 @@
+package statemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/statemanager/client"
+)
+
+const outboxFilename = "outbox.json"
+
+// OutboxConfig is the configuration of the State Manager outbox.
+type OutboxConfig struct {
+	// Directory is where the pending metadata submissions are persisted.
+	Directory string
+	// FlushInterval is the interval between attempts to flush pending submissions.
+	FlushInterval time.Duration
+}
+
+type outboxEntry struct {
+	Container      entity.ContainerIdentity  `json:"container"`
+	CheckpointHash string                    `json:"checkpoint_hash"`
+	Metadata       *entity.ContainerMetadata `json:"metadata"`
+}
+
+// outboxStateManagerService wraps another StateManagerService and queues metadata
+// submissions on disk while the State Manager is unreachable, sending them in the
+// order they were made once it is back. The submissions are sent without holding the
+// mutex, so queueing never waits on the State Manager.
+type outboxStateManagerService struct {
+	next          entity.StateManagerService
+	filename      string
+	flushInterval time.Duration
+	mutex         sync.Mutex
+	pending       []outboxEntry
+	// sending is whether a submission is being sent, only one is sent at a time to
+	// keep them in order.
+	sending bool
+}
+
+// Outbox creates the outbox around the given service, loading any submission left
+// pending by a previous run from the configured directory.
+func Outbox(next entity.StateManagerService, cfg OutboxConfig) (*outboxStateManagerService, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o755); err != nil {
+		return nil, err
+	}
+
+	outbox := &outboxStateManagerService{
+		next:          next,
+		filename:      filepath.Join(cfg.Directory, outboxFilename),
+		flushInterval: cfg.FlushInterval,
+	}
+
+	content, err := os.ReadFile(outbox.filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &outbox.pending); err != nil {
+			return nil, err
+		}
+	}
+
+	return outbox, nil
+}
+
+// Register registers the Interceptor directly, it is sent again by the Interceptor
+// rather than queued.
+func (outbox *outboxStateManagerService) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	return outbox.next.Register(ctx, registration)
+}
+
+// Heartbeat sends the heartbeat directly, a missed heartbeat is superseded by the
+// next one.
+func (outbox *outboxStateManagerService) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	return outbox.next.Heartbeat(ctx, heartbeat)
+}
+
+// SaveMetadata sends the metadata to the State Manager, queueing it when the State
+// Manager is unavailable. While there are queued submissions new ones are queued
+// behind them so the State Manager always receives them in order. It returns
+// entity.ErrMetadataQueued when the metadata is still queued.
+func (outbox *outboxStateManagerService) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	entry := outboxEntry{Container: identity, CheckpointHash: checkpointHash, Metadata: metadata}
+
+	outbox.mutex.Lock()
+	if len(outbox.pending) == 0 && !outbox.sending {
+		outbox.sending = true
+		outbox.mutex.Unlock()
+		err := outbox.next.SaveMetadata(ctx, identity, checkpointHash, metadata)
+		outbox.mutex.Lock()
+		outbox.sending = false
+		if err == nil || !unsent(err) {
+			outbox.mutex.Unlock()
+			if err != nil {
+				return err
+			}
+			// Submissions may have been queued behind this one while it was sent.
+			return outbox.flush(ctx)
+		}
+		slog.Warn("state manager unavailable, queueing metadata", logging.KeyContainer, identity.String(), logging.KeyCheckpointHash, checkpointHash, logging.Error(err))
+		// The submissions queued while this one was sent were made after it.
+		outbox.pending = append([]outboxEntry{entry}, outbox.pending...)
+	} else {
+		outbox.pending = append(outbox.pending, entry)
+	}
+	err := outbox.persist()
+	outbox.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := outbox.flush(ctx); err != nil {
+		return err
+	}
+	if outbox.queued(metadata) {
+		return entity.ErrMetadataQueued
+	}
+	return nil
+}
+
+// queued tells whether the submission of the metadata is still pending.
+func (outbox *outboxStateManagerService) queued(metadata *entity.ContainerMetadata) bool {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	return slices.ContainsFunc(outbox.pending, func(entry outboxEntry) bool {
+		return entry.Metadata == metadata
+	})
+}
+
+// Pending returns the number of submissions waiting to be sent.
+func (outbox *outboxStateManagerService) Pending() int {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	return len(outbox.pending)
+}
+
+// Flush tries to send every queued submission, stopping at the first one the State
+// Manager is still unavailable for. It returns at once when a submission is already
+// being sent.
+func (outbox *outboxStateManagerService) Flush() error {
+	return outbox.flush(context.Background())
+}
+
+// Run flushes the outbox every FlushInterval until the context is done.
+func (outbox *outboxStateManagerService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(outbox.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := outbox.Flush(); err != nil {
+				slog.Error("could not flush state manager outbox", logging.Error(err))
+			}
+		}
+	}
+}
+
+func (outbox *outboxStateManagerService) flush(ctx context.Context) error {
+	for {
+		outbox.mutex.Lock()
+		if outbox.sending || len(outbox.pending) == 0 {
+			outbox.mutex.Unlock()
+			return nil
+		}
+		outbox.sending = true
+		entries := slices.Clone(outbox.pending)
+		outbox.mutex.Unlock()
+
+		sent, unavailable := outbox.send(ctx, entries)
+
+		outbox.mutex.Lock()
+		outbox.sending = false
+		// Submissions queued while sending were appended after the sent ones.
+		outbox.pending = outbox.pending[sent:]
+		var err error
+		if sent > 0 {
+			err = outbox.persist()
+		}
+		outbox.mutex.Unlock()
+		if err != nil || unavailable {
+			return err
+		}
+	}
+}
+
+// send sends the entries in order, returning how many were sent and whether it
+// stopped because the State Manager is unavailable.
+func (outbox *outboxStateManagerService) send(ctx context.Context, entries []outboxEntry) (int, bool) {
+	for i, entry := range entries {
+		err := outbox.next.SaveMetadata(ctx, entry.Container, entry.CheckpointHash, entry.Metadata)
+		if err != nil && unsent(err) {
+			return i, true
+		}
+		if err != nil {
+			// The State Manager rejected the submission, retrying it would only block
+			// the ones queued behind it.
+			slog.Error("dropping queued metadata rejected by state manager", logging.KeyContainer, entry.Container.String(), logging.KeyCheckpointHash, entry.CheckpointHash, logging.Error(err))
+		}
+	}
+	return len(entries), false
+}
+
+// unsent tells whether the submission failed without an answer of the State Manager,
+// unavailable or given up by the caller, and must be sent again.
+func unsent(err error) bool {
+	return errors.Is(err, client.ErrUnavailable) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// persist atomically replaces the outbox file with the pending submissions.
+func (outbox *outboxStateManagerService) persist() error {
+	content, err := json.Marshal(outbox.pending)
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := outbox.filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, outbox.filename)
+}
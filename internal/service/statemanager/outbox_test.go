@@ -0,0 +1,157 @@
+package statemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/pkg/statemanager/client"
+)
+
+type flakyStateManager struct {
+	available bool
+	received  []string
+}
+
+func (s *flakyStateManager) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	return nil
+}
+
+func (s *flakyStateManager) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	return nil
+}
+
+func (s *flakyStateManager) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !s.available {
+		return fmt.Errorf("%w: connection refused", client.ErrUnavailable)
+	}
+	s.received = append(s.received, metadata.LastRequestSolvedID)
+	return nil
+}
+
+// blockingStateManager blocks every submission until it is released.
+type blockingStateManager struct {
+	flakyStateManager
+	mutex   sync.Mutex
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStateManager) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	s.entered <- struct{}{}
+	<-s.release
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.received = append(s.received, metadata.LastRequestSolvedID)
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app", Container: "test"}
+	dir := t.TempDir()
+	stateManager := &flakyStateManager{}
+	outbox, err := Outbox(stateManager, OutboxConfig{Directory: dir, FlushInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should queue metadata while the State Manager is unavailable", func(t *testing.T) {
+		for _, id := range []string{"1", "2"} {
+			if err := outbox.SaveMetadata(context.Background(), identity, "hash", &entity.ContainerMetadata{LastRequestSolvedID: id}); !errors.Is(err, entity.ErrMetadataQueued) {
+				t.Errorf("expected ErrMetadataQueued, received %v\n", err)
+			}
+		}
+		if outbox.Pending() != 2 {
+			t.Errorf("expected 2 pending submissions, got %d\n", outbox.Pending())
+		}
+	})
+
+	t.Run("it should keep queued metadata across restarts", func(t *testing.T) {
+		reopened, err := Outbox(stateManager, OutboxConfig{Directory: dir, FlushInterval: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reopened.Pending() != 2 {
+			t.Errorf("expected 2 pending submissions, got %d\n", reopened.Pending())
+		}
+	})
+
+	t.Run("it should flush queued metadata in order", func(t *testing.T) {
+		stateManager.available = true
+		if err := outbox.SaveMetadata(context.Background(), identity, "hash", &entity.ContainerMetadata{LastRequestSolvedID: "3"}); err != nil {
+			t.Errorf("expected error nil, received %v\n", err)
+		}
+		if fmt.Sprint(stateManager.received) != "[1 2 3]" {
+			t.Errorf("expected metadata to be received in order, got %v\n", stateManager.received)
+		}
+		if outbox.Pending() != 0 {
+			t.Errorf("expected no pending submissions, got %d\n", outbox.Pending())
+		}
+	})
+
+	t.Run("it should queue metadata sent with a canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := outbox.SaveMetadata(ctx, identity, "hash", &entity.ContainerMetadata{LastRequestSolvedID: "4"}); !errors.Is(err, entity.ErrMetadataQueued) {
+			t.Errorf("expected ErrMetadataQueued, received %v\n", err)
+		}
+		if err := outbox.Flush(); err != nil {
+			t.Errorf("expected error nil, received %v\n", err)
+		}
+		if fmt.Sprint(stateManager.received) != "[1 2 3 4]" {
+			t.Errorf("expected the canceled metadata sent on the next flush, got %v\n", stateManager.received)
+		}
+	})
+
+	t.Run("it should queue metadata while a flush waits on the State Manager", func(t *testing.T) {
+		stateManager := &blockingStateManager{entered: make(chan struct{}), release: make(chan struct{})}
+		outbox, err := Outbox(stateManager, OutboxConfig{Directory: t.TempDir(), FlushInterval: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		saved := make(chan error, 1)
+		go func() {
+			saved <- outbox.SaveMetadata(context.Background(), identity, "hash", &entity.ContainerMetadata{LastRequestSolvedID: "1"})
+		}()
+		<-stateManager.entered
+
+		queued := make(chan error, 1)
+		go func() {
+			queued <- outbox.SaveMetadata(context.Background(), identity, "hash", &entity.ContainerMetadata{LastRequestSolvedID: "2"})
+		}()
+		select {
+		case err := <-queued:
+			if !errors.Is(err, entity.ErrMetadataQueued) {
+				t.Errorf("expected ErrMetadataQueued, received %v\n", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the metadata to be queued without waiting on the State Manager")
+		}
+		if outbox.Pending() != 1 {
+			t.Errorf("expected 1 pending submission, got %d\n", outbox.Pending())
+		}
+
+		close(stateManager.release)
+		go func() {
+			for range stateManager.entered {
+			}
+		}()
+		if err := <-saved; err != nil {
+			t.Errorf("expected error nil, received %v\n", err)
+		}
+		if fmt.Sprint(stateManager.received) != "[1 2]" {
+			t.Errorf("expected metadata to be received in order, got %v\n", stateManager.received)
+		}
+		if outbox.Pending() != 0 {
+			t.Errorf("expected no pending submissions, got %d\n", outbox.Pending())
+		}
+	})
+}
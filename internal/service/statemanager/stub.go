@@ -1,6 +1,10 @@
 package statemanager
 
-import "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+import (
+	"context"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
 
 type alwaysAcceptingStateManagerStub struct{}
 
@@ -8,6 +12,14 @@ func AlawaysAcceptingStub() *alwaysAcceptingStateManagerStub {
 	return &alwaysAcceptingStateManagerStub{}
 }
 
-func (stateManager *alwaysAcceptingStateManagerStub) SaveMetadata(containerName string, metadata *entity.ContainerMetadata) error {
+func (stateManager *alwaysAcceptingStateManagerStub) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	return nil
+}
+
+func (stateManager *alwaysAcceptingStateManagerStub) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	return nil
+}
+
+func (stateManager *alwaysAcceptingStateManagerStub) SaveMetadata(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
 	return nil
 }
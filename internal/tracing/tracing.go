@@ -0,0 +1,106 @@
+// Package tracing sets up OpenTelemetry tracing and propagates the W3C trace context
+// over HTTP.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	tracingConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/GianOrtiz/k8s-transparent-checkpoint-restore"
+
+// propagator propagates the W3C trace context and baggage.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup installs the global tracer provider of the service configured to export
+// spans as configured. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, serviceName string, cfg tracingConfig.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if cfg.Exporter != tracingConfig.ExporterOTLP {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(serviceName, sdktrace.NewBatchSpanProcessor(exporter), cfg.SampleRatio)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider of the service sending its spans to the
+// processor. Tests use it with an in-memory exporter.
+func NewProvider(serviceName string, processor sdktrace.SpanProcessor, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+}
+
+// InMemory installs a global tracer provider recording the spans of the service in
+// memory, for tests.
+func InMemory(serviceName string) *tracetest.InMemoryExporter {
+	otel.SetTextMapPropagator(propagator)
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(NewProvider(serviceName, sdktrace.NewSimpleSpanProcessor(exporter), 1))
+	return exporter
+}
+
+// Start starts a span named after the operation as a child of the span in ctx.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error in the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx in the headers of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract reads the trace context of an incoming request.
+func Extract(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
+
+// Handler serves the requests in a server span named after the route, continuing the
+// trace of the caller.
+func Handler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer(instrumentationName).Start(Extract(r), route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method)),
+		)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
+)
+
+var (
+	// ErrHoldLimitExceeded is returned for the requests received while holding once
+	// the held requests reach the limits of the configuration.
+	ErrHoldLimitExceeded = errors.New("too many requests held during the restore")
+	// ErrHoldTimeout is returned for the requests held for longer than the maximum
+	// wait.
+	ErrHoldTimeout = errors.New("request held for too long during the restore")
+)
+
+// requestHold buffers the requests received while the monitored container is
+// restored, then dispatches them one at a time in version order once released.
+type requestHold struct {
+	mutex    sync.Mutex
+	holding  bool
+	draining bool
+	// reheld tells a new hold started while draining, holding continues after it.
+	reheld bool
+	queue  []*heldRequest
+	bytes  int64
+	expiry *time.Timer
+}
+
+// heldRequest is a request waiting for the hold to be released.
+type heldRequest struct {
+	version int
+	size    int64
+	// dispatched is closed once the request can be forwarded.
+	dispatched chan struct{}
+	// done is closed by the request once forwarded, to dispatch the next one.
+	done chan struct{}
+}
+
+// hold starts holding the requests, until release or the timeout.
+func (h *requestHold) hold(timeout time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.holding = true
+	h.reheld = h.draining
+	if h.expiry != nil {
+		h.expiry.Stop()
+	}
+	h.expiry = time.AfterFunc(timeout, h.release)
+}
+
+// release dispatches the held requests, the requests received meanwhile are held
+// after them.
+func (h *requestHold) release() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.holding {
+		return
+	}
+	if h.expiry != nil {
+		h.expiry.Stop()
+		h.expiry = nil
+	}
+	if h.draining {
+		h.reheld = false
+		return
+	}
+	h.draining = true
+	go h.drain()
+}
+
+func (h *requestHold) drain() {
+	for {
+		h.mutex.Lock()
+		if len(h.queue) == 0 {
+			h.holding = h.reheld
+			h.draining = false
+			h.reheld = false
+			h.mutex.Unlock()
+			return
+		}
+		next := h.queue[0]
+		h.queue = h.queue[1:]
+		h.bytes -= next.size
+		metrics.HeldRequests.Set(float64(len(h.queue)))
+		h.mutex.Unlock()
+
+		close(next.dispatched)
+		<-next.done
+	}
+}
+
+// admit holds the request when holding, returning nil otherwise. The caller must
+// wait for the held request and then close its done channel.
+func (h *requestHold) admit(version int, size int64, cfg interceptorConfig.HoldingConfig) (*heldRequest, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.holding {
+		return nil, nil
+	}
+	if len(h.queue) >= cfg.MaxRequests || h.bytes+size > cfg.MaxBytes {
+		metrics.RefusedHeldRequests.WithLabelValues("limit").Inc()
+		return nil, ErrHoldLimitExceeded
+	}
+
+	held := &heldRequest{
+		version:    version,
+		size:       size,
+		dispatched: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	// Requests can reach the hold out of the order of their versions.
+	i := sort.Search(len(h.queue), func(i int) bool { return h.queue[i].version > version })
+	h.queue = append(h.queue, nil)
+	copy(h.queue[i+1:], h.queue[i:])
+	h.queue[i] = held
+	h.bytes += size
+	metrics.HeldRequests.Set(float64(len(h.queue)))
+	return held, nil
+}
+
+// wait waits for the held request to be dispatched, at most maxWait.
+func (h *requestHold) wait(ctx context.Context, held *heldRequest, maxWait time.Duration) error {
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-held.dispatched:
+		return nil
+	case <-timer.C:
+		err = ErrHoldTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, queued := range h.queue {
+		if queued == held {
+			h.queue = append(h.queue[:i], h.queue[i+1:]...)
+			h.bytes -= held.size
+			metrics.HeldRequests.Set(float64(len(h.queue)))
+			if errors.Is(err, ErrHoldTimeout) {
+				metrics.RefusedHeldRequests.WithLabelValues("timeout").Inc()
+			}
+			return err
+		}
+	}
+	// The request was dispatched meanwhile.
+	return nil
+}
+
+// status returns whether requests are held and how many.
+func (h *requestHold) status() (bool, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.holding, len(h.queue)
+}
@@ -1,14 +1,32 @@
 package usecase
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // InterceptorUseCase declares use cases for the Interceptor. It declares the use cases for intercepting
@@ -18,14 +36,95 @@ type InterceptorUseCase interface {
 	InterceptRequest(reqID string, req *http.Request) (*http.Response, error)
 	// Checkpoint creates a new checkpoint of the monitored container.
 	Checkpoint() error
-	// Reproject reprojects the requests to the monitored application since the given version.
-	Reproject(version int) error
+	// CreateCheckpoint creates a new checkpoint of the monitored container, returning
+	// its hash and metadata.
+	CreateCheckpoint(ctx context.Context) (*entity.CheckpointResult, error)
+	// ExportCheckpoint writes the image of the checkpoint, to be imported on another
+	// node.
+	ExportCheckpoint(checkpointHash string, w io.Writer) error
+	// ImportCheckpoint stores the image of a checkpoint exported on another node.
+	ImportCheckpoint(checkpointHash string, r io.Reader) error
+	// RestoreCheckpoint restores the checkpoint in the monitored container.
+	RestoreCheckpoint(ctx context.Context, checkpointHash string) error
+	// Reproject reprojects the requests to the monitored application since the given
+	// version after the restore of the given ID, then releases the held requests. The
+	// requests at or below the applied version are skipped when the configuration says
+	// so, and so are the ones an interrupted reprojection of the same restore replayed.
+	// The reprojections without a restore always start over.
+	Reproject(ctx context.Context, version int, appliedVersion int, restore string) (*entity.ReprojectionReport, error)
+	// Hold holds the intercepted requests, within the limits of the configuration,
+	// while the monitored container is restored, until Release or the hold timeout.
+	Hold()
+	// Release forwards the held requests in version order and stops holding.
+	Release()
+	// SwitchUpstream replays the requests since the given version to the upstream and
+	// then forwards the new requests to it, holding them meanwhile so they reach the
+	// upstream after the replayed ones.
+	SwitchUpstream(ctx context.Context, fromVersion int, upstream string) error
+	// UpdateConfig applies a new configuration to the running Interceptor. Only the
+	// checkpointing interval, the checkpoint retention, the monitored container URL and
+	// the body capture limit are applied, other changes require a restart.
+	UpdateConfig(cfg *interceptorConfig.Config) error
+	// Status returns the current state of the Interceptor.
+	Status() (*entity.InterceptorStatus, error)
+	// Ready tells whether the Interceptor can forward requests to the monitored
+	// container, returning why when it cannot.
+	Ready(ctx context.Context) error
+	// Register registers the Interceptor and its monitored container in the State
+	// Manager.
+	Register(ctx context.Context) error
+	// Heartbeat tells the State Manager the Interceptor is alive, registering it
+	// again when the State Manager does not know it.
+	Heartbeat(ctx context.Context) error
+	// RunHeartbeats sends a heartbeat every interval until the context is done.
+	RunHeartbeats(ctx context.Context, interval time.Duration) error
 }
 
-// Scheduler schedules tasks to be handled in the future.
+// ErrCheckpointInProgress is returned by Checkpoint when another checkpoint of the
+// monitored container is still running.
+var ErrCheckpointInProgress = errors.New("checkpoint already in progress")
+
+// ErrReprojectionInProgress is returned by Ready while requests are being reprojected
+// to the monitored container.
+var ErrReprojectionInProgress = errors.New("reprojection in progress")
+
+// Scheduler schedules the checkpoints of the monitored container.
 type Scheduler interface {
-	// ScheduleCheckpoint schedules the checkponint to be made in the future.
-	ScheduleCheckpoint(usecase InterceptorUseCase, scheduleIn time.Duration) error
+	// Run makes the checkpoints of the use case when its policy decides they are due,
+	// one at a time, until the context is done.
+	Run(ctx context.Context, usecase InterceptorUseCase) error
+	// ObserveRequest records an intercepted request for request driven policies.
+	ObserveRequest(req *entity.InterceptedRequest)
+	// UpdateConfig replaces the checkpoint policy by the one of the configuration.
+	UpdateConfig(cfg *interceptorConfig.Config) error
+	// NextRun returns when the next checkpoint is planned, false when it depends on
+	// the traffic rather than on time.
+	NextRun() (time.Time, bool)
+}
+
+// CheckpointStats are the statistics of the traffic since the last checkpoint.
+type CheckpointStats struct {
+	// LastCheckpoint is when the last successful checkpoint started.
+	LastCheckpoint time.Time
+	// Requests is the number of requests intercepted since the last checkpoint.
+	Requests int
+	// WriteRequests is the number of requests intercepted since the last checkpoint
+	// with methods other than GET, HEAD, OPTIONS and TRACE.
+	WriteRequests int
+	// LastWrite is when the last write request was intercepted.
+	LastWrite time.Time
+	// EventLogBytes is the estimated size of the event log since the last checkpoint.
+	EventLogBytes int64
+}
+
+// CheckpointPolicy decides when a checkpoint is due.
+type CheckpointPolicy interface {
+	// Due tells whether a checkpoint is due at now given the statistics since the last
+	// checkpoint.
+	Due(now time.Time, stats CheckpointStats) bool
+	// Next returns when the next checkpoint is planned after now, false when the
+	// policy is not driven by time.
+	Next(now time.Time, stats CheckpointStats) (time.Time, bool)
 }
 
 type interceptorUseCase struct {
@@ -35,10 +134,36 @@ type interceptorUseCase struct {
 	InterceptedRequestRepository entity.InterceptedRequestRepository
 	Scheduler                    Scheduler
 	LastVersion                  int
-	Mutex                        sync.Mutex
+	LastCheckpoint               time.Time
+	// LastCheckpointHash is the hash of the last successful checkpoint.
+	LastCheckpointHash string
+	// LastCheckpointError is the error of the last checkpoint, nil when it succeeded.
+	LastCheckpointError error
+	// LastCheckpointFailure is when LastCheckpointError happened.
+	LastCheckpointFailure time.Time
+	Mutex                 sync.Mutex
+	// CheckpointMutex prevents checkpoints from overlapping.
+	CheckpointMutex sync.Mutex
+	// ConfigMutex guards the Interceptor configuration and monitored container URL,
+	// which can be updated while requests are being intercepted.
+	ConfigMutex sync.RWMutex
+	// Reprojections is the number of reprojections running.
+	Reprojections atomic.Int32
+	// RestoreService restores the checkpoints migrated to this Interceptor.
+	RestoreService entity.RestoreService
+	// ImageStore exports and imports the checkpoint images of migrations.
+	ImageStore entity.CheckpointImageStore
+	// hold holds the requests received during restores.
+	hold requestHold
+	// ProgressRepository stores the progress of the reprojections, so interrupted
+	// ones resume. Reprojections always start over when nil.
+	ProgressRepository entity.ReprojectionProgressRepository
+	// forwarding is read locked by the requests from their version to their response,
+	// checkpoints lock it so the container is dumped with no request in flight.
+	forwarding sync.RWMutex
 }
 
-func Interceptor(interceptor *entity.Interceptor, checkpointService entity.CheckpointService, stateManagerService entity.StateManagerService, interceptedRequestRepository entity.InterceptedRequestRepository, scheduler Scheduler) (InterceptorUseCase, error) {
+func Interceptor(interceptor *entity.Interceptor, checkpointService entity.CheckpointService, stateManagerService entity.StateManagerService, interceptedRequestRepository entity.InterceptedRequestRepository, scheduler Scheduler, restoreService entity.RestoreService, imageStore entity.CheckpointImageStore, progressRepository entity.ReprojectionProgressRepository) (InterceptorUseCase, error) {
 	// Retrieve the last version of request in the database
 	lastVersion, err := interceptedRequestRepository.GetLastVersion()
 	if err != nil {
@@ -50,6 +175,9 @@ func Interceptor(interceptor *entity.Interceptor, checkpointService entity.Check
 		InterceptedRequestRepository: interceptedRequestRepository,
 		CheckpointService:            checkpointService,
 		StateManagerService:          stateManagerService,
+		RestoreService:               restoreService,
+		ImageStore:                   imageStore,
+		ProgressRepository:           progressRepository,
 		LastVersion:                  lastVersion,
 		Scheduler:                    scheduler,
 		Mutex:                        sync.Mutex{},
@@ -59,41 +187,110 @@ func Interceptor(interceptor *entity.Interceptor, checkpointService entity.Check
 // InterceptRequest intercepts a given request and return the response after it is
 // redirected to the monitored application.
 func (uc *interceptorUseCase) InterceptRequest(reqID string, req *http.Request) (*http.Response, error) {
+	uc.ConfigMutex.RLock()
+	action := interceptorConfig.RouteAction(uc.Interceptor.Config.Routes, req)
+	uc.ConfigMutex.RUnlock()
+	if action == interceptorConfig.RoutePassthrough {
+		return uc.passThrough(reqID, req)
+	}
+
+	// The request gets its version once no checkpoint is running, and a checkpoint
+	// waits for the requests with a version to be solved or failed. The image then has
+	// the effects of every request up to the last version and of none after it.
+	uc.forwarding.RLock()
+	defer uc.forwarding.RUnlock()
+
 	// TODO: abstract this
 	uc.Mutex.Lock()
 	interceptedRequest := entity.InterceptedRequest{
-		ID:      reqID,
-		Request: req,
-		Solved:  false,
-		Version: uc.LastVersion + 1,
+		ID:         reqID,
+		Request:    req,
+		Solved:     false,
+		Version:    uc.LastVersion + 1,
+		ReceivedAt: time.Now(),
 	}
 	uc.LastVersion++
 	uc.Mutex.Unlock()
 
-	if err := uc.InterceptedRequestRepository.Save(&interceptedRequest); err != nil {
-		return nil, err
+	ctx, span := tracing.Start(req.Context(), "InterceptRequest",
+		attribute.String("request.id", reqID),
+		attribute.Int("request.version", interceptedRequest.Version),
+	)
+	res, err := uc.interceptRequest(ctx, &interceptedRequest)
+	tracing.End(span, err)
+	if err != nil {
+		slog.Error("could not intercept request",
+			logging.KeyRequestID, reqID,
+			logging.KeyVersion, interceptedRequest.Version,
+			logging.Error(err),
+		)
+	} else {
+		slog.Debug("request intercepted", logging.KeyRequestID, reqID, logging.KeyVersion, interceptedRequest.Version)
 	}
+	return res, err
+}
 
-	// Create the URL to access the monitored URL from the monitored application URL
-	// and the content receive in the path of the intercepted request.
-	url := uc.Interceptor.MonitoredContainer.HTTPUrl + req.URL.Path
-	reqCopy, err := http.NewRequest(req.Method, url, req.Body)
+// passThrough forwards the request without recording it, it is neither versioned nor
+// held during restores.
+func (uc *interceptorUseCase) passThrough(reqID string, req *http.Request) (*http.Response, error) {
+	uc.ConfigMutex.RLock()
+	containerURL := uc.Interceptor.MonitoredContainer.HTTPUrl
+	uc.ConfigMutex.RUnlock()
+
+	ctx, span := tracing.Start(req.Context(), "PassThrough", attribute.String("request.id", reqID))
+	res, err := forward(ctx, containerURL, req, req.Body, nil)
+	tracing.End(span, err)
+	if err != nil {
+		slog.Error("could not pass request through", logging.KeyRequestID, reqID, logging.Error(err))
+	}
+	return res, err
+}
+
+func (uc *interceptorUseCase) interceptRequest(ctx context.Context, interceptedRequest *entity.InterceptedRequest) (*http.Response, error) {
+	req := interceptedRequest.Request
+	uc.ConfigMutex.RLock()
+	maxBodyCaptureBytes := uc.Interceptor.Config.MaxBodyCaptureBytes
+	holding := uc.Interceptor.Config.Holding
+	uc.ConfigMutex.RUnlock()
+
+	body, err := captureBody(req, maxBodyCaptureBytes, interceptedRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	for key, values := range req.Header {
-		for _, value := range values {
-			reqCopy.Header.Add(key, value)
+	// While the container is restored the request is saved only once released, so it
+	// is not reprojected and reaches the container after the reprojected ones.
+	held, err := uc.hold.admit(interceptedRequest.Version, int64(len(interceptedRequest.Body)), holding)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil {
+		if err := uc.hold.wait(ctx, held, holding.MaxWait); err != nil {
+			return nil, err
 		}
+		defer close(held.done)
+	}
+
+	// The request is saved and its upstream read under the configuration lock, so a
+	// switch of the upstream either replays it or forwards it to the new upstream.
+	uc.ConfigMutex.RLock()
+	containerURL := uc.Interceptor.MonitoredContainer.HTTPUrl
+	err = uc.InterceptedRequestRepository.Save(interceptedRequest)
+	uc.ConfigMutex.RUnlock()
+	if err != nil {
+		return nil, err
 	}
+	uc.Scheduler.ObserveRequest(interceptedRequest)
+	metrics.EventLogRequests.Inc()
+	metrics.EventLogBytes.Add(float64(len(interceptedRequest.Body)))
+	metrics.EventLogVersion.Set(float64(interceptedRequest.Version))
 
-	res, err := http.DefaultClient.Do(reqCopy)
+	res, err := forward(ctx, containerURL, req, body, interceptedRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := uc.InterceptedRequestRepository.SetSolved(reqID, time.Now(), true); err != nil {
+	if err := uc.InterceptedRequestRepository.SetSolved(interceptedRequest.ID, time.Now(), true); err != nil {
 		return nil, err
 	}
 
@@ -102,56 +299,647 @@ func (uc *interceptorUseCase) InterceptRequest(reqID string, req *http.Request)
 
 // Checkpoint the monitored application into a new image.
 func (uc *interceptorUseCase) Checkpoint() error {
-	metadata := uc.generateMetadataForNewImage()
-	if err := uc.StateManagerService.SaveMetadata(uc.Interceptor.MonitoredContainer.Name, metadata); err != nil {
-		return err
+	_, err := uc.CreateCheckpoint(context.Background())
+	return err
+}
+
+func (uc *interceptorUseCase) CreateCheckpoint(ctx context.Context) (*entity.CheckpointResult, error) {
+	if !uc.CheckpointMutex.TryLock() {
+		return nil, ErrCheckpointInProgress
+	}
+	defer uc.CheckpointMutex.Unlock()
+
+	ctx, span := tracing.Start(ctx, "Checkpoint",
+		attribute.String("container.name", uc.Interceptor.MonitoredContainer.Name),
+	)
+	startedAt := time.Now()
+	result, err := uc.checkpoint(ctx)
+	// The checkpoint is made even when its metadata waits in the outbox, but the
+	// State Manager may still restore the previous one.
+	acknowledged := err == nil
+	if errors.Is(err, entity.ErrMetadataQueued) {
+		err = nil
+	}
+	metrics.ObserveCheckpoint(startedAt, err)
+	tracing.End(span, err)
+
+	uc.Mutex.Lock()
+	if err != nil {
+		uc.LastCheckpointError = err
+		uc.LastCheckpointFailure = time.Now()
+	} else {
+		uc.LastCheckpoint = time.Now()
+		uc.LastCheckpointHash = result.CheckpointHash
+		uc.LastCheckpointError = nil
+	}
+	uc.Mutex.Unlock()
+
+	logger := slog.With(logging.KeyContainer, uc.Interceptor.MonitoredContainer.Name, logging.KeyCheckpointHash, result.CheckpointHash)
+	if err != nil {
+		logger.Error("checkpoint failed", logging.Error(err))
+		return nil, err
+	}
+	logger.Info("checkpoint created", "duration", time.Since(startedAt), "metadata_queued", !acknowledged)
+	if acknowledged {
+		uc.pruneImages(result.CheckpointHash)
+	}
+	return result, nil
+}
+
+// pruneImages deletes the oldest images of the monitored container beyond the
+// retention, never the one of the latest checkpoint. It only runs once the State
+// Manager acknowledged the latest checkpoint, the outbox sends the metadata in
+// order so no older checkpoint can become the latest again. A failure only delays
+// the pruning to the next checkpoint.
+func (uc *interceptorUseCase) pruneImages(latestHash string) {
+	uc.ConfigMutex.RLock()
+	retention := uc.Interceptor.Config.CheckpointRetention
+	uc.ConfigMutex.RUnlock()
+	if uc.ImageStore == nil || retention <= 0 {
+		return
+	}
+
+	containerName := uc.Interceptor.MonitoredContainer.Name
+	images, err := uc.ImageStore.List()
+	if err != nil {
+		slog.Warn("could not list the checkpoint images", logging.KeyContainer, containerName, logging.Error(err))
+		return
+	}
+	var own []entity.CheckpointImage
+	for _, image := range images {
+		if isImageOf(containerName, image.Hash) {
+			own = append(own, image)
+		}
+	}
+	// Newest first, the ones past the retention are deleted.
+	sort.SliceStable(own, func(i, j int) bool {
+		return own[j].CreatedAt.Before(own[i].CreatedAt)
+	})
+	kept := 0
+	for _, image := range own {
+		if kept < retention || image.Hash == latestHash {
+			kept++
+			continue
+		}
+		if err := uc.ImageStore.Delete(image.Hash); err != nil {
+			slog.Warn("could not prune checkpoint image", logging.KeyContainer, containerName, logging.KeyCheckpointHash, image.Hash, logging.Error(err))
+			continue
+		}
+		slog.Info("pruned checkpoint image", logging.KeyContainer, containerName, logging.KeyCheckpointHash, image.Hash)
 	}
+}
 
+// checkpoint dumps the image and then saves its metadata in the State Manager, each
+// phase in its own span. The metadata moves the latest checkpoint of the container,
+// so it is only saved once the image exists. The new requests are held during the
+// dump, so the image has exactly the requests up to the version of the metadata. The
+// result always has the hash of the image.
+func (uc *interceptorUseCase) checkpoint(ctx context.Context) (*entity.CheckpointResult, error) {
 	checkpointHash := uc.generateHashForNewImage(uc.Interceptor.MonitoredContainer.Name)
+
+	uc.forwarding.Lock()
+	metadata := uc.generateMetadataForNewImage()
+	result := &entity.CheckpointResult{CheckpointHash: checkpointHash, Metadata: *metadata}
+
+	_, span := tracing.Start(ctx, "Checkpoint.Dump", attribute.String("checkpoint.hash", checkpointHash))
 	err := uc.CheckpointService.Checkpoint(&entity.CheckpointConfig{
 		Container:      uc.Interceptor.MonitoredContainer,
 		CheckpointHash: checkpointHash,
 	})
+	tracing.End(span, err)
+	uc.forwarding.Unlock()
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	// Reeschedule checkpoint in the future.
-	return uc.Scheduler.ScheduleCheckpoint(uc, uc.Interceptor.Config.CheckpointingInterval)
+	metadataCtx, span := tracing.Start(ctx, "Checkpoint.SaveMetadata", attribute.String("checkpoint.hash", checkpointHash))
+	err = uc.StateManagerService.SaveMetadata(metadataCtx, uc.Interceptor.MonitoredContainer.Identity(), checkpointHash, metadata)
+	tracing.End(span, err)
+	return result, err
 }
 
-func (uc *interceptorUseCase) Reproject(version int) error {
-	requests, err := uc.InterceptedRequestRepository.GetAllFromLastVersion(version)
+func (uc *interceptorUseCase) Reproject(ctx context.Context, version int, appliedVersion int, restore string) (*entity.ReprojectionReport, error) {
+	uc.Reprojections.Add(1)
+	defer uc.Reprojections.Add(-1)
+
+	uc.ConfigMutex.RLock()
+	containerURL := uc.Interceptor.MonitoredContainer.HTTPUrl
+	routes := uc.Interceptor.Config.Routes
+	replay := uc.Interceptor.Config.Replay
+	uc.ConfigMutex.RUnlock()
+	if !replay.SkipApplied {
+		appliedVersion = 0
+	}
+
+	ctx, span := tracing.Start(ctx, "Reproject",
+		attribute.Int("reprojection.from_version", version),
+		attribute.Int("reprojection.applied_version", appliedVersion),
+		attribute.String("reprojection.restore", restore),
+	)
+	report, err := uc.reproject(ctx, version, appliedVersion, restore, containerURL, routes, replay)
+	metrics.Reprojections.WithLabelValues(metrics.Result(err)).Inc()
+	tracing.End(span, err)
+	slog.Info("reprojection finished",
+		logging.KeyVersion, version,
+		"replayed", report.Replayed,
+		"skipped", report.Skipped,
+		"retries", report.Retries,
+		"failures", len(report.Failures),
+		"duration", report.Duration,
+	)
+
+	// The held requests are forwarded even when the reprojection failed, the
+	// container is up again.
+	uc.Release()
+	return report, err
+}
+
+func (uc *interceptorUseCase) Hold() {
+	uc.ConfigMutex.RLock()
+	holding := uc.Interceptor.Config.Holding
+	uc.ConfigMutex.RUnlock()
+	if holding.MaxRequests == 0 {
+		return
+	}
+
+	uc.hold.hold(holding.Timeout)
+	slog.Info("holding requests", "timeout", holding.Timeout)
+}
+
+func (uc *interceptorUseCase) Release() {
+	if holding, held := uc.hold.status(); holding {
+		slog.Info("releasing held requests", "requests", held)
+	}
+	uc.hold.release()
+}
+
+func (uc *interceptorUseCase) SwitchUpstream(ctx context.Context, fromVersion int, upstream string) error {
+	upstreamURL, err := url.Parse(upstream)
+	if err != nil || upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+		return fmt.Errorf("upstream must be an absolute URL, got %q", upstream)
+	}
+
+	uc.Reprojections.Add(1)
+	defer uc.Reprojections.Add(-1)
+
+	ctx, span := tracing.Start(ctx, "SwitchUpstream",
+		attribute.Int("reprojection.from_version", fromVersion),
+		attribute.String("upstream", upstream),
+	)
+	// The new requests are held while the requests since the version are replayed,
+	// once released they read the new upstream.
+	uc.Hold()
+	uc.ConfigMutex.RLock()
+	routes := uc.Interceptor.Config.Routes
+	replay := uc.Interceptor.Config.Replay
+	uc.ConfigMutex.RUnlock()
+
+	_, err = uc.reproject(ctx, fromVersion, 0, "", upstream, routes, replay)
+	if err == nil {
+		uc.ConfigMutex.Lock()
+		uc.Interceptor.MonitoredContainer.HTTPUrl = upstream
+		if uc.Interceptor.Config != nil {
+			updated := *uc.Interceptor.Config
+			updated.ContainerURL = *upstreamURL
+			uc.Interceptor.Config = &updated
+		}
+		uc.ConfigMutex.Unlock()
+	}
+	uc.Release()
+	metrics.Reprojections.WithLabelValues(metrics.Result(err)).Inc()
+	tracing.End(span, err)
+
 	if err != nil {
 		return err
 	}
+	slog.Info("upstream switched", "url", upstream, logging.KeyVersion, fromVersion)
+	return nil
+}
 
-	for _, interceptedReq := range requests {
-		// Create the URL to access the monitored URL from the monitored application URL
-		// and the content receive in the path of the intercepted request.
-		url := uc.Interceptor.MonitoredContainer.HTTPUrl + interceptedReq.Request.URL.Path
-		reqCopy, err := http.NewRequest(interceptedReq.Request.Method, url, interceptedReq.Request.Body)
+// reproject replays the requests since the version to the container URL, except the
+// ones at or below the applied version, the ones the replay policy of their route
+// excludes and the ones an interrupted reprojection of the same restore from the
+// same version already replayed. The progress is only saved with a restore, the
+// container restored again since an interrupted reprojection needs all its requests.
+func (uc *interceptorUseCase) reproject(ctx context.Context, version int, appliedVersion int, restore string, containerURL string, routes []interceptorConfig.RouteRule, replay interceptorConfig.ReplayConfig) (*entity.ReprojectionReport, error) {
+	progressRepository := uc.ProgressRepository
+	if restore == "" {
+		progressRepository = nil
+	}
+	report := &entity.ReprojectionReport{
+		Restore:        restore,
+		FromVersion:    version,
+		AppliedVersion: appliedVersion,
+		Skipped:        map[string]int{},
+		StartedAt:      time.Now(),
+	}
+	defer func() { report.Duration = time.Since(report.StartedAt) }()
+
+	requests, err := uc.InterceptedRequestRepository.GetAllFromLastVersion(version)
+	if err != nil {
+		return report, err
+	}
+	report.Requests = len(requests)
+	metrics.ReprojectionRequests.Set(float64(len(requests)))
+	metrics.ReprojectionReplayed.Set(0)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("reprojection.requests", len(requests)))
+
+	if progressRepository != nil {
+		progress, err := progressRepository.Get()
 		if err != nil {
-			return err
+			return report, err
+		}
+		switch {
+		case progress == nil:
+		case progress.Restore == restore && progress.FromVersion == version:
+			report.ResumedVersion = progress.ReplayedVersion
+			slog.Info("resuming interrupted reprojection", logging.KeyVersion, version, "replayed_version", progress.ReplayedVersion)
+		default:
+			// The progress of another restore, the container was reset since.
+			if err := progressRepository.Clear(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	var replayed []*entity.InterceptedRequest
+	for _, interceptedReq := range requests {
+		reason := ""
+		switch {
+		case interceptedReq.Version <= appliedVersion:
+			reason = "applied"
+		case interceptedReq.Version <= report.ResumedVersion:
+			reason = "resumed"
+		case !shouldReplay(interceptorConfig.ReplayPolicy(routes, replay.DefaultPolicy, interceptedReq.Request), interceptedReq.Request.Method):
+			slog.Debug("skipping request excluded from replay", logging.KeyRequestID, interceptedReq.ID, logging.KeyVersion, interceptedReq.Version)
+			reason = "policy"
+		default:
+			replayed = append(replayed, interceptedReq)
+			continue
 		}
+		report.Skipped[reason]++
+		metrics.ReprojectionSkipped.WithLabelValues(reason).Inc()
+	}
 
-		for key, values := range interceptedReq.Request.Header {
-			for _, value := range values {
-				reqCopy.Header.Add(key, value)
+	result, err := Replay(ctx, containerURL, replayed, ReplayOptions{
+		RateLimit:    replay.RateLimit,
+		Parallelism:  replay.Parallelism,
+		Timeout:      replay.Timeout,
+		Retries:      replay.Retries,
+		RetryBackoff: replay.RetryBackoff,
+		Timed:        replay.Timed,
+		Speed:        replay.Speed,
+		Commutative: func(req *entity.InterceptedRequest) bool {
+			return interceptorConfig.Commutative(routes, req.Request)
+		},
+		OnReplayed: func(req *entity.InterceptedRequest) error {
+			// The requests solved before keep their original timing, which timed
+			// replays reproduce.
+			if !req.Solved {
+				if err := uc.InterceptedRequestRepository.SetSolved(req.ID, time.Now(), true); err != nil {
+					return err
+				}
 			}
+			metrics.ReprojectionReplayed.Inc()
+			return nil
+		},
+		OnProgress: func(replayedVersion int) error {
+			if progressRepository == nil {
+				return nil
+			}
+			return progressRepository.Save(&entity.ReprojectionProgress{
+				Restore:         restore,
+				FromVersion:     version,
+				ReplayedVersion: replayedVersion,
+				UpdatedAt:       time.Now(),
+			})
+		},
+	})
+	report.ReplayResult = *result
+	if err != nil {
+		return report, err
+	}
+
+	if progressRepository != nil {
+		return report, progressRepository.Clear()
+	}
+	return report, nil
+}
+
+// ErrMigrationUnsupported is returned by the migration use cases when the services
+// they need, like the image store of the Interceptor, are not configured.
+var ErrMigrationUnsupported = errors.New("migrations are not supported")
+
+func (uc *interceptorUseCase) ExportCheckpoint(checkpointHash string, w io.Writer) error {
+	if uc.ImageStore == nil {
+		return ErrMigrationUnsupported
+	}
+	return uc.ImageStore.Export(checkpointHash, w)
+}
+
+func (uc *interceptorUseCase) ImportCheckpoint(checkpointHash string, r io.Reader) error {
+	if uc.ImageStore == nil {
+		return ErrMigrationUnsupported
+	}
+	return uc.ImageStore.Import(checkpointHash, r)
+}
+
+func (uc *interceptorUseCase) RestoreCheckpoint(ctx context.Context, checkpointHash string) error {
+	if uc.RestoreService == nil {
+		return ErrMigrationUnsupported
+	}
+
+	uc.Hold()
+	defer uc.Release()
+
+	// The progress of the reprojections after a previous restore no longer applies.
+	if uc.ProgressRepository != nil {
+		if err := uc.ProgressRepository.Clear(); err != nil {
+			return err
 		}
+	}
+
+	_, span := tracing.Start(ctx, "RestoreCheckpoint", attribute.String("checkpoint.hash", checkpointHash))
+	startedAt := time.Now()
+	err := uc.RestoreService.Restore(&entity.RestoreConfig{
+		ContainerName:  uc.Interceptor.MonitoredContainer.Name,
+		CheckpointHash: checkpointHash,
+	})
+	tracing.End(span, err)
+
+	logger := slog.With(logging.KeyContainer, uc.Interceptor.MonitoredContainer.Name, logging.KeyCheckpointHash, checkpointHash)
+	if err != nil {
+		logger.Error("restore failed", logging.Error(err))
+		return err
+	}
+	logger.Info("checkpoint restored", "duration", time.Since(startedAt))
+	return nil
+}
+
+// UpdateConfig applies the new configuration, updating the checkpoint policy when
+// the scheduling changes. Requests already being forwarded keep the previous
+// container URL.
+func (uc *interceptorUseCase) UpdateConfig(cfg *interceptorConfig.Config) error {
+	uc.ConfigMutex.Lock()
+	previous := uc.Interceptor.Config
+	updated := *previous
+	updated.CheckpointingInterval = cfg.CheckpointingInterval
+	updated.CheckpointEveryRequests = cfg.CheckpointEveryRequests
+	updated.CheckpointWriteBurstRequests = cfg.CheckpointWriteBurstRequests
+	updated.CheckpointWriteBurstQuietPeriod = cfg.CheckpointWriteBurstQuietPeriod
+	updated.CheckpointEventLogBudgetBytes = cfg.CheckpointEventLogBudgetBytes
+	updated.CheckpointCron = cfg.CheckpointCron
+	updated.BlackoutWindows = cfg.BlackoutWindows
+	updated.ContainerURL = cfg.ContainerURL
+	updated.MaxBodyCaptureBytes = cfg.MaxBodyCaptureBytes
+	updated.CheckpointRetention = cfg.CheckpointRetention
+	uc.Interceptor.Config = &updated
+	uc.Interceptor.MonitoredContainer.HTTPUrl = cfg.ContainerURL.String()
+	uc.ConfigMutex.Unlock()
+
+	if updated.ContainerURL != previous.ContainerURL {
+		slog.Info("monitored container URL changed", "url", updated.ContainerURL.String())
+	}
+	if updated.MaxBodyCaptureBytes != previous.MaxBodyCaptureBytes {
+		slog.Info("body capture limit changed", "bytes", updated.MaxBodyCaptureBytes)
+	}
+	if updated.CheckpointRetention != previous.CheckpointRetention {
+		slog.Info("checkpoint retention changed", "images", updated.CheckpointRetention)
+	}
+	if updated.CheckpointingInterval != previous.CheckpointingInterval ||
+		updated.CheckpointEveryRequests != previous.CheckpointEveryRequests ||
+		updated.CheckpointWriteBurstRequests != previous.CheckpointWriteBurstRequests ||
+		updated.CheckpointWriteBurstQuietPeriod != previous.CheckpointWriteBurstQuietPeriod ||
+		updated.CheckpointEventLogBudgetBytes != previous.CheckpointEventLogBudgetBytes ||
+		updated.CheckpointCron != previous.CheckpointCron ||
+		!reflect.DeepEqual(updated.BlackoutWindows, previous.BlackoutWindows) {
+		slog.Info("checkpoint scheduling changed", "interval", updated.CheckpointingInterval)
+		return uc.Scheduler.UpdateConfig(&updated)
+	}
+	return nil
+}
+
+// Status returns the current state of the Interceptor with its planned checkpoint.
+func (uc *interceptorUseCase) Status() (*entity.InterceptorStatus, error) {
+	uc.Mutex.Lock()
+	status := &entity.InterceptorStatus{
+		ID:                 uc.Interceptor.ID,
+		ContainerName:      uc.Interceptor.MonitoredContainer.Name,
+		LastVersion:        uc.LastVersion,
+		LastCheckpointHash: uc.LastCheckpointHash,
+	}
+	if !uc.LastCheckpoint.IsZero() {
+		lastCheckpoint := uc.LastCheckpoint
+		status.LastCheckpoint = &lastCheckpoint
+	}
+	if uc.LastCheckpointError != nil {
+		lastFailure := uc.LastCheckpointFailure
+		status.LastCheckpointError = uc.LastCheckpointError.Error()
+		status.LastCheckpointFailure = &lastFailure
+	}
+	uc.Mutex.Unlock()
+
+	if next, planned := uc.Scheduler.NextRun(); planned {
+		status.NextCheckpoint = &next
+	}
+	status.Holding, status.HeldRequests = uc.hold.status()
+	return status, nil
+}
+
+// Register sends the identity of the monitored container, the addresses and the
+// configuration of the Interceptor to the State Manager.
+func (uc *interceptorUseCase) Register(ctx context.Context) error {
+	uc.ConfigMutex.RLock()
+	registration := &entity.InterceptorRegistration{
+		InterceptorID: uc.Interceptor.ID,
+		AdminURL:      uc.Interceptor.AdminURL,
+		Container:     uc.Interceptor.MonitoredContainer.Identity(),
+		PID:           uc.Interceptor.MonitoredContainer.PID,
+		HTTPUrl:       uc.Interceptor.MonitoredContainer.HTTPUrl,
+	}
+	cfg := uc.Interceptor.Config
+	uc.ConfigMutex.RUnlock()
 
-		_, err = http.DefaultClient.Do(reqCopy)
+	if cfg != nil {
+		content, err := cfg.YAML()
 		if err != nil {
 			return err
 		}
+		registration.Config = string(content)
+	}
 
-		if err := uc.InterceptedRequestRepository.SetSolved(interceptedReq.ID, time.Now(), true); err != nil {
-			return err
+	return uc.StateManagerService.Register(ctx, registration)
+}
+
+// Heartbeat sends the last version and checkpoint to the State Manager. The State
+// Manager forgets the Interceptors when it restarts, so an unknown Interceptor
+// registers again.
+func (uc *interceptorUseCase) Heartbeat(ctx context.Context) error {
+	uc.Mutex.Lock()
+	heartbeat := &entity.Heartbeat{
+		InterceptorID:      uc.Interceptor.ID,
+		Container:          uc.Interceptor.MonitoredContainer.Identity(),
+		LastVersion:        uc.LastVersion,
+		LastCheckpointHash: uc.LastCheckpointHash,
+	}
+	if !uc.LastCheckpoint.IsZero() {
+		lastCheckpoint := uc.LastCheckpoint
+		heartbeat.LastCheckpoint = &lastCheckpoint
+	}
+	uc.Mutex.Unlock()
+
+	err := uc.StateManagerService.Heartbeat(ctx, heartbeat)
+	if !errors.Is(err, entity.ErrNotFound) {
+		return err
+	}
+
+	slog.Info("state manager does not know the interceptor, registering again", logging.KeyInterceptorID, heartbeat.InterceptorID)
+	if err := uc.Register(ctx); err != nil {
+		return err
+	}
+	return uc.StateManagerService.Heartbeat(ctx, heartbeat)
+}
+
+func (uc *interceptorUseCase) RunHeartbeats(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := uc.Heartbeat(ctx); err != nil {
+				slog.Warn("heartbeat failed", logging.KeyInterceptorID, uc.Interceptor.ID, logging.Error(err))
+			}
 		}
 	}
+}
 
-	return nil
+// Ready checks that no reprojection is running and that the monitored container
+// accepts connections.
+func (uc *interceptorUseCase) Ready(ctx context.Context) error {
+	if uc.Reprojections.Load() > 0 {
+		return ErrReprojectionInProgress
+	}
+
+	uc.ConfigMutex.RLock()
+	containerURL := uc.Interceptor.MonitoredContainer.HTTPUrl
+	uc.ConfigMutex.RUnlock()
+
+	address, err := dialAddress(containerURL)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("monitored container unreachable: %w", err)
+	}
+	return conn.Close()
+}
+
+// dialAddress returns the host and port to connect to the URL, with the default port
+// of its scheme when it has none.
+func dialAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+// forward sends the request to the container, identified as the intercepted request
+// when it is recorded.
+func forward(ctx context.Context, containerURL string, req *http.Request, body io.Reader, interceptedRequest *entity.InterceptedRequest) (*http.Response, error) {
+	// Create the URL to access the monitored URL from the monitored application URL
+	// and the content receive in the path of the intercepted request.
+	url := containerURL + req.URL.Path
+	reqCopy, err := http.NewRequestWithContext(ctx, req.Method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.Header {
+		for _, value := range values {
+			reqCopy.Header.Add(key, value)
+		}
+	}
+	if interceptedRequest != nil {
+		setIdempotencyHeaders(reqCopy.Header, interceptedRequest)
+	}
+	tracing.Inject(ctx, reqCopy.Header)
+
+	return http.DefaultClient.Do(reqCopy)
+}
+
+// Headers identifying the intercepted requests forwarded to the monitored container.
+// They are the same when the request is replayed, so the container can deduplicate
+// the requests it already applied.
+const (
+	HeaderRequestID      = "X-Interceptor-Request-Id"
+	HeaderRequestVersion = "X-Interceptor-Request-Version"
+)
+
+func setIdempotencyHeaders(header http.Header, interceptedRequest *entity.InterceptedRequest) {
+	header.Set(HeaderRequestID, interceptedRequest.ID)
+	header.Set(HeaderRequestVersion, strconv.Itoa(interceptedRequest.Version))
+}
+
+// shouldReplay tells whether the policy of its route replays a request of the
+// method. Requests without policy are replayed.
+func shouldReplay(policy string, method string) bool {
+	switch policy {
+	case interceptorConfig.ReplayNever:
+		return false
+	case interceptorConfig.ReplayIdempotent:
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// captureBody records up to maxBytes of the request body in the intercepted request
+// and returns the full body to forward.
+func captureBody(req *http.Request, maxBytes int64, interceptedRequest *entity.InterceptedRequest) (io.Reader, error) {
+	if req.Body == nil || req.Body == http.NoBody || maxBytes <= 0 {
+		return req.Body, nil
+	}
+
+	captured, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(captured)) > maxBytes {
+		interceptedRequest.Body = captured[:maxBytes]
+		interceptedRequest.BodyTruncated = true
+		return io.MultiReader(bytes.NewReader(captured), req.Body), nil
+	}
+
+	interceptedRequest.Body = captured
+	return bytes.NewReader(captured), nil
+}
+
+// isImageOf tells whether the checkpoint hash was generated for the container, the
+// images of other containers may share the images directory.
+func isImageOf(containerName string, checkpointHash string) bool {
+	hash, ok := strings.CutPrefix(checkpointHash, containerName+"-")
+	if !ok {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return hash != "" && err == nil
 }
 
 func (uc *interceptorUseCase) generateHashForNewImage(containerName string) string {
@@ -164,6 +952,8 @@ func (uc *interceptorUseCase) generateHashForNewImage(containerName string) stri
 	return fmt.Sprintf("%s-%s", containerName, hash)
 }
 
+// generateMetadataForNewImage returns the metadata of a checkpoint made while no
+// request is in flight, every request up to its last version was solved or failed.
 func (uc *interceptorUseCase) generateMetadataForNewImage() *entity.ContainerMetadata {
 	lastTimestamp := time.Now()
 	// TODO: add a logger to log the error?
@@ -174,8 +964,13 @@ func (uc *interceptorUseCase) generateMetadataForNewImage() *entity.ContainerMet
 		lastRequestSolvedID = lastRequestSolved.ID
 	}
 
+	uc.Mutex.Lock()
+	lastVersion := uc.LastVersion
+	uc.Mutex.Unlock()
+
 	return &entity.ContainerMetadata{
 		LastTimestamp:       lastTimestamp,
 		LastRequestSolvedID: lastRequestSolvedID,
+		LastVersion:         lastVersion,
 	}
 }
@@ -1,8 +1,14 @@
 package usecase
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,6 +16,8 @@ import (
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
 	mock_entity "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity/mock"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptedrequest"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/reprojectionprogress"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
 )
@@ -18,10 +26,20 @@ type fakeHandler struct{}
 
 type dummyScheduler struct{}
 
-func (s *dummyScheduler) ScheduleCheckpoint(usecase InterceptorUseCase, scheduleIn time.Duration) error {
+func (s *dummyScheduler) Run(ctx context.Context, usecase InterceptorUseCase) error {
 	return nil
 }
 
+func (s *dummyScheduler) ObserveRequest(req *entity.InterceptedRequest) {}
+
+func (s *dummyScheduler) UpdateConfig(cfg *interceptorConfig.Config) error {
+	return nil
+}
+
+func (s *dummyScheduler) NextRun() (time.Time, bool) {
+	return time.Time{}, false
+}
+
 func (h *fakeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -46,7 +64,7 @@ func TestInterceptRequest(t *testing.T) {
 				},
 			}
 			interceptedRequestRepository := interceptedrequest.InMemory()
-			useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, scheduler)
+			useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, scheduler, nil, nil, nil)
 
 			reqID := uuid.NewString()
 			useCase.InterceptRequest(reqID, req)
@@ -82,7 +100,7 @@ func TestInterceptRequest(t *testing.T) {
 				},
 			}
 			interceptedRequestRepository := interceptedrequest.InMemory()
-			useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, scheduler)
+			useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, scheduler, nil, nil, nil)
 			defer testServer.Close()
 
 			req := httptest.NewRequest(http.MethodGet, testServer.URL, nil)
@@ -128,7 +146,7 @@ func TestCheckpoint(t *testing.T) {
 	}
 
 	checkpointService.EXPECT().Checkpoint(gomock.Any()).Return(nil).Times(1)
-	stateManagerService.EXPECT().SaveMetadata(monitoredContainer.Name, gomock.Any()).Return(nil).Times(1)
+	stateManagerService.EXPECT().SaveMetadata(gomock.Any(), monitoredContainer.Identity(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
 	interceptor := entity.Interceptor{
 		ID:                    uuid.NewString(),
@@ -139,10 +157,849 @@ func TestCheckpoint(t *testing.T) {
 		},
 	}
 	interceptedRequestRepository := interceptedrequest.InMemory()
-	useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedRequestRepository, scheduler)
+	useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedRequestRepository, scheduler, nil, nil, nil)
 
 	err := useCase.Checkpoint()
 	if err != nil {
 		t.Errorf("expected error nil, received %v\n", err)
 	}
 }
+
+func TestCheckpointVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	checkpointService := mock_entity.NewMockCheckpointService(ctrl)
+	stateManagerService := mock_entity.NewMockStateManagerService(ctrl)
+
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	arrived, unblock := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(arrived)
+			<-unblock
+		}
+		record(r.URL.Path)
+	}))
+	defer server.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), Name: "test", HTTPUrl: server.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{},
+	}
+	useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+
+	t.Run("it should dump between the requests in flight and the new ones", func(t *testing.T) {
+		intercept := func(path string) chan error {
+			intercepted := make(chan error)
+			go func() {
+				_, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, "http://app"+path, nil))
+				intercepted <- err
+			}()
+			return intercepted
+		}
+		slow := intercept("/slow")
+		<-arrived
+
+		var metadata *entity.ContainerMetadata
+		checkpointService.EXPECT().Checkpoint(gomock.Any()).DoAndReturn(func(config *entity.CheckpointConfig) error {
+			record("dump")
+			return nil
+		})
+		stateManagerService.EXPECT().SaveMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, m *entity.ContainerMetadata) error {
+				metadata = m
+				return nil
+			})
+		checkpointed := make(chan error)
+		go func() { checkpointed <- useCase.Checkpoint() }()
+
+		select {
+		case <-checkpointed:
+			t.Fatalf("expected the checkpoint to wait for the request in flight\n")
+		case <-time.After(50 * time.Millisecond):
+		}
+		fast := intercept("/fast")
+		time.Sleep(50 * time.Millisecond)
+		close(unblock)
+		for _, done := range []chan error{slow, checkpointed, fast} {
+			if err := <-done; err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+		}
+
+		if strings.Join(events, ",") != "/slow,dump,/fast" {
+			t.Errorf("expected the new request after the dump, got %v\n", events)
+		}
+		if metadata == nil || metadata.LastVersion != 1 {
+			t.Errorf("expected the last version 1 in the metadata, got %+v\n", metadata)
+		}
+	})
+}
+
+type memoryImageStore struct {
+	images map[string]time.Time
+}
+
+func (s *memoryImageStore) Export(checkpointHash string, w io.Writer) error {
+	return nil
+}
+
+func (s *memoryImageStore) Import(checkpointHash string, r io.Reader) error {
+	s.images[checkpointHash] = time.Now()
+	return nil
+}
+
+func (s *memoryImageStore) List() ([]entity.CheckpointImage, error) {
+	var images []entity.CheckpointImage
+	for hash, createdAt := range s.images {
+		images = append(images, entity.CheckpointImage{Hash: hash, CreatedAt: createdAt})
+	}
+	return images, nil
+}
+
+func (s *memoryImageStore) Delete(checkpointHash string) error {
+	delete(s.images, checkpointHash)
+	return nil
+}
+
+func TestCheckpointRetention(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	checkpointService := mock_entity.NewMockCheckpointService(ctrl)
+	stateManagerService := mock_entity.NewMockStateManagerService(ctrl)
+	now := time.Now()
+	imageStore := &memoryImageStore{images: map[string]time.Time{
+		"test-0000000000000003": now.Add(-3 * time.Hour),
+		"test-0000000000000002": now.Add(-2 * time.Hour),
+		"test-0000000000000001": now.Add(-time.Hour),
+		"test-other-0000000001": now.Add(-4 * time.Hour),
+		"other-000000000000001": now.Add(-4 * time.Hour),
+	}}
+	checkpointService.EXPECT().Checkpoint(gomock.Any()).DoAndReturn(func(config *entity.CheckpointConfig) error {
+		imageStore.images[config.CheckpointHash] = time.Now()
+		return nil
+	}).Times(2)
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), Name: "test"}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{CheckpointRetention: 2},
+	}
+	useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedrequest.InMemory(), &dummyScheduler{}, nil, imageStore, nil)
+
+	var queued *entity.CheckpointResult
+	t.Run("it should keep the images while the metadata is not acknowledged", func(t *testing.T) {
+		stateManagerService.EXPECT().SaveMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(entity.ErrMetadataQueued)
+		result, err := useCase.CreateCheckpoint(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		queued = result
+		if len(imageStore.images) != 6 {
+			t.Errorf("expected 6 images left, got %v\n", imageStore.images)
+		}
+	})
+
+	t.Run("it should delete the oldest images of the container beyond the retention", func(t *testing.T) {
+		stateManagerService.EXPECT().SaveMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+		time.Sleep(time.Millisecond)
+		result, err := useCase.CreateCheckpoint(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		for _, hash := range []string{result.CheckpointHash, queued.CheckpointHash, "test-other-0000000001", "other-000000000000001"} {
+			if _, ok := imageStore.images[hash]; !ok {
+				t.Errorf("expected image %s to be kept\n", hash)
+			}
+		}
+		if len(imageStore.images) != 4 {
+			t.Errorf("expected 4 images left, got %v\n", imageStore.images)
+		}
+	})
+}
+
+type recordingScheduler struct {
+	dummyScheduler
+	intervals []time.Duration
+}
+
+func (s *recordingScheduler) UpdateConfig(cfg *interceptorConfig.Config) error {
+	s.intervals = append(s.intervals, cfg.CheckpointingInterval)
+	return nil
+}
+
+func TestUpdateConfig(t *testing.T) {
+	var firstCalls, secondCalls int
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { firstCalls++ }))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { secondCalls++ }))
+	defer second.Close()
+
+	firstURL, _ := url.Parse(first.URL)
+	secondURL, _ := url.Parse(second.URL)
+	monitoredContainer := entity.Container{
+		ID:      uuid.NewString(),
+		HTTPUrl: first.URL,
+	}
+	interceptor := entity.Interceptor{
+		ID:                    uuid.NewString(),
+		MonitoringContainerID: monitoredContainer.ID,
+		MonitoredContainer:    &monitoredContainer,
+		Config: &interceptorConfig.Config{
+			CheckpointingInterval: time.Duration(time.Minute * 5),
+			ContainerURL:          *firstURL,
+			MaxBodyCaptureBytes:   4,
+		},
+	}
+	scheduler := &recordingScheduler{}
+	interceptedRequestRepository := interceptedrequest.InMemory()
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, scheduler, nil, nil, nil)
+
+	t.Run("it should truncate bodies larger than the capture limit", func(t *testing.T) {
+		reqID := uuid.NewString()
+		req := httptest.NewRequest(http.MethodPost, first.URL, strings.NewReader("0123456789"))
+		if _, err := useCase.InterceptRequest(reqID, req); err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		requests, _ := interceptedRequestRepository.GetAll()
+		if string(requests[0].Body) != "0123" || !requests[0].BodyTruncated {
+			t.Errorf("expected truncated body %q, got %q\n", "0123", requests[0].Body)
+		}
+	})
+
+	t.Run("it should forward requests to the new container URL", func(t *testing.T) {
+		err := useCase.UpdateConfig(&interceptorConfig.Config{
+			CheckpointingInterval: time.Duration(time.Minute * 10),
+			ContainerURL:          *secondURL,
+			MaxBodyCaptureBytes:   4,
+		})
+		if err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, second.URL, nil)
+		if _, err := useCase.InterceptRequest(uuid.NewString(), req); err != nil {
+			t.Fatalf("expected error nil, received %v\n", err)
+		}
+		if firstCalls != 1 || secondCalls != 1 {
+			t.Errorf("expected one request to each container, got %d and %d\n", firstCalls, secondCalls)
+		}
+	})
+
+	t.Run("it should reschedule checkpoints with the new interval", func(t *testing.T) {
+		if len(scheduler.intervals) != 1 || scheduler.intervals[0] != time.Minute*10 {
+			t.Errorf("expected checkpoint rescheduled in 10m, got %v\n", scheduler.intervals)
+		}
+	})
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracing.InMemory("interceptor-test")
+
+	t.Run("it should trace the intercepted request and propagate the trace upstream", func(t *testing.T) {
+		exporter.Reset()
+		var traceparent string
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+		}))
+		defer testServer.Close()
+
+		monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: testServer.URL}
+		interceptor := entity.Interceptor{
+			ID:                 uuid.NewString(),
+			MonitoredContainer: &monitoredContainer,
+			Config:             &interceptorConfig.Config{},
+		}
+		useCase, _ := Interceptor(&interceptor, nil, nil, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodGet, testServer.URL, nil)); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 || spans[0].Name != "InterceptRequest" {
+			t.Fatalf("expected an InterceptRequest span, got %v\n", spans)
+		}
+		versionRecorded := false
+		for _, attr := range spans[0].Attributes {
+			if attr.Key == "request.version" && attr.Value.AsInt64() == 1 {
+				versionRecorded = true
+			}
+		}
+		if !versionRecorded {
+			t.Errorf("expected request.version attribute 1, got %v\n", spans[0].Attributes)
+		}
+		if !strings.Contains(traceparent, spans[0].SpanContext.TraceID().String()) {
+			t.Errorf("expected upstream traceparent with trace %s, got %q\n", spans[0].SpanContext.TraceID(), traceparent)
+		}
+	})
+
+	t.Run("it should trace each checkpoint phase", func(t *testing.T) {
+		exporter.Reset()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		checkpointService := mock_entity.NewMockCheckpointService(ctrl)
+		stateManagerService := mock_entity.NewMockStateManagerService(ctrl)
+		stateManagerService.EXPECT().SaveMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+		checkpointService.EXPECT().Checkpoint(gomock.Any()).Return(nil)
+
+		monitoredContainer := entity.Container{ID: uuid.NewString(), Name: "test"}
+		interceptor := entity.Interceptor{
+			ID:                 uuid.NewString(),
+			MonitoredContainer: &monitoredContainer,
+			Config:             &interceptorConfig.Config{},
+		}
+		useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+		if err := useCase.Checkpoint(); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		names := map[string]bool{}
+		for _, span := range exporter.GetSpans() {
+			names[span.Name] = true
+		}
+		for _, name := range []string{"Checkpoint", "Checkpoint.SaveMetadata", "Checkpoint.Dump"} {
+			if !names[name] {
+				t.Errorf("expected span %q, got %v\n", name, names)
+			}
+		}
+	})
+}
+
+func TestCheckpointErrorInStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	checkpointService := mock_entity.NewMockCheckpointService(ctrl)
+	stateManagerService := mock_entity.NewMockStateManagerService(ctrl)
+	stateManagerService.EXPECT().SaveMetadata(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), Name: "test"}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{},
+	}
+	useCase, _ := Interceptor(&interceptor, checkpointService, stateManagerService, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+
+	t.Run("it should report the error of a failed checkpoint without saving its metadata", func(t *testing.T) {
+		checkpointService.EXPECT().Checkpoint(gomock.Any()).Return(errors.New("criu dump failed"))
+		useCase.Checkpoint()
+
+		status, _ := useCase.Status()
+		if status.LastCheckpointError != "criu dump failed" || status.LastCheckpointFailure == nil {
+			t.Errorf("expected the checkpoint error in status, got %+v\n", status)
+		}
+	})
+
+	t.Run("it should clear the error once a checkpoint succeeds", func(t *testing.T) {
+		checkpointService.EXPECT().Checkpoint(gomock.Any()).Return(nil)
+		useCase.Checkpoint()
+
+		status, _ := useCase.Status()
+		if status.LastCheckpointError != "" || status.LastCheckpoint == nil {
+			t.Errorf("expected a successful checkpoint in status, got %+v\n", status)
+		}
+	})
+}
+
+func TestReady(t *testing.T) {
+	testServer := httptest.NewServer(&fakeHandler{})
+	defer testServer.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: testServer.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{},
+	}
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+
+	t.Run("it should be ready when the monitored container accepts connections", func(t *testing.T) {
+		if err := useCase.Ready(context.Background()); err != nil {
+			t.Errorf("expected to be ready, got %v\n", err)
+		}
+	})
+
+	t.Run("it should not be ready during a reprojection", func(t *testing.T) {
+		useCase.(*interceptorUseCase).Reprojections.Add(1)
+		defer useCase.(*interceptorUseCase).Reprojections.Add(-1)
+		if err := useCase.Ready(context.Background()); !errors.Is(err, ErrReprojectionInProgress) {
+			t.Errorf("expected ErrReprojectionInProgress, got %v\n", err)
+		}
+	})
+
+	t.Run("it should not be ready when the monitored container is unreachable", func(t *testing.T) {
+		closedServer := httptest.NewServer(&fakeHandler{})
+		closedServer.Close()
+		monitoredContainer.HTTPUrl = closedServer.URL
+		if err := useCase.Ready(context.Background()); err == nil {
+			t.Error("expected an error for an unreachable container")
+		}
+	})
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stateManagerService := mock_entity.NewMockStateManagerService(ctrl)
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), Name: "test", Namespace: "default", Pod: "app-0"}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{},
+	}
+	useCase, _ := Interceptor(&interceptor, nil, stateManagerService, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+
+	t.Run("it should send the last version", func(t *testing.T) {
+		stateManagerService.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, heartbeat *entity.Heartbeat) error {
+			if heartbeat.InterceptorID != interceptor.ID || heartbeat.Container != monitoredContainer.Identity() {
+				t.Errorf("expected the heartbeat of the interceptor, got %+v\n", heartbeat)
+			}
+			return nil
+		})
+		if err := useCase.Heartbeat(context.Background()); err != nil {
+			t.Errorf("expected no error, got %v\n", err)
+		}
+	})
+
+	t.Run("it should register again when the state manager does not know it", func(t *testing.T) {
+		gomock.InOrder(
+			stateManagerService.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(entity.ErrNotFound),
+			stateManagerService.EXPECT().Register(gomock.Any(), gomock.Any()).Return(nil),
+			stateManagerService.EXPECT().Heartbeat(gomock.Any(), gomock.Any()).Return(nil),
+		)
+		if err := useCase.Heartbeat(context.Background()); err != nil {
+			t.Errorf("expected no error, got %v\n", err)
+		}
+	})
+}
+
+func TestSwitchUpstream(t *testing.T) {
+	var sourceCalls, targetCalls []string
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { sourceCalls = append(sourceCalls, r.URL.Path) }))
+	defer source.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { targetCalls = append(targetCalls, r.URL.Path) }))
+	defer target.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: source.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config:             &interceptorConfig.Config{MaxBodyCaptureBytes: 1024},
+	}
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+	for _, path := range []string{"/checkpointed", "/after-checkpoint"} {
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, source.URL+path, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should replay the requests from the version to the new upstream", func(t *testing.T) {
+		if err := useCase.SwitchUpstream(context.Background(), 2, target.URL); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if len(targetCalls) != 1 || targetCalls[0] != "/after-checkpoint" {
+			t.Errorf("expected the request after the checkpoint replayed, got %v\n", targetCalls)
+		}
+	})
+
+	t.Run("it should forward the new requests to the new upstream", func(t *testing.T) {
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodGet, source.URL+"/after-switch", nil)); err != nil {
+			t.Fatal(err)
+		}
+		if len(sourceCalls) != 2 || len(targetCalls) != 2 || targetCalls[1] != "/after-switch" {
+			t.Errorf("expected the new request forwarded to the target, got %v and %v\n", sourceCalls, targetCalls)
+		}
+	})
+
+	t.Run("it should hold the new requests while replaying without blocking the others", func(t *testing.T) {
+		var mutex sync.Mutex
+		var calls []string
+		replaying := make(chan struct{})
+		unblock := make(chan struct{})
+		next := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/after-switch" {
+				close(replaying)
+				<-unblock
+			}
+			mutex.Lock()
+			calls = append(calls, r.URL.Path)
+			mutex.Unlock()
+		}))
+		defer next.Close()
+		interceptor.Config.Holding = interceptorConfig.HoldingConfig{MaxRequests: 1, MaxBytes: 1024, MaxWait: 5 * time.Second, Timeout: time.Minute}
+
+		switched := make(chan error)
+		go func() { switched <- useCase.SwitchUpstream(context.Background(), 3, next.URL) }()
+		<-replaying
+
+		held := make(chan error)
+		go func() {
+			_, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, source.URL+"/during-switch", nil))
+			held <- err
+		}()
+		waitForHold(t, useCase, true, 1)
+		if err := useCase.Ready(context.Background()); !errors.Is(err, ErrReprojectionInProgress) {
+			t.Errorf("expected ErrReprojectionInProgress, got %v\n", err)
+		}
+
+		close(unblock)
+		if err := <-switched; err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if err := <-held; err != nil {
+			t.Fatalf("expected the held request forwarded, got %v\n", err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.Join(calls, " ") != "/after-switch /during-switch" {
+			t.Errorf("expected the held request forwarded to the new upstream after the replayed one, got %v\n", calls)
+		}
+	})
+
+	t.Run("it should reject a relative upstream", func(t *testing.T) {
+		if err := useCase.SwitchUpstream(context.Background(), 1, "/target"); err == nil {
+			t.Error("expected an error for a relative upstream")
+		}
+	})
+
+	t.Run("it should refuse to export images without an image store", func(t *testing.T) {
+		if err := useCase.ExportCheckpoint("test-0123", io.Discard); !errors.Is(err, ErrMigrationUnsupported) {
+			t.Errorf("expected ErrMigrationUnsupported, got %v\n", err)
+		}
+	})
+}
+
+func TestHold(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls = append(calls, r.URL.Path)
+		mutex.Unlock()
+	}))
+	defer upstream.Close()
+	received := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), calls...)
+	}
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: upstream.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config: &interceptorConfig.Config{
+			MaxBodyCaptureBytes: 1024,
+			Holding: interceptorConfig.HoldingConfig{
+				MaxRequests: 1,
+				MaxBytes:    1024,
+				MaxWait:     5 * time.Second,
+				Timeout:     time.Minute,
+			},
+		},
+	}
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, nil)
+	if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, upstream.URL+"/before-restore", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it should forward the held requests after the reprojected ones", func(t *testing.T) {
+		useCase.Hold()
+		held := make(chan error)
+		go func() {
+			_, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, upstream.URL+"/during-restore", nil))
+			held <- err
+		}()
+		waitForHold(t, useCase, true, 1)
+
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, upstream.URL+"/over-limit", nil)); !errors.Is(err, ErrHoldLimitExceeded) {
+			t.Errorf("expected ErrHoldLimitExceeded, got %v\n", err)
+		}
+		if len(received()) != 1 {
+			t.Errorf("expected no request forwarded while holding, got %v\n", received())
+		}
+
+		if _, err := useCase.Reproject(context.Background(), 1, 0, ""); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if err := <-held; err != nil {
+			t.Errorf("expected the held request to be forwarded, got %v\n", err)
+		}
+		if calls := received(); strings.Join(calls, " ") != "/before-restore /before-restore /during-restore" {
+			t.Errorf("expected the held request after the reprojected one, got %v\n", calls)
+		}
+		waitForHold(t, useCase, false, 0)
+	})
+
+	t.Run("it should refuse requests held for longer than the maximum wait", func(t *testing.T) {
+		interceptor.Config.Holding.MaxWait = 10 * time.Millisecond
+		useCase.Hold()
+		defer useCase.Release()
+
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodGet, upstream.URL+"/too-late", nil)); !errors.Is(err, ErrHoldTimeout) {
+			t.Errorf("expected ErrHoldTimeout, got %v\n", err)
+		}
+		if status, _ := useCase.Status(); status.HeldRequests != 0 {
+			t.Errorf("expected the refused request out of the hold, got %+v\n", status)
+		}
+	})
+}
+
+// waitForHold waits for the hold to reach the state, as the held requests are
+// dispatched in the background.
+func waitForHold(t *testing.T, useCase InterceptorUseCase, holding bool, held int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status, _ := useCase.Status(); status.Holding == holding && status.HeldRequests == held {
+			return
+		}
+	}
+	status, _ := useCase.Status()
+	t.Fatalf("expected holding %v with %d held requests, got %+v\n", holding, held, status)
+}
+
+func TestReproject(t *testing.T) {
+	var mutex sync.Mutex
+	var replayed []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		replayed = append(replayed, r.Method+" "+r.URL.Path+" "+r.Header.Get(HeaderRequestVersion))
+	}))
+	defer upstream.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: upstream.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config: &interceptorConfig.Config{
+			MaxBodyCaptureBytes: 1024,
+			Routes: []interceptorConfig.RouteRule{
+				{Path: "/payments/**", Replay: interceptorConfig.ReplayNever},
+				{Path: "/orders/**", Replay: interceptorConfig.ReplayIdempotent},
+			},
+			Replay: interceptorConfig.ReplayConfig{
+				SkipApplied:   true,
+				DefaultPolicy: interceptorConfig.ReplayAlways,
+			},
+		},
+	}
+	interceptedRequestRepository := interceptedrequest.InMemory()
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, &dummyScheduler{}, nil, nil, nil)
+	for _, request := range []struct{ method, path string }{
+		{http.MethodPost, "/applied"},
+		{http.MethodPost, "/payments"},
+		{http.MethodPost, "/orders"},
+		{http.MethodPut, "/orders/1"},
+		{http.MethodPost, "/events"},
+	} {
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(request.method, upstream.URL+request.path, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should send the identity of the request upstream", func(t *testing.T) {
+		if replayed[0] != "POST /applied 1" {
+			t.Errorf("expected the version header on the forwarded request, got %q\n", replayed[0])
+		}
+	})
+
+	t.Run("it should only replay the requests allowed by their route", func(t *testing.T) {
+		replayed = nil
+		if _, err := useCase.Reproject(context.Background(), 2, 0, ""); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		expected := "PUT /orders/1 4,POST /events 5"
+		if strings.Join(replayed, ",") != expected {
+			t.Errorf("expected the replayed requests %q, got %q\n", expected, strings.Join(replayed, ","))
+		}
+	})
+
+	t.Run("it should skip the requests up to an applied version above the last version of the checkpoint", func(t *testing.T) {
+		// The checkpoint was taken at version 1 but the container reports the requests
+		// up to version 4 as applied.
+		replayed = nil
+		report, err := useCase.Reproject(context.Background(), 2, 4, "")
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if strings.Join(replayed, ",") != "POST /events 5" {
+			t.Errorf("expected only the request after the applied version replayed, got %q\n", strings.Join(replayed, ","))
+		}
+		if report.AppliedVersion != 4 || report.Skipped["applied"] != 3 {
+			t.Errorf("expected 3 requests skipped as applied, got %+v\n", report)
+		}
+	})
+
+	t.Run("it should replay the applied requests when not skipping them", func(t *testing.T) {
+		interceptor.Config.Replay.SkipApplied = false
+		defer func() { interceptor.Config.Replay.SkipApplied = true }()
+		replayed = nil
+		report, err := useCase.Reproject(context.Background(), 2, 4, "")
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		expected := "PUT /orders/1 4,POST /events 5"
+		if strings.Join(replayed, ",") != expected {
+			t.Errorf("expected the replayed requests %q, got %q\n", expected, strings.Join(replayed, ","))
+		}
+		if report.AppliedVersion != 0 || report.Skipped["applied"] != 0 {
+			t.Errorf("expected no request skipped as applied, got %+v\n", report)
+		}
+	})
+}
+
+func TestRouteRules(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, r.Method+" "+r.URL.Path+" "+r.Header.Get(HeaderRequestVersion))
+	}))
+	defer upstream.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: upstream.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config: &interceptorConfig.Config{
+			MaxBodyCaptureBytes: 1024,
+			Routes: []interceptorConfig.RouteRule{
+				{Path: "/health", Action: interceptorConfig.RoutePassthrough},
+				{Methods: []string{http.MethodPost}, Path: "/reports/**", Action: interceptorConfig.RouteRecordNoReplay},
+			},
+			Replay: interceptorConfig.ReplayConfig{DefaultPolicy: interceptorConfig.ReplayAlways},
+		},
+	}
+	interceptedRequestRepository := interceptedrequest.InMemory()
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedRequestRepository, &dummyScheduler{}, nil, nil, nil)
+	for _, request := range []struct{ method, path string }{
+		{http.MethodPost, "/orders"},
+		{http.MethodGet, "/health"},
+		{http.MethodPost, "/reports/daily"},
+	} {
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(request.method, upstream.URL+request.path, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should pass requests through without recording them", func(t *testing.T) {
+		if received[1] != "GET /health " {
+			t.Errorf("expected the request passed through without a version, got %q\n", received[1])
+		}
+		version, _ := interceptedRequestRepository.GetLastVersion()
+		if version != 2 {
+			t.Errorf("expected 2 recorded requests, got %d\n", version)
+		}
+	})
+
+	t.Run("it should not replay the requests recorded without replay", func(t *testing.T) {
+		received = nil
+		if _, err := useCase.Reproject(context.Background(), 1, 0, ""); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		expected := "POST /orders 1"
+		if strings.Join(received, ",") != expected {
+			t.Errorf("expected the replayed requests %q, got %q\n", expected, strings.Join(received, ","))
+		}
+	})
+}
+
+func TestReprojectResume(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	failing := "3"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get(HeaderRequestVersion) == failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, r.Header.Get(HeaderRequestVersion))
+	}))
+	defer upstream.Close()
+
+	monitoredContainer := entity.Container{ID: uuid.NewString(), HTTPUrl: upstream.URL}
+	interceptor := entity.Interceptor{
+		ID:                 uuid.NewString(),
+		MonitoredContainer: &monitoredContainer,
+		Config: &interceptorConfig.Config{
+			MaxBodyCaptureBytes: 1024,
+			Replay:              interceptorConfig.ReplayConfig{DefaultPolicy: interceptorConfig.ReplayAlways, Parallelism: 1},
+		},
+	}
+	progressRepository := reprojectionprogress.InMemory()
+	useCase, _ := Interceptor(&interceptor, nil, nil, interceptedrequest.InMemory(), &dummyScheduler{}, nil, nil, progressRepository)
+	for i := 0; i < 5; i++ {
+		if _, err := useCase.InterceptRequest(uuid.NewString(), httptest.NewRequest(http.MethodPost, upstream.URL+"/orders", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("it should save the progress of an interrupted reprojection", func(t *testing.T) {
+		received = nil
+		report, err := useCase.Reproject(context.Background(), 1, 0, "first")
+		if err == nil {
+			t.Fatal("expected the reprojection to fail")
+		}
+		if report.LastReplayedVersion != 2 || len(report.Failures) != 1 {
+			t.Errorf("expected the report of the failure after version 2, got %+v\n", report)
+		}
+		progress, _ := progressRepository.Get()
+		if progress == nil || progress.Restore != "first" || progress.FromVersion != 1 || progress.ReplayedVersion != 2 {
+			t.Errorf("expected the progress up to version 2, got %+v\n", progress)
+		}
+	})
+
+	t.Run("it should resume after the last replayed version of the same restore", func(t *testing.T) {
+		failing = ""
+		received = nil
+		report, err := useCase.Reproject(context.Background(), 1, 0, "first")
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if strings.Join(received, ",") != "3,4,5" {
+			t.Errorf("expected the versions 3 to 5 replayed, got %v\n", received)
+		}
+		if report.ResumedVersion != 2 || report.Skipped["resumed"] != 2 || report.Replayed != 3 {
+			t.Errorf("expected a resumed reprojection, got %+v\n", report)
+		}
+		if progress, _ := progressRepository.Get(); progress != nil {
+			t.Errorf("expected the progress cleared, got %+v\n", progress)
+		}
+	})
+
+	t.Run("it should replay every request once the container is restored again", func(t *testing.T) {
+		failing = "3"
+		if _, err := useCase.Reproject(context.Background(), 1, 0, "second"); err == nil {
+			t.Fatal("expected the reprojection to fail")
+		}
+
+		failing = ""
+		received = nil
+		report, err := useCase.Reproject(context.Background(), 1, 0, "third")
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if strings.Join(received, ",") != "1,2,3,4,5" {
+			t.Errorf("expected the versions 1 to 5 replayed, got %v\n", received)
+		}
+		if report.ResumedVersion != 0 || report.Replayed != 5 {
+			t.Errorf("expected a reprojection from the start, got %+v\n", report)
+		}
+	})
+}
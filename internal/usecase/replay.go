@@ -0,0 +1,307 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"golang.org/x/time/rate"
+)
+
+// ReplayOptions are the options of Replay.
+type ReplayOptions struct {
+	// RateLimit is the maximum number of requests sent per second, unlimited when zero.
+	RateLimit float64
+	// Parallelism is the maximum number of commutative requests sent at once.
+	Parallelism int
+	// Timeout bounds each attempt to send a request, unbounded when zero.
+	Timeout time.Duration
+	// Retries is the number of times a request is sent again when the target could not
+	// be reached or answered 502, 503 or 504.
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled on each of the next.
+	RetryBackoff time.Duration
+	// Timed sends the requests with their original inter-arrival timing, and sends a
+	// request along with the ones it was originally processed concurrently with.
+	// Parallelism and Commutative do not apply.
+	Timed bool
+	// Speed divides the original delays between the requests of timed replays, they
+	// are kept when zero.
+	Speed float64
+	// Commutative tells whether the request can be sent along with the commutative
+	// requests next to it, the others are sent alone. No request is commutative when
+	// nil.
+	Commutative func(req *entity.InterceptedRequest) bool
+	// OnReplayed is called once the request is replayed.
+	OnReplayed func(req *entity.InterceptedRequest) error
+	// OnProgress is called with the version up to which every request was replayed,
+	// each time it moves forward.
+	OnProgress func(version int) error
+}
+
+// Replay sends the requests to the target URL in their order, which is the version
+// order. Consecutive commutative requests are sent in parallel, so a request that is
+// not commutative is only sent once the ones before it were replayed, and before the
+// ones after it. Timed replays follow the original timing instead. The replay stops
+// at the first request failing after its retries, the requests already sent still
+// complete.
+func Replay(ctx context.Context, targetURL string, requests []*entity.InterceptedRequest, options ReplayOptions) (*entity.ReplayResult, error) {
+	limit := rate.Inf
+	if options.RateLimit > 0 {
+		limit = rate.Limit(options.RateLimit)
+	}
+	r := &replay{
+		targetURL: targetURL,
+		requests:  requests,
+		options:   options,
+		limiter:   rate.NewLimiter(limit, 1),
+		replayed:  make([]bool, len(requests)),
+		result:    &entity.ReplayResult{},
+	}
+	if options.Timed {
+		r.runTimed(ctx)
+	} else {
+		r.runInOrder(ctx)
+	}
+
+	if r.err == nil && ctx.Err() != nil {
+		r.err = ctx.Err()
+	}
+	return r.result, r.err
+}
+
+// replay is the state of a Replay.
+type replay struct {
+	targetURL string
+	requests  []*entity.InterceptedRequest
+	options   ReplayOptions
+	limiter   *rate.Limiter
+
+	// mutex guards the fields below.
+	mutex sync.Mutex
+	// replayed tells which requests were replayed.
+	replayed []bool
+	// next is the index of the first request not replayed.
+	next   int
+	result *entity.ReplayResult
+	// err is the first failure, which stops the replay.
+	err error
+}
+
+// runInOrder sends the requests in version order, the consecutive commutative ones
+// in parallel.
+func (r *replay) runInOrder(ctx context.Context) {
+	slots := make(chan struct{}, max(r.options.Parallelism, 1))
+	var wg sync.WaitGroup
+	for i, req := range r.requests {
+		commutative := r.options.Parallelism > 1 && r.options.Commutative != nil && r.options.Commutative(req)
+		if !commutative {
+			wg.Wait()
+		}
+		if r.failed() || ctx.Err() != nil {
+			break
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, req *entity.InterceptedRequest) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.replay(ctx, i, req)
+		}(i, req)
+
+		if !commutative {
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+}
+
+// runTimed sends each request after the delay that originally separated it from the
+// previous one, scaled by the speed. A request is sent once the requests solved
+// before it was received are replayed, so the ones that overlapped in time are
+// replayed concurrently and the others in version order.
+func (r *replay) runTimed(ctx context.Context) {
+	speed := r.options.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	// The original times are read before replaying, which solves the requests.
+	solved := make([]time.Time, len(r.requests))
+	for i, req := range r.requests {
+		solved[i] = solvedAt(req)
+	}
+
+	done := make([]chan struct{}, len(r.requests))
+	// sending are the requests sent the next ones may have to wait for.
+	var sending []int
+	var sentAt time.Time
+	var wg sync.WaitGroup
+	for i, req := range r.requests {
+		if i > 0 {
+			delay := time.Duration(float64(req.ReceivedAt.Sub(r.requests[i-1].ReceivedAt))/speed) - time.Since(sentAt)
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		// The requests solved when this one was received did not overlap with it, and
+		// neither do they with the next ones, which were received later.
+		overlapping := sending[:0]
+		for _, j := range sending {
+			if solved[j].After(req.ReceivedAt) {
+				overlapping = append(overlapping, j)
+				continue
+			}
+			select {
+			case <-done[j]:
+			case <-ctx.Done():
+			}
+		}
+		sending = overlapping
+		if r.failed() || ctx.Err() != nil {
+			break
+		}
+
+		done[i] = make(chan struct{})
+		sentAt = time.Now()
+		wg.Add(1)
+		go func(i int, req *entity.InterceptedRequest) {
+			defer wg.Done()
+			defer close(done[i])
+			r.replay(ctx, i, req)
+		}(i, req)
+		sending = append(sending, i)
+	}
+	wg.Wait()
+}
+
+// solvedAt returns when the request was originally solved, when it was received if
+// it never was.
+func solvedAt(req *entity.InterceptedRequest) time.Time {
+	if req.SolvedAt != nil && req.SolvedAt.After(req.ReceivedAt) {
+		return *req.SolvedAt
+	}
+	return req.ReceivedAt
+}
+
+func (r *replay) failed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err != nil
+}
+
+// replay sends the request until it succeeds or runs out of retries, and records the
+// outcome.
+func (r *replay) replay(ctx context.Context, i int, req *entity.InterceptedRequest) {
+	if req.BodyTruncated {
+		slog.Warn("replaying request with a truncated body",
+			logging.KeyRequestID, req.ID,
+			logging.KeyVersion, req.Version,
+			"body_bytes", len(req.Body),
+		)
+	}
+
+	backoff := r.options.RetryBackoff
+	attempts, statusCode := 0, 0
+	var err error
+	for {
+		attempts++
+		statusCode, err = r.send(ctx, req)
+		if err == nil || attempts > r.options.Retries || ctx.Err() != nil {
+			break
+		}
+
+		slog.Warn("retrying replayed request", logging.KeyRequestID, req.ID, logging.KeyVersion, req.Version, "attempt", attempts, logging.Error(err))
+		r.mutex.Lock()
+		r.result.Retries++
+		r.mutex.Unlock()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err == nil {
+		err = r.completed(i, req)
+	}
+	if err != nil {
+		r.result.Failures = append(r.result.Failures, entity.ReplayFailure{
+			RequestID:  req.ID,
+			Version:    req.Version,
+			Attempts:   attempts,
+			StatusCode: statusCode,
+			Error:      err.Error(),
+		})
+		if r.err == nil {
+			r.err = fmt.Errorf("replaying request %s of version %d: %w", req.ID, req.Version, err)
+		}
+	}
+}
+
+// errRetryableStatus is the failure of an attempt answered by a gateway error, the
+// target is likely not ready yet.
+var errRetryableStatus = errors.New("target is unavailable")
+
+// send sends the request once, within the timeout of an attempt.
+func (r *replay) send(ctx context.Context, req *entity.InterceptedRequest) (int, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+
+	res, err := forward(ctx, r.targetURL, req.Request, bytes.NewReader(req.Body), req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return res.StatusCode, fmt.Errorf("%w: status %d", errRetryableStatus, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// completed records the request as replayed and moves the progress forward past the
+// replayed requests. It is called with the mutex held.
+func (r *replay) completed(i int, req *entity.InterceptedRequest) error {
+	if r.options.OnReplayed != nil {
+		if err := r.options.OnReplayed(req); err != nil {
+			return err
+		}
+	}
+	r.replayed[i] = true
+	r.result.Replayed++
+
+	next := r.next
+	for next < len(r.replayed) && r.replayed[next] {
+		next++
+	}
+	if next == r.next {
+		return nil
+	}
+	r.next = next
+	r.result.LastReplayedVersion = r.requests[next-1].Version
+	if r.options.OnProgress != nil {
+		return r.options.OnProgress(r.result.LastReplayedVersion)
+	}
+	return nil
+}
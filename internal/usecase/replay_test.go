@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/google/uuid"
+)
+
+// interceptedRequests returns a request to each path, with versions from one.
+func interceptedRequests(target string, paths ...string) []*entity.InterceptedRequest {
+	var requests []*entity.InterceptedRequest
+	for i, path := range paths {
+		requests = append(requests, &entity.InterceptedRequest{
+			ID:      uuid.NewString(),
+			Request: httptest.NewRequest(http.MethodPost, target+path, nil),
+			Version: i + 1,
+		})
+	}
+	return requests
+}
+
+func TestReplay(t *testing.T) {
+	t.Run("it should only replay the commutative requests in parallel", func(t *testing.T) {
+		var mutex sync.Mutex
+		inFlight, maxInFlight := 0, 0
+		overlapped := map[string]bool{}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			if inFlight > 1 {
+				overlapped[r.URL.Path] = true
+			}
+			inFlight--
+			mutex.Unlock()
+		}))
+		defer upstream.Close()
+
+		requests := interceptedRequests(upstream.URL, "/events", "/events", "/events", "/orders", "/events", "/events")
+		result, err := Replay(context.Background(), upstream.URL, requests, ReplayOptions{
+			Parallelism: 2,
+			Commutative: func(req *entity.InterceptedRequest) bool { return req.Request.URL.Path == "/events" },
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if result.Replayed != 6 || result.LastReplayedVersion != 6 {
+			t.Errorf("expected every request replayed, got %+v\n", result)
+		}
+		if maxInFlight != 2 {
+			t.Errorf("expected 2 requests in parallel at most, got %d\n", maxInFlight)
+		}
+		if overlapped["/orders"] {
+			t.Error("expected the request that is not commutative replayed alone")
+		}
+	})
+
+	t.Run("it should retry the requests the target was not ready for", func(t *testing.T) {
+		var mutex sync.Mutex
+		attempts := 0
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			attempts++
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer upstream.Close()
+
+		result, err := Replay(context.Background(), upstream.URL, interceptedRequests(upstream.URL, "/orders"), ReplayOptions{
+			Retries:      2,
+			RetryBackoff: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if result.Retries != 1 || result.Replayed != 1 {
+			t.Errorf("expected the request replayed after a retry, got %+v\n", result)
+		}
+	})
+
+	t.Run("it should stop at the first failure and report the progress", func(t *testing.T) {
+		var mutex sync.Mutex
+		var received []string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, r.Header.Get(HeaderRequestVersion))
+			if r.Header.Get(HeaderRequestVersion) == "3" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+		defer upstream.Close()
+
+		var progress []int
+		result, err := Replay(context.Background(), upstream.URL, interceptedRequests(upstream.URL, "/a", "/b", "/c", "/d"), ReplayOptions{
+			Retries:      1,
+			RetryBackoff: time.Millisecond,
+			OnProgress: func(version int) error {
+				progress = append(progress, version)
+				return nil
+			},
+		})
+		if err == nil {
+			t.Fatal("expected the replay to fail")
+		}
+		if len(received) != 4 || received[3] != "3" {
+			t.Errorf("expected the replay to stop after retrying version 3, got %v\n", received)
+		}
+		if result.LastReplayedVersion != 2 || len(progress) != 2 || progress[1] != 2 {
+			t.Errorf("expected the progress up to version 2, got %+v and %v\n", result, progress)
+		}
+		if len(result.Failures) != 1 || result.Failures[0].Version != 3 || result.Failures[0].Attempts != 2 || result.Failures[0].StatusCode != http.StatusBadGateway {
+			t.Errorf("expected the failure of version 3 reported, got %+v\n", result.Failures)
+		}
+	})
+
+	t.Run("it should replay with the original timing and concurrency", func(t *testing.T) {
+		var mutex sync.Mutex
+		started, ended := map[string]time.Time{}, map[string]time.Time{}
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version := r.Header.Get(HeaderRequestVersion)
+			mutex.Lock()
+			started[version] = time.Now()
+			mutex.Unlock()
+			time.Sleep(40 * time.Millisecond)
+			mutex.Lock()
+			ended[version] = time.Now()
+			mutex.Unlock()
+		}))
+		defer upstream.Close()
+
+		// The second request was received while the first one was processed, the third
+		// one after both were solved.
+		receivedAt := time.Now().Add(-time.Hour)
+		requests := interceptedRequests(upstream.URL, "/a", "/b", "/c")
+		for i, timing := range []struct{ received, solved time.Duration }{
+			{0, 100 * time.Millisecond},
+			{50 * time.Millisecond, 60 * time.Millisecond},
+			{200 * time.Millisecond, 210 * time.Millisecond},
+		} {
+			solvedAt := receivedAt.Add(timing.solved)
+			requests[i].ReceivedAt = receivedAt.Add(timing.received)
+			requests[i].SolvedAt = &solvedAt
+		}
+
+		startedAt := time.Now()
+		if _, err := Replay(context.Background(), upstream.URL, requests, ReplayOptions{Timed: true, Speed: 2}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if !started["2"].Before(ended["1"]) {
+			t.Error("expected the overlapping requests replayed concurrently")
+		}
+		if started["3"].Before(ended["1"]) || started["3"].Before(ended["2"]) {
+			t.Error("expected the last request replayed after the ones solved before it was received")
+		}
+		if delay := started["2"].Sub(startedAt); delay < 20*time.Millisecond {
+			t.Errorf("expected the second request replayed 25ms after the first, got %v\n", delay)
+		}
+	})
+
+	t.Run("it should limit the rate of the requests", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer upstream.Close()
+
+		paths := make([]string, 5)
+		for i := range paths {
+			paths[i] = "/" + strconv.Itoa(i)
+		}
+		startedAt := time.Now()
+		if _, err := Replay(context.Background(), upstream.URL, interceptedRequests(upstream.URL, paths...), ReplayOptions{RateLimit: 50}); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if elapsed := time.Since(startedAt); elapsed < 80*time.Millisecond {
+			t.Errorf("expected 5 requests at 50 per second to take at least 80ms, took %v\n", elapsed)
+		}
+	})
+}
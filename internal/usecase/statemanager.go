@@ -1,77 +1,479 @@
 package usecase
 
-import "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	interceptorConfig "github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/config/interceptor"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/logging"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/metrics"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
 
 // StateManagerUseCase declares use cases for the State Manager. It declares the use cases for
-// saving checkpoint images metadata and retrieving them.
+// saving checkpoint images metadata and retrieving them. A single State Manager serves
+// many Interceptors, every use case takes the identity of the container it is about.
 type StateManagerUseCase interface {
-	// SaveImageMetadata saves metadata about a checkpoint image.
-	SaveImageMetadata(checkpointHash string, metadata *entity.ContainerMetadata) error
-	// RetrieveImageMetadata retrieves the metadata about a checkpoint image.
-	RetrieveImageMetadata(checkpointHash string) (*entity.ContainerMetadata, error)
-	// Restore restores the monitored application container to a previous checkpointed
-	// image.
-	Restore() error
+	// RegisterInterceptor registers an Interceptor and its monitored container, updating
+	// them when they are already registered.
+	RegisterInterceptor(registration *entity.InterceptorRegistration) (*entity.Interceptor, error)
+	// HeartbeatInterceptor records the heartbeat of a registered Interceptor, marking
+	// it active again when it was lost. It returns ErrNotFound when the Interceptor is
+	// not registered for the container of the heartbeat.
+	HeartbeatInterceptor(heartbeat *entity.Heartbeat) error
+	// Interceptors returns the registry of the Interceptors.
+	Interceptors() ([]entity.InterceptorRegistryEntry, error)
+	// MonitorInterceptors marks as lost the Interceptors without heartbeats for longer
+	// than timeout, until the context is done.
+	MonitorInterceptors(ctx context.Context, timeout time.Duration) error
+	// SaveImageMetadata saves metadata about a checkpoint image of the container.
+	SaveImageMetadata(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error
+	// RetrieveImageMetadata retrieves the metadata about a checkpoint image of the
+	// container.
+	RetrieveImageMetadata(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error)
+	// Restore restores the container to its latest checkpointed image, then has its
+	// Interceptor reproject the requests intercepted after the checkpoint.
+	Restore(ctx context.Context, identity entity.ContainerIdentity) (*entity.Restoration, error)
+	// Migrate moves the container to a new pod: its checkpoint is restored in the
+	// target container and the Interceptor of the source switches its traffic to the
+	// target once it replayed the requests received meanwhile.
+	Migrate(ctx context.Context, request *entity.MigrationRequest) (*entity.Migration, error)
 	// DevelopmentRestore development use case to restore a specific container image with
 	// the given hash.
-	DevelopmentRestore(containerName string, containerHash string) error
+	DevelopmentRestore(ctx context.Context, containerName string, containerHash string) error
+	// Ready tells whether the State Manager can serve requests, returning why when it
+	// cannot.
+	Ready(ctx context.Context) error
 }
 
-// ContainerMetadataRepository repository to access container metadata at a datasource.
+// ContainerMetadataRepository repository to access container metadata at a datasource,
+// namespaced by container identity.
 type ContainerMetadataRepository interface {
 	// Insert inserts a new metadata.
-	Insert(checkpointHash string, metadata *entity.ContainerMetadata) error
+	Insert(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error
 	// Get retrieves a container metadata by checkpointHash.
-	Get(checkpointHash string) (*entity.ContainerMetadata, error)
+	Get(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error)
 	// UpsertContainerLatestCheckpoint upserts the content of the latest checkpoint
 	// hash the container received.
-	UpsertContainerLatestCheckpoint(checkpointHash string, containerID string) error
+	UpsertContainerLatestCheckpoint(identity entity.ContainerIdentity, checkpointHash string) error
 	// LatestContainerCheckpoint retrieves the latest container checkpoint hash.
-	LatestContainerCheckpoint(containerID string) (string, error)
+	LatestContainerCheckpoint(identity entity.ContainerIdentity) (string, error)
+	// SaveCheckpoint inserts the metadata and makes the checkpoint the latest of the
+	// container atomically, unless the latest checkpoint is newer.
+	SaveCheckpoint(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error
+	// Ping checks the connectivity with the datasource.
+	Ping(ctx context.Context) error
+}
+
+// CheckpointWatcher notifies the checkpoints becoming the latest of their container,
+// implemented by the repositories able to watch their datasource.
+type CheckpointWatcher interface {
+	// WatchCheckpoints sends the new latest checkpoints until the context is done.
+	WatchCheckpoints(ctx context.Context) (<-chan entity.CheckpointEvent, error)
+}
+
+// CheckpointHistory lists the checkpoints of the containers, implemented by the
+// repositories keeping every checkpoint rather than only the latest.
+type CheckpointHistory interface {
+	// ListCheckpoints returns every checkpoint of the container, oldest first.
+	ListCheckpoints(identity entity.ContainerIdentity) ([]*entity.Checkpoint, error)
 }
 
 type stateManagerUseCase struct {
-	repository           ContainerMetadataRepository
-	restoreService       entity.RestoreService
-	monitoredApplication *entity.Container
+	repository            ContainerMetadataRepository
+	containerRepository   entity.ContainerRepository
+	interceptorRepository entity.InterceptorRepository
+	restoreService        entity.RestoreService
+	// reprojectionService reprojects the requests after a restore, restores are not
+	// followed by reprojections when nil.
+	reprojectionService entity.ReprojectionService
+	// migrationService drives the migrations, which are refused when nil.
+	migrationService entity.MigrationService
+	// registrationMutex serializes registrations and heartbeats so concurrent ones of
+	// the same container do not create it twice nor overwrite each other.
+	registrationMutex sync.Mutex
 }
 
-func StateManager(repository ContainerMetadataRepository, restoreService entity.RestoreService, monitoredApplication *entity.Container) (StateManagerUseCase, error) {
+func StateManager(repository ContainerMetadataRepository, containerRepository entity.ContainerRepository, interceptorRepository entity.InterceptorRepository, restoreService entity.RestoreService, reprojectionService entity.ReprojectionService, migrationService entity.MigrationService) (StateManagerUseCase, error) {
 	return &stateManagerUseCase{
-		repository:           repository,
-		restoreService:       restoreService,
-		monitoredApplication: monitoredApplication,
+		repository:            repository,
+		containerRepository:   containerRepository,
+		interceptorRepository: interceptorRepository,
+		restoreService:        restoreService,
+		reprojectionService:   reprojectionService,
+		migrationService:      migrationService,
 	}, nil
 }
 
-func (uc *stateManagerUseCase) SaveImageMetadata(checkpointHash string, metadata *entity.ContainerMetadata) error {
-	err := uc.repository.UpsertContainerLatestCheckpoint(checkpointHash, uc.monitoredApplication.ID)
+func (uc *stateManagerUseCase) RegisterInterceptor(registration *entity.InterceptorRegistration) (*entity.Interceptor, error) {
+	if err := registration.Container.Validate(); err != nil {
+		return nil, err
+	}
+	if registration.InterceptorID == "" {
+		return nil, errors.New("interceptor id is required")
+	}
+
+	uc.registrationMutex.Lock()
+	defer uc.registrationMutex.Unlock()
+
+	container, err := uc.containerRepository.GetByIdentity(registration.Container)
+	switch {
+	case errors.Is(err, entity.ErrNotFound):
+		container = &entity.Container{
+			ID:        uuid.NewString(),
+			Name:      registration.Container.Container,
+			Namespace: registration.Container.Namespace,
+			Pod:       registration.Container.Pod,
+			PID:       registration.PID,
+			HTTPUrl:   registration.HTTPUrl,
+		}
+		if err := uc.containerRepository.Create(container); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		updated := *container
+		updated.PID = registration.PID
+		updated.HTTPUrl = registration.HTTPUrl
+		if err := uc.containerRepository.Update(&updated); err != nil {
+			return nil, err
+		}
+		container = &updated
+	}
+
+	now := time.Now()
+	interceptor := &entity.Interceptor{
+		ID:                    registration.InterceptorID,
+		MonitoringContainerID: container.ID,
+		MonitoredContainer:    container,
+		AdminURL:              registration.AdminURL,
+		State:                 entity.InterceptorActive,
+		RegisteredAt:          now,
+		LastHeartbeat:         now,
+	}
+	if registration.Config != "" {
+		cfg, err := interceptorConfig.FromYAML([]byte(registration.Config))
+		if err != nil {
+			slog.Warn("ignoring invalid interceptor configuration", logging.KeyInterceptorID, interceptor.ID, logging.Error(err))
+		} else {
+			interceptor.Config = cfg
+		}
+	}
+	previous, err := uc.interceptorRepository.GetById(interceptor.ID)
+	switch {
+	case errors.Is(err, entity.ErrNotFound):
+		err = uc.interceptorRepository.Create(interceptor)
+	case err == nil:
+		// Keep what the Interceptor reported before registering again.
+		interceptor.LastVersion = previous.LastVersion
+		interceptor.LastCheckpoint = previous.LastCheckpoint
+		interceptor.LastCheckpointHash = previous.LastCheckpointHash
+		err = uc.interceptorRepository.Update(interceptor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("interceptor registered", logging.KeyInterceptorID, interceptor.ID, logging.KeyContainer, registration.Container.String())
+	return interceptor, nil
+}
+
+func (uc *stateManagerUseCase) HeartbeatInterceptor(heartbeat *entity.Heartbeat) error {
+	uc.registrationMutex.Lock()
+	defer uc.registrationMutex.Unlock()
+
+	interceptor, err := uc.interceptorRepository.GetById(heartbeat.InterceptorID)
 	if err != nil {
 		return err
 	}
+	if interceptor.MonitoredContainer == nil || interceptor.MonitoredContainer.Identity() != heartbeat.Container {
+		return fmt.Errorf("%w: interceptor %q is not registered for container %s", entity.ErrNotFound, heartbeat.InterceptorID, heartbeat.Container)
+	}
 
-	return uc.repository.Insert(checkpointHash, metadata)
+	updated := *interceptor
+	updated.LastHeartbeat = time.Now()
+	updated.LastVersion = heartbeat.LastVersion
+	updated.LastCheckpoint = heartbeat.LastCheckpoint
+	updated.LastCheckpointHash = heartbeat.LastCheckpointHash
+	if updated.State == entity.InterceptorLost {
+		slog.Info("lost interceptor is back", logging.KeyInterceptorID, updated.ID, logging.KeyContainer, heartbeat.Container.String())
+	}
+	updated.State = entity.InterceptorActive
+	return uc.interceptorRepository.Update(&updated)
 }
 
-func (uc *stateManagerUseCase) RetrieveImageMetadata(checkpointHash string) (*entity.ContainerMetadata, error) {
-	return uc.repository.Get(checkpointHash)
+func (uc *stateManagerUseCase) Interceptors() ([]entity.InterceptorRegistryEntry, error) {
+	interceptors, err := uc.interceptorRepository.List()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entity.InterceptorRegistryEntry, 0, len(interceptors))
+	for _, interceptor := range interceptors {
+		entries = append(entries, interceptor.Entry())
+	}
+	return entries, nil
+}
+
+// MonitorInterceptors checks the heartbeats a few times per timeout so an Interceptor
+// is marked as lost soon after it misses them.
+func (uc *stateManagerUseCase) MonitorInterceptors(ctx context.Context, timeout time.Duration) error {
+	ticker := time.NewTicker(timeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if _, err := uc.markLostInterceptors(now, timeout); err != nil {
+				slog.Error("could not check interceptor heartbeats", logging.Error(err))
+			}
+		}
+	}
 }
 
-func (uc *stateManagerUseCase) Restore() error {
-	checkpointHash, err := uc.repository.LatestContainerCheckpoint(uc.monitoredApplication.ID)
+// markLostInterceptors marks as lost the active Interceptors whose last heartbeat is
+// older than timeout at now, returning them.
+func (uc *stateManagerUseCase) markLostInterceptors(now time.Time, timeout time.Duration) ([]*entity.Interceptor, error) {
+	uc.registrationMutex.Lock()
+	defer uc.registrationMutex.Unlock()
+
+	interceptors, err := uc.interceptorRepository.List()
 	if err != nil {
+		return nil, err
+	}
+
+	var lost []*entity.Interceptor
+	states := map[string]int{entity.InterceptorActive: 0, entity.InterceptorLost: 0}
+	for _, interceptor := range interceptors {
+		if interceptor.State == entity.InterceptorActive && now.Sub(interceptor.LastHeartbeat) > timeout {
+			updated := *interceptor
+			updated.State = entity.InterceptorLost
+			if err := uc.interceptorRepository.Update(&updated); err != nil {
+				return lost, err
+			}
+			slog.Warn("interceptor lost",
+				logging.KeyInterceptorID, updated.ID,
+				logging.KeyContainer, updated.MonitoredContainer.Identity().String(),
+				"last_heartbeat", updated.LastHeartbeat,
+			)
+			metrics.LostInterceptors.Inc()
+			lost = append(lost, &updated)
+			interceptor = &updated
+		}
+		states[interceptor.State]++
+	}
+	for state, count := range states {
+		metrics.Interceptors.WithLabelValues(state).Set(float64(count))
+	}
+	return lost, nil
+}
+
+func (uc *stateManagerUseCase) SaveImageMetadata(identity entity.ContainerIdentity, checkpointHash string, metadata *entity.ContainerMetadata) error {
+	if err := identity.Validate(); err != nil {
 		return err
 	}
 
-	return uc.restoreService.Restore(&entity.RestoreConfig{
-		ContainerName:  uc.monitoredApplication.Name,
+	return uc.repository.SaveCheckpoint(identity, checkpointHash, metadata)
+}
+
+func (uc *stateManagerUseCase) RetrieveImageMetadata(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	return uc.repository.Get(identity, checkpointHash)
+}
+
+func (uc *stateManagerUseCase) Restore(ctx context.Context, identity entity.ContainerIdentity) (*entity.Restoration, error) {
+	container, err := uc.containerRepository.GetByIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointHash, err := uc.repository.LatestContainerCheckpoint(identity)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := uc.repository.Get(identity, checkpointHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var interceptor *entity.Interceptor
+	if uc.reprojectionService != nil {
+		interceptor, err = uc.activeInterceptor(identity)
+		if err != nil {
+			slog.Warn("restoring without reprojection", logging.KeyContainer, identity.String(), logging.Error(err))
+		}
+	}
+	// The Interceptor holds the requests received during the restore, forwarding them
+	// after the reprojection. Restoring without holding only fails more requests.
+	if interceptor != nil {
+		if err := uc.reprojectionService.Hold(ctx, interceptor.AdminURL); err != nil {
+			slog.Warn("restoring without holding requests", logging.KeyContainer, identity.String(), logging.Error(err))
+		}
+	}
+
+	err = uc.restore(ctx, &entity.RestoreConfig{
+		ContainerName:  container.Name,
 		CheckpointHash: checkpointHash,
 	})
+	if err != nil {
+		if interceptor != nil {
+			if err := uc.reprojectionService.Release(ctx, interceptor.AdminURL); err != nil {
+				slog.Warn("could not release held requests", logging.KeyContainer, identity.String(), logging.Error(err))
+			}
+		}
+		return nil, err
+	}
+
+	// The requests up to the last version of the checkpoint are part of the restored
+	// state, the ones after it are reprojected.
+	restoration := &entity.Restoration{
+		ID:             uuid.NewString(),
+		Container:      identity,
+		CheckpointHash: checkpointHash,
+		FromVersion:    metadata.LastVersion + 1,
+	}
+	if interceptor == nil {
+		return restoration, nil
+	}
+
+	reprojectCtx, span := tracing.Start(ctx, "Restore.Reproject", attribute.Int("reprojection.from_version", restoration.FromVersion))
+	restoration.Reprojection, err = uc.reprojectionService.Reproject(reprojectCtx, interceptor.AdminURL, restoration.FromVersion, metadata.LastVersion, restoration.ID)
+	tracing.End(span, err)
+	if err != nil {
+		return restoration, fmt.Errorf("reprojection from version %d: %w", restoration.FromVersion, err)
+	}
+	restoration.Reprojected = true
+	return restoration, nil
 }
 
-func (uc *stateManagerUseCase) DevelopmentRestore(containerName string, containerHash string) error {
-	return uc.restoreService.Restore(&entity.RestoreConfig{
+// activeInterceptor returns the last registered active Interceptor of the container
+// with an admin URL.
+func (uc *stateManagerUseCase) activeInterceptor(identity entity.ContainerIdentity) (*entity.Interceptor, error) {
+	interceptors, err := uc.interceptorRepository.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var active *entity.Interceptor
+	for _, interceptor := range interceptors {
+		if interceptor.MonitoredContainer == nil || interceptor.MonitoredContainer.Identity() != identity {
+			continue
+		}
+		if interceptor.State != entity.InterceptorActive || interceptor.AdminURL == "" {
+			continue
+		}
+		if active == nil || interceptor.RegisteredAt.After(active.RegisteredAt) {
+			active = interceptor
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("%w: no active interceptor with an admin URL for container %s", entity.ErrNotFound, identity)
+	}
+	return active, nil
+}
+
+func (uc *stateManagerUseCase) Migrate(ctx context.Context, request *entity.MigrationRequest) (*entity.Migration, error) {
+	if uc.migrationService == nil {
+		return nil, ErrMigrationUnsupported
+	}
+	if err := request.Source.Validate(); err != nil {
+		return nil, err
+	}
+	if err := request.Target.Validate(); err != nil {
+		return nil, err
+	}
+	if request.Source == request.Target {
+		return nil, errors.New("the source and the target of a migration must differ")
+	}
+	if targetURL, err := url.Parse(request.TargetURL); err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
+		return nil, fmt.Errorf("target url must be an absolute URL, got %q", request.TargetURL)
+	}
+
+	source, err := uc.activeInterceptor(request.Source)
+	if err != nil {
+		return nil, err
+	}
+	target, err := uc.activeInterceptor(request.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := &entity.Migration{
+		Source:    request.Source,
+		Target:    request.Target,
+		StartedAt: time.Now(),
+	}
+	ctx, span := tracing.Start(ctx, "Migrate",
+		attribute.String("migration.source", request.Source.String()),
+		attribute.String("migration.target", request.Target.String()),
+	)
+	err = uc.migrate(ctx, migration, source, target, request.TargetURL)
+	tracing.End(span, err)
+
+	logger := slog.With("source", request.Source.String(), "target", request.Target.String(), logging.KeyCheckpointHash, migration.CheckpointHash)
+	if err != nil {
+		logger.Error("migration failed", logging.Error(err))
+		return nil, err
+	}
+	logger.Info("container migrated", "duration", migration.CompletedAt.Sub(migration.StartedAt))
+	return migration, nil
+}
+
+// migrate runs the steps of the migration. The source keeps serving the requests
+// until the switch, which replays the ones after the checkpoint to the target.
+func (uc *stateManagerUseCase) migrate(ctx context.Context, migration *entity.Migration, source *entity.Interceptor, target *entity.Interceptor, targetURL string) error {
+	result, err := uc.migrationService.Checkpoint(ctx, source.AdminURL)
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	migration.CheckpointHash = result.CheckpointHash
+	migration.FromVersion = result.Metadata.LastVersion + 1
+
+	if err := uc.migrationService.Transfer(ctx, source.AdminURL, target.AdminURL, result.CheckpointHash); err != nil {
+		return fmt.Errorf("transfer of checkpoint %q: %w", result.CheckpointHash, err)
+	}
+	if err := uc.migrationService.Restore(ctx, target.AdminURL, result.CheckpointHash); err != nil {
+		return fmt.Errorf("restore of checkpoint %q: %w", result.CheckpointHash, err)
+	}
+	if err := uc.migrationService.Switch(ctx, source.AdminURL, migration.FromVersion, targetURL); err != nil {
+		return fmt.Errorf("switch from version %d: %w", migration.FromVersion, err)
+	}
+	migration.CompletedAt = time.Now()
+	return nil
+}
+
+func (uc *stateManagerUseCase) DevelopmentRestore(ctx context.Context, containerName string, containerHash string) error {
+	return uc.restore(ctx, &entity.RestoreConfig{
 		ContainerName:  containerName,
 		CheckpointHash: containerHash,
 	})
 }
+
+func (uc *stateManagerUseCase) Ready(ctx context.Context) error {
+	if err := uc.repository.Ping(ctx); err != nil {
+		return fmt.Errorf("metadata store unreachable: %w", err)
+	}
+	return nil
+}
+
+func (uc *stateManagerUseCase) restore(ctx context.Context, cfg *entity.RestoreConfig) error {
+	_, span := tracing.Start(ctx, "Restore",
+		attribute.String("container.name", cfg.ContainerName),
+		attribute.String("checkpoint.hash", cfg.CheckpointHash),
+	)
+	startedAt := time.Now()
+	err := uc.restoreService.Restore(cfg)
+	metrics.ObserveRestore(startedAt, err)
+	tracing.End(span, err)
+	return err
+}
@@ -1,11 +1,17 @@
 package usecase
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/container"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/containermetadata"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/repository/interceptor"
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/service/restore"
 	"github.com/google/uuid"
 )
@@ -13,15 +19,13 @@ import (
 func TestStateManager(t *testing.T) {
 	containerMetadataRepository := containermetadata.InMemory()
 	restoreService := restore.AlwaysAcceptStub()
-	stateManager, err := StateManager(containerMetadataRepository, restoreService, &entity.Container{
-		ID:      uuid.NewString(),
-		PID:     30,
-		HTTPUrl: "http://localhost:8000",
-		Name:    "test",
-	})
+	reprojectionService := &recordingReprojectionService{}
+	migrationService := &recordingMigrationService{}
+	stateManager, err := StateManager(containerMetadataRepository, container.InMemory(), interceptor.InMemory(), restoreService, reprojectionService, migrationService)
 	if err != nil {
 		t.Fatal(err)
 	}
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app-0", Container: "test"}
 
 	t.Run("should save image metadata", func(t *testing.T) {
 		checkpointHash := uuid.NewString()
@@ -29,13 +33,13 @@ func TestStateManager(t *testing.T) {
 			LastTimestamp:       time.Now(),
 			LastRequestSolvedID: uuid.NewString(),
 		}
-		err = stateManager.SaveImageMetadata(checkpointHash, &containerMetadata)
+		err = stateManager.SaveImageMetadata(identity, checkpointHash, &containerMetadata)
 		if err != nil {
 			t.Errorf("should got error nil, received %v\n", err)
 		}
 
 		t.Run("should retrieve image metadata", func(t *testing.T) {
-			metadata, err := containerMetadataRepository.Get(checkpointHash)
+			metadata, err := containerMetadataRepository.Get(identity, checkpointHash)
 			if err != nil {
 				t.Errorf("expected to get no error retrieving metadata, got %v\n", err)
 			}
@@ -46,5 +50,277 @@ func TestStateManager(t *testing.T) {
 				t.Errorf("expected last timestamp to be %v, received %v\n", containerMetadata.LastTimestamp, metadata.LastTimestamp)
 			}
 		})
+
+		t.Run("should not share metadata between containers", func(t *testing.T) {
+			other := identity
+			other.Namespace = "other"
+			if _, err := stateManager.RetrieveImageMetadata(other, checkpointHash); !errors.Is(err, entity.ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v\n", err)
+			}
+		})
+	})
+
+	t.Run("should register interceptors", func(t *testing.T) {
+		registration := &entity.InterceptorRegistration{
+			InterceptorID: uuid.NewString(),
+			Container:     identity,
+			PID:           30,
+			HTTPUrl:       "http://localhost:8000",
+		}
+		first, err := stateManager.RegisterInterceptor(registration)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		t.Run("should keep the container of a new interceptor", func(t *testing.T) {
+			registration.InterceptorID = uuid.NewString()
+			registration.PID = 31
+			second, err := stateManager.RegisterInterceptor(registration)
+			if err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+			if second.MonitoringContainerID != first.MonitoringContainerID {
+				t.Errorf("expected container %q, got %q\n", first.MonitoringContainerID, second.MonitoringContainerID)
+			}
+			if second.MonitoredContainer.PID != 31 {
+				t.Errorf("expected updated PID 31, got %d\n", second.MonitoredContainer.PID)
+			}
+		})
+
+		t.Run("should reject an incomplete identity", func(t *testing.T) {
+			_, err := stateManager.RegisterInterceptor(&entity.InterceptorRegistration{
+				InterceptorID: uuid.NewString(),
+				Container:     entity.ContainerIdentity{Container: "test"},
+			})
+			if err == nil {
+				t.Error("expected an error for a registration without namespace and pod")
+			}
+		})
+	})
+
+	t.Run("should track the heartbeats of interceptors", func(t *testing.T) {
+		registration := &entity.InterceptorRegistration{
+			InterceptorID: uuid.NewString(),
+			Container:     entity.ContainerIdentity{Namespace: "default", Pod: "app-1", Container: "test"},
+		}
+		if _, err := stateManager.RegisterInterceptor(registration); err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+
+		err := stateManager.HeartbeatInterceptor(&entity.Heartbeat{
+			InterceptorID: registration.InterceptorID,
+			Container:     registration.Container,
+			LastVersion:   42,
+		})
+		if err != nil {
+			t.Errorf("expected no error, got %v\n", err)
+		}
+		entry := registryEntry(t, stateManager, registration.InterceptorID)
+		if entry.State != entity.InterceptorActive || entry.LastVersion != 42 {
+			t.Errorf("expected an active interceptor at version 42, got %+v\n", entry)
+		}
+
+		t.Run("should reject heartbeats of unknown interceptors", func(t *testing.T) {
+			err := stateManager.HeartbeatInterceptor(&entity.Heartbeat{InterceptorID: uuid.NewString(), Container: registration.Container})
+			if !errors.Is(err, entity.ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v\n", err)
+			}
+		})
+
+		t.Run("should mark interceptors without heartbeats as lost", func(t *testing.T) {
+			lost, err := stateManager.(*stateManagerUseCase).markLostInterceptors(time.Now().Add(time.Minute), 30*time.Second)
+			if err != nil {
+				t.Fatalf("expected no error, got %v\n", err)
+			}
+			if len(lost) == 0 {
+				t.Error("expected interceptors to be lost")
+			}
+			if entry := registryEntry(t, stateManager, registration.InterceptorID); entry.State != entity.InterceptorLost {
+				t.Errorf("expected a lost interceptor, got %q\n", entry.State)
+			}
+		})
+
+		t.Run("should mark lost interceptors active on their next heartbeat", func(t *testing.T) {
+			err := stateManager.HeartbeatInterceptor(&entity.Heartbeat{InterceptorID: registration.InterceptorID, Container: registration.Container})
+			if err != nil {
+				t.Errorf("expected no error, got %v\n", err)
+			}
+			if entry := registryEntry(t, stateManager, registration.InterceptorID); entry.State != entity.InterceptorActive {
+				t.Errorf("expected an active interceptor, got %q\n", entry.State)
+			}
+		})
+	})
+
+	t.Run("should restore the latest checkpoint of a registered container", func(t *testing.T) {
+		restoration, err := stateManager.Restore(context.Background(), identity)
+		if err != nil {
+			t.Errorf("expected no error, got %v\n", err)
+		}
+		if restoration == nil || restoration.Reprojected {
+			t.Errorf("expected a restoration without reprojection when no interceptor is active, got %+v\n", restoration)
+		}
+
+		unknown := entity.ContainerIdentity{Namespace: "default", Pod: "unknown", Container: "test"}
+		if _, err := stateManager.Restore(context.Background(), unknown); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v\n", err)
+		}
+	})
+
+	t.Run("should reproject the requests after the restored checkpoint", func(t *testing.T) {
+		err := stateManager.SaveImageMetadata(identity, uuid.NewString(), &entity.ContainerMetadata{
+			LastTimestamp: time.Now(),
+			LastVersion:   7,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stateManager.RegisterInterceptor(&entity.InterceptorRegistration{
+			InterceptorID: uuid.NewString(),
+			AdminURL:      "http://interceptor:8003",
+			Container:     identity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restoration, err := stateManager.Restore(context.Background(), identity)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if !restoration.Reprojected || restoration.FromVersion != 8 {
+			t.Errorf("expected a reprojection from version 8, got %+v\n", restoration)
+		}
+		if restoration.Reprojection == nil || restoration.Reprojection.FromVersion != 8 || restoration.Reprojection.AppliedVersion != 7 {
+			t.Errorf("expected the report of the reprojection, got %+v\n", restoration.Reprojection)
+		}
+		if reprojectionService.adminURL != "http://interceptor:8003" || reprojectionService.fromVersion != 8 {
+			t.Errorf("expected the interceptor to reproject from version 8, got %+v\n", reprojectionService)
+		}
+		if restoration.ID == "" || reprojectionService.restore != restoration.ID {
+			t.Errorf("expected the reprojection of restore %q, got %q\n", restoration.ID, reprojectionService.restore)
+		}
+		if reprojectionService.holds != 1 || reprojectionService.releases != 0 {
+			t.Errorf("expected the requests held until the reprojection, got %+v\n", reprojectionService)
+		}
+	})
+
+	t.Run("should migrate a container to the target container", func(t *testing.T) {
+		target := entity.ContainerIdentity{Namespace: "default", Pod: "app-2", Container: "test"}
+		request := &entity.MigrationRequest{Source: identity, Target: target, TargetURL: "http://app-2:8001"}
+		if _, err := stateManager.Migrate(context.Background(), request); !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound without interceptor of the target, got %v\n", err)
+		}
+
+		_, err := stateManager.RegisterInterceptor(&entity.InterceptorRegistration{
+			InterceptorID: uuid.NewString(),
+			AdminURL:      "http://target:8003",
+			Container:     target,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		migration, err := stateManager.Migrate(context.Background(), request)
+		if err != nil {
+			t.Fatalf("expected no error, got %v\n", err)
+		}
+		if migration.CheckpointHash != "migrated" || migration.FromVersion != 13 {
+			t.Errorf("expected a migration of checkpoint %q from version 13, got %+v\n", "migrated", migration)
+		}
+		expected := []string{
+			"checkpoint http://interceptor:8003",
+			"transfer migrated http://interceptor:8003 http://target:8003",
+			"restore migrated http://target:8003",
+			"switch 13 http://app-2:8001 http://interceptor:8003",
+		}
+		if strings.Join(migrationService.steps, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("expected steps %q, got %q\n", expected, migrationService.steps)
+		}
+
+		t.Run("should not switch when the restore fails", func(t *testing.T) {
+			migrationService.steps = nil
+			migrationService.restoreErr = errors.New("restore failed")
+			defer func() { migrationService.restoreErr = nil }()
+
+			if _, err := stateManager.Migrate(context.Background(), request); !errors.Is(err, migrationService.restoreErr) {
+				t.Errorf("expected the restore error, got %v\n", err)
+			}
+			if len(migrationService.steps) != 3 {
+				t.Errorf("expected no switch, got steps %q\n", migrationService.steps)
+			}
+		})
+
+		t.Run("should reject a migration to the source", func(t *testing.T) {
+			if _, err := stateManager.Migrate(context.Background(), &entity.MigrationRequest{Source: identity, Target: identity, TargetURL: "http://app-0:8001"}); err == nil {
+				t.Error("expected an error migrating a container to itself")
+			}
+		})
 	})
 }
+
+// recordingMigrationService records the steps of the migrations.
+type recordingMigrationService struct {
+	steps      []string
+	restoreErr error
+}
+
+func (s *recordingMigrationService) Checkpoint(ctx context.Context, adminURL string) (*entity.CheckpointResult, error) {
+	s.steps = append(s.steps, "checkpoint "+adminURL)
+	return &entity.CheckpointResult{CheckpointHash: "migrated", Metadata: entity.ContainerMetadata{LastVersion: 12}}, nil
+}
+
+func (s *recordingMigrationService) Transfer(ctx context.Context, sourceAdminURL string, targetAdminURL string, checkpointHash string) error {
+	s.steps = append(s.steps, "transfer "+checkpointHash+" "+sourceAdminURL+" "+targetAdminURL)
+	return nil
+}
+
+func (s *recordingMigrationService) Restore(ctx context.Context, adminURL string, checkpointHash string) error {
+	s.steps = append(s.steps, "restore "+checkpointHash+" "+adminURL)
+	return s.restoreErr
+}
+
+func (s *recordingMigrationService) Switch(ctx context.Context, adminURL string, fromVersion int, upstream string) error {
+	s.steps = append(s.steps, fmt.Sprintf("switch %d %s %s", fromVersion, upstream, adminURL))
+	return nil
+}
+
+// recordingReprojectionService records the last reprojection and the holds.
+type recordingReprojectionService struct {
+	adminURL    string
+	fromVersion int
+	restore     string
+	holds       int
+	releases    int
+}
+
+func (s *recordingReprojectionService) Hold(ctx context.Context, adminURL string) error {
+	s.holds++
+	return nil
+}
+
+func (s *recordingReprojectionService) Release(ctx context.Context, adminURL string) error {
+	s.releases++
+	return nil
+}
+
+func (s *recordingReprojectionService) Reproject(ctx context.Context, adminURL string, fromVersion int, appliedVersion int, restore string) (*entity.ReprojectionReport, error) {
+	s.adminURL = adminURL
+	s.fromVersion = fromVersion
+	s.restore = restore
+	return &entity.ReprojectionReport{FromVersion: fromVersion, AppliedVersion: appliedVersion}, nil
+}
+
+func registryEntry(t *testing.T, stateManager StateManagerUseCase, id string) entity.InterceptorRegistryEntry {
+	t.Helper()
+	entries, err := stateManager.Interceptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	t.Fatalf("interceptor %q is not in the registry", id)
+	return entity.InterceptorRegistryEntry{}
+}
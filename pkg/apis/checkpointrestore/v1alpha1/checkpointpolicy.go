@@ -0,0 +1,68 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CheckpointPolicyResource is the resource of the CheckpointPolicy kind.
+var CheckpointPolicyResource = GroupVersion.WithResource("checkpointpolicies")
+
+// InterceptorConfigKey is the key of the Interceptor configuration file in the
+// ConfigMap of a CheckpointPolicy.
+const InterceptorConfigKey = "interceptor.yaml"
+
+// ConditionReady is the condition of a CheckpointPolicy whose configuration is
+// applied.
+const ConditionReady = "Ready"
+
+// CheckpointPolicy configures the checkpoints of the Interceptors of the selected
+// pods, in its namespace.
+type CheckpointPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CheckpointPolicySpec   `json:"spec"`
+	Status CheckpointPolicyStatus `json:"status,omitempty"`
+}
+
+// CheckpointPolicySpec describes how the containers are checkpointed.
+type CheckpointPolicySpec struct {
+	// Selector selects the pods whose Interceptors follow the policy.
+	Selector metav1.LabelSelector `json:"selector"`
+	// Interval is the interval between the checkpoints.
+	Interval metav1.Duration `json:"interval"`
+	// Retention is the number of checkpoints kept per container, zero keeps them all.
+	// The Interceptors delete the older images from the images directory. Their
+	// metadata is pruned only with the kubernetes backend of the State Manager, by
+	// deleting the Checkpoint resources, the etcd and sql backends keep it.
+	Retention int `json:"retention,omitempty"`
+	// Storage is where the checkpoint images are stored.
+	Storage CheckpointStorage `json:"storage,omitempty"`
+}
+
+// CheckpointStorage is where the checkpoint images are stored.
+type CheckpointStorage struct {
+	// ImagesDirectory is the directory of the checkpoint images on the nodes.
+	ImagesDirectory string `json:"imagesDirectory,omitempty"`
+}
+
+// CheckpointPolicyStatus is the observed state of the policy.
+type CheckpointPolicyStatus struct {
+	// ObservedGeneration is the generation of the spec last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ConfigMap is the name of the ConfigMap with the Interceptor configuration, to
+	// be mounted by the selected pods.
+	ConfigMap string `json:"configMap,omitempty"`
+	// MatchedPods is the number of pods selected by the policy.
+	MatchedPods int `json:"matchedPods"`
+	// Conditions are the conditions of the policy, ConditionReady.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// CheckpointPolicyList is a list of CheckpointPolicies.
+type CheckpointPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CheckpointPolicy `json:"items"`
+}
@@ -0,0 +1,73 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreResource is the resource of the Restore kind.
+var RestoreResource = GroupVersion.WithResource("restores")
+
+// Phases of a Restore.
+const (
+	// RestorePending is the phase of a Restore not started yet.
+	RestorePending = "Pending"
+	// RestoreRestoring is the phase of a Restore waiting for the State Manager to
+	// restore the container and reproject its requests.
+	RestoreRestoring = "Restoring"
+	// RestoreSucceeded is the phase of a Restore whose container was restored.
+	RestoreSucceeded = "Succeeded"
+	// RestoreFailed is the phase of a Restore that could not restore its container.
+	RestoreFailed = "Failed"
+)
+
+// Conditions of a Restore.
+const (
+	// ConditionRestored tells whether the checkpoint was restored.
+	ConditionRestored = "Restored"
+	// ConditionReprojected tells whether the requests after the checkpoint were
+	// reprojected.
+	ConditionReprojected = "Reprojected"
+)
+
+// Restore restores a container to its latest checkpoint when created, it is not
+// retried once it succeeded or failed.
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+// RestoreSpec describes the container to restore, in the namespace of the Restore.
+type RestoreSpec struct {
+	// Pod is the name of the pod running the container.
+	Pod string `json:"pod"`
+	// Container is the name of the container in the pod.
+	Container string `json:"container"`
+}
+
+// RestoreStatus is the progress of the restore.
+type RestoreStatus struct {
+	// Phase is the phase of the restore, RestorePending when empty.
+	Phase string `json:"phase,omitempty"`
+	// CheckpointHash is the hash of the restored checkpoint.
+	CheckpointHash string `json:"checkpointHash,omitempty"`
+	// FromVersion is the version the intercepted requests were reprojected from.
+	FromVersion int `json:"fromVersion,omitempty"`
+	// StartedAt is when the restore started.
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is when the restore succeeded or failed.
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// Conditions are the conditions of the restore, ConditionRestored and
+	// ConditionReprojected.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// RestoreList is a list of Restores.
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Restore `json:"items"`
+}
@@ -0,0 +1,70 @@
+// Package v1alpha1 declares the custom resources of the checkpoint and restore of
+// the containers.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the group and version of the custom resources.
+var GroupVersion = schema.GroupVersion{Group: "ctr.gianortiz.github.io", Version: "v1alpha1"}
+
+// Labels set on the custom resources of a container, to select them.
+var (
+	LabelPod       = GroupVersion.Group + "/pod"
+	LabelContainer = GroupVersion.Group + "/container"
+)
+
+// CheckpointResource is the resource of the Checkpoint kind.
+var CheckpointResource = GroupVersion.WithResource("checkpoints")
+
+// Phases of a Checkpoint.
+const (
+	// CheckpointAvailable is the phase of a Checkpoint that can be restored.
+	CheckpointAvailable = "Available"
+	// CheckpointLatest is the phase of the latest Checkpoint of its container, the one
+	// restored.
+	CheckpointLatest = "Latest"
+)
+
+// Checkpoint is a checkpoint of a container, in the namespace of its pod.
+type Checkpoint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CheckpointSpec   `json:"spec"`
+	Status CheckpointStatus `json:"status,omitempty"`
+}
+
+// CheckpointSpec describes the checkpoint.
+type CheckpointSpec struct {
+	// Pod is the name of the pod running the checkpointed container.
+	Pod string `json:"pod"`
+	// Container is the name of the checkpointed container in the pod.
+	Container string `json:"container"`
+	// Hash is the hash of the checkpoint image.
+	Hash string `json:"hash"`
+	// LastTimestamp is when the checkpoint was made.
+	LastTimestamp metav1.MicroTime `json:"lastTimestamp"`
+	// LastRequestSolvedID is the id of the last request served before the checkpoint.
+	LastRequestSolvedID string `json:"lastRequestSolvedID"`
+	// LastVersion is the version of the last request intercepted before the checkpoint.
+	LastVersion int `json:"lastVersion,omitempty"`
+	// StorageLocation is where the checkpoint image is stored.
+	StorageLocation string `json:"storageLocation,omitempty"`
+}
+
+// CheckpointStatus is the observed state of the checkpoint.
+type CheckpointStatus struct {
+	// Phase is either CheckpointAvailable or CheckpointLatest.
+	Phase string `json:"phase,omitempty"`
+}
+
+// CheckpointList is a list of Checkpoints.
+type CheckpointList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Checkpoint `json:"items"`
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff returns how long to wait before the given retry attempt, starting at 1.
+// The wait grows exponentially from initial up to max, and a random jitter of up to
+// half of it is subtracted so that many interceptors retrying at once do not hit the
+// State Manager in lockstep.
+func backoff(initial time.Duration, max time.Duration, attempt int) time.Duration {
+	if initial <= 0 {
+		return 0
+	}
+
+	wait := initial
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+
+	half := int64(wait / 2)
+	if half == 0 {
+		return wait
+	}
+	return wait - time.Duration(rand.Int63n(half))
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops requests to the State Manager after a number of consecutive
+// failures, letting a single request through again once the cooldown has passed.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow tells whether a request may be sent to the State Manager.
+func (cb *circuitBreaker) allow() bool {
+	if cb.threshold <= 0 {
+		return true
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	// The circuit is open, after the cooldown let one request through to probe
+	// whether the State Manager is back. Another failure reopens it.
+	if cb.now().Sub(cb.openedAt) >= cb.cooldown {
+		cb.openedAt = cb.now()
+		return true
+	}
+	return false
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures = 0
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openedAt = cb.now()
+	}
+}
@@ -2,49 +2,156 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 
 	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/tracing"
 )
 
 const CONTAINERS_PATH = "/containers"
 
+const CHECKPOINTS_PATH = "/checkpoints"
+
+const HEARTBEAT_PATH = "/heartbeat"
+
+const INTERCEPTORS_PATH = "/interceptors"
+
+const RESTORE_PATH = "/restore"
+
+const MIGRATIONS_PATH = "/migrations"
+
+// ErrUnavailable is returned, wrapped, when the State Manager could not be reached
+// or answered with a server error after every retry. Callers can check it with
+// errors.Is to decide whether a request is worth trying again later.
+var ErrUnavailable = errors.New("state manager unavailable")
+
+// Config is the configuration of the State Manager client.
+type Config struct {
+	// Timeout is the maximum duration of a single HTTP request to the State Manager.
+	Timeout time.Duration
+	// MaxRetries is the number of times a failed request is retried before giving up.
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, doubled on every retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the wait between retries.
+	MaxBackoff time.Duration
+	// CircuitBreakerThreshold is the number of consecutive failed requests that opens
+	// the circuit. Zero disables the circuit breaker.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long the circuit stays open before a new request
+	// is allowed through.
+	CircuitBreakerCooldown time.Duration
+	// TLSConfig is the TLS configuration used to reach the State Manager, with the
+	// client certificate to present for mutual TLS.
+	TLSConfig *tls.Config
+	// TokenFile is a file with the bearer token sent on every request, read again on
+	// each request so rotated ServiceAccount tokens are picked up.
+	TokenFile string
+}
+
+// DefaultConfig returns the configuration used by New.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:                 10 * time.Second,
+		MaxRetries:              3,
+		InitialBackoff:          200 * time.Millisecond,
+		MaxBackoff:              5 * time.Second,
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerCooldown:  30 * time.Second,
+	}
+}
+
 type Client struct {
 	httpClient *http.Client
 	baseURL    string
+	config     Config
+	breaker    *circuitBreaker
 }
 
 func New(stateManagerURL string) *Client {
+	return NewWithConfig(stateManagerURL, DefaultConfig())
+}
+
+// NewWithConfig creates a new client with the given timeouts, retries and circuit
+// breaker configuration.
+func NewWithConfig(stateManagerURL string, cfg Config) *Client {
+	transport := http.DefaultTransport
+	if cfg.TLSConfig != nil {
+		tlsTransport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsTransport.TLSClientConfig = cfg.TLSConfig
+		transport = tlsTransport
+	}
 	httpClient := http.Client{
-		Transport: http.DefaultTransport,
+		Transport: transport,
+		Timeout:   cfg.Timeout,
 	}
 	return &Client{
 		httpClient: &httpClient,
 		baseURL:    stateManagerURL,
+		config:     cfg,
+		breaker:    newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown),
 	}
 }
 
-func (c *Client) InsertMetadata(checkpointHash string, containerMetadata *entity.ContainerMetadata) error {
-	bodyBuffer := bytes.NewBuffer([]byte{})
-	err := json.NewEncoder(bodyBuffer).Encode(containerMetadata)
+// ContainerPath returns the path of the container in the State Manager API,
+// /containers/{namespace}/{pod}/{container}.
+func ContainerPath(identity entity.ContainerIdentity) string {
+	return fmt.Sprintf("%s/%s/%s/%s", CONTAINERS_PATH, url.PathEscape(identity.Namespace), url.PathEscape(identity.Pod), url.PathEscape(identity.Container))
+}
+
+// CheckpointPath returns the path of a checkpoint of the container in the State
+// Manager API, /containers/{namespace}/{pod}/{container}/checkpoints/{hash}.
+func CheckpointPath(identity entity.ContainerIdentity, checkpointHash string) string {
+	return fmt.Sprintf("%s%s/%s", ContainerPath(identity), CHECKPOINTS_PATH, url.PathEscape(checkpointHash))
+}
+
+// Register registers the Interceptor and its monitored container.
+func (c *Client) Register(ctx context.Context, registration *entity.InterceptorRegistration) error {
+	body, err := json.Marshal(registration)
 	if err != nil {
 		return err
 	}
 
-	url := fmt.Sprintf("%s/%s/%s", c.baseURL, CONTAINERS_PATH, checkpointHash)
-	req, err := http.NewRequest(http.MethodPost, url, bodyBuffer)
+	res, err := c.do(ctx, http.MethodPut, c.baseURL+ContainerPath(registration.Container), body)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
-	res, err := c.httpClient.Do(req)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Heartbeat sends the heartbeat of a registered Interceptor. It returns an error
+// wrapping entity.ErrNotFound when the State Manager does not know the Interceptor,
+// which must register again.
+func (c *Client) Heartbeat(ctx context.Context, heartbeat *entity.Heartbeat) error {
+	body, err := json.Marshal(heartbeat)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, c.baseURL+ContainerPath(heartbeat.Container)+HEARTBEAT_PATH, body)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: interceptor %q is not registered", entity.ErrNotFound, heartbeat.InterceptorID)
+	}
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("status code is %d", res.StatusCode)
 	}
@@ -52,14 +159,116 @@ func (c *Client) InsertMetadata(checkpointHash string, containerMetadata *entity
 	return nil
 }
 
-func (c *Client) RetrieveMetadata(checkpointHash string) (*entity.ContainerMetadata, error) {
-	url := fmt.Sprintf("%s/%s/%s", c.baseURL, CONTAINERS_PATH, checkpointHash)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// Interceptors lists the Interceptors in the State Manager registry.
+func (c *Client) Interceptors(ctx context.Context) ([]entity.InterceptorRegistryEntry, error) {
+	res, err := c.do(ctx, http.MethodGet, c.baseURL+INTERCEPTORS_PATH, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	var entries []entity.InterceptorRegistryEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
 
-	res, err := c.httpClient.Do(req)
+// Restore restores the container to its latest checkpoint and reprojects the
+// requests intercepted after it. It returns an error wrapping entity.ErrNotFound when
+// the container or its checkpoint is unknown. Restores are not idempotent, callers
+// should use a configuration without retries and with a timeout covering the
+// reprojection.
+func (c *Client) Restore(ctx context.Context, identity entity.ContainerIdentity) (*entity.Restoration, error) {
+	res, err := c.do(ctx, http.MethodPost, c.baseURL+ContainerPath(identity)+RESTORE_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: no checkpoint of container %s to restore", entity.ErrNotFound, identity)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	var restoration entity.Restoration
+	if err := json.NewDecoder(res.Body).Decode(&restoration); err != nil {
+		return nil, err
+	}
+
+	return &restoration, nil
+}
+
+// Migrate migrates the container to the target container, which must have a
+// registered Interceptor. It returns an error wrapping entity.ErrNotFound when either
+// has no active Interceptor. Like restores, migrations are not idempotent.
+func (c *Client) Migrate(ctx context.Context, request *entity.MigrationRequest) (*entity.Migration, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, c.baseURL+MIGRATIONS_PATH, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: no active interceptor of container %s or %s", entity.ErrNotFound, request.Source, request.Target)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	var migration entity.Migration
+	if err := json.NewDecoder(res.Body).Decode(&migration); err != nil {
+		return nil, err
+	}
+
+	return &migration, nil
+}
+
+func (c *Client) InsertMetadata(identity entity.ContainerIdentity, checkpointHash string, containerMetadata *entity.ContainerMetadata) error {
+	return c.InsertMetadataContext(context.Background(), identity, checkpointHash, containerMetadata)
+}
+
+// InsertMetadataContext inserts the metadata, propagating the trace context of ctx
+// to the State Manager.
+func (c *Client) InsertMetadataContext(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string, containerMetadata *entity.ContainerMetadata) error {
+	body, err := json.Marshal(containerMetadata)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, c.baseURL+CheckpointPath(identity, checkpointHash), body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code is %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (c *Client) RetrieveMetadata(identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	return c.RetrieveMetadataContext(context.Background(), identity, checkpointHash)
+}
+
+// RetrieveMetadataContext retrieves the metadata, propagating the trace context of
+// ctx to the State Manager.
+func (c *Client) RetrieveMetadataContext(ctx context.Context, identity entity.ContainerIdentity, checkpointHash string) (*entity.ContainerMetadata, error) {
+	res, err := c.do(ctx, http.MethodGet, c.baseURL+CheckpointPath(identity, checkpointHash), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -77,3 +286,68 @@ func (c *Client) RetrieveMetadata(checkpointHash string) (*entity.ContainerMetad
 
 	return &containerMetadata, nil
 }
+
+// do sends the request, retrying with exponential backoff on network errors and
+// server errors. Client errors are returned on the first attempt since retrying
+// them would not change the outcome. The error of ctx is returned as is once it is
+// done, the caller giving up says nothing about the State Manager.
+func (c *Client) do(ctx context.Context, method string, requestURL string, body []byte) (*http.Response, error) {
+	if !c.breaker.allow() {
+		return nil, fmt.Errorf("%w: circuit breaker is open", ErrUnavailable)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff(c.config.InitialBackoff, c.config.MaxBackoff, attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, requestURL, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		tracing.Inject(ctx, req.Header)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.config.TokenFile != "" {
+			token, err := os.ReadFile(c.config.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+		}
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+
+		if res.StatusCode >= http.StatusInternalServerError {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			lastErr = fmt.Errorf("status code is %d", res.StatusCode)
+			continue
+		}
+
+		c.breaker.success()
+		return res, nil
+	}
+
+	c.breaker.failure()
+	return nil, fmt.Errorf("%w: %v", ErrUnavailable, lastErr)
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GianOrtiz/k8s-transparent-checkpoint-restore/internal/entity"
+)
+
+func testConfig() Config {
+	return Config{
+		Timeout:                 time.Second,
+		MaxRetries:              2,
+		InitialBackoff:          time.Millisecond,
+		MaxBackoff:              time.Millisecond,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+	}
+}
+
+func TestInsertMetadata(t *testing.T) {
+	identity := entity.ContainerIdentity{Namespace: "default", Pod: "app", Container: "test"}
+	t.Run("it should retry server errors until the State Manager answers", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.URL.Path != "/containers/default/app/test/checkpoints/hash" {
+				t.Errorf("expected path %q, got %q\n", "/containers/default/app/test/checkpoints/hash", r.URL.Path)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		c := NewWithConfig(server.URL, testConfig())
+		err := c.InsertMetadata(identity, "hash", &entity.ContainerMetadata{})
+		if err != nil {
+			t.Errorf("expected error nil, received %v\n", err)
+		}
+		if calls != 3 {
+			t.Errorf("expected 3 calls, got %d\n", calls)
+		}
+	})
+
+	t.Run("it should not retry client errors", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		c := NewWithConfig(server.URL, testConfig())
+		err := c.InsertMetadata(identity, "hash", &entity.ContainerMetadata{})
+		if err == nil || errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected a non unavailable error, received %v\n", err)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d\n", calls)
+		}
+	})
+
+	t.Run("it should open the circuit after consecutive failures", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		c := NewWithConfig(server.URL, testConfig())
+		for i := 0; i < 3; i++ {
+			err := c.InsertMetadata(identity, "hash", &entity.ContainerMetadata{})
+			if !errors.Is(err, ErrUnavailable) {
+				t.Errorf("expected unavailable error, received %v\n", err)
+			}
+		}
+		// Two failed requests with three attempts each, the third is refused by the
+		// open circuit without reaching the server.
+		if calls != 6 {
+			t.Errorf("expected 6 calls, got %d\n", calls)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	registration := &entity.InterceptorRegistration{Container: entity.ContainerIdentity{Namespace: "default", Pod: "app", Container: "test"}}
+	t.Run("it should stop retrying once the context is canceled, without opening the circuit", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				cancel()
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		cfg := testConfig()
+		cfg.InitialBackoff = time.Hour
+		cfg.MaxBackoff = time.Hour
+		cfg.CircuitBreakerThreshold = 1
+		c := NewWithConfig(server.URL, cfg)
+
+		done := make(chan error)
+		go func() { done <- c.Register(ctx, registration) }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) || errors.Is(err, ErrUnavailable) {
+				t.Errorf("expected context.Canceled, got %v\n", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the backoff to stop once the context is canceled")
+		}
+
+		if err := c.Register(context.Background(), registration); err != nil {
+			t.Errorf("expected the circuit closed, got %v\n", err)
+		}
+		if calls != 2 {
+			t.Errorf("expected 2 calls, got %d\n", calls)
+		}
+	})
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Run("it should report unregistered interceptors as not found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/containers/default/app/test/heartbeat" {
+				t.Errorf("expected path %q, got %q\n", "/containers/default/app/test/heartbeat", r.URL.Path)
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		c := NewWithConfig(server.URL, testConfig())
+		err := c.Heartbeat(context.Background(), &entity.Heartbeat{
+			InterceptorID: "interceptor",
+			Container:     entity.ContainerIdentity{Namespace: "default", Pod: "app", Container: "test"},
+		})
+		if !errors.Is(err, entity.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, received %v\n", err)
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		wait := backoff(100*time.Millisecond, time.Second, attempt)
+		if wait <= 0 || wait > time.Second {
+			t.Errorf("expected backoff of attempt %d between 0 and 1s, got %v\n", attempt, wait)
+		}
+	}
+}